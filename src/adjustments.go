@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type FareAdjustment struct {
+	ID           int64     `json:"id"`
+	RideID       string    `json:"ride_id"`
+	Currency     string    `json:"currency"`
+	PreviousFare Money     `json:"previous_fare"`
+	NewFare      Money     `json:"new_fare"`
+	Delta        Money     `json:"delta"`
+	Reason       string    `json:"reason"`
+	AdminID      int       `json:"admin_id"`
+	AmountDue    Money     `json:"amount_due,omitempty"`
+	Refunded     Money     `json:"refunded,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+var errFareUnchanged = errors.New("new fare matches the current fare")
+
+func adjustFareHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	var req struct {
+		NewFare *Money `json:"new_fare"` // minor units of the ride's currency
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.NewFare == nil || *req.NewFare < 0 || req.Reason == "" {
+		respondJSON(w, http.StatusBadRequest, errorResponse("new_fare and reason are required"))
+		return
+	}
+
+	adj, err := adjustFare(r.Context(), mux.Vars(r)["id"], *req.NewFare, req.Reason, claims.UserID)
+	if errors.Is(err, errFareUnchanged) {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(adj))
+}
+
+// fareAdjustmentPlan is how a fare change is settled with the rider.
+type fareAdjustmentPlan struct {
+	Charge    Money // taken from the rider's wallet now
+	AmountDue Money // added to what the rider owes on the ride
+	Forgiven  Money // taken off what the rider still owes on the ride
+	Refund    Money // returned to the rider's wallet
+}
+
+// planFareAdjustment settles delta with a rider who has spendable wallet
+// funds and still owes owed on the ride. Increases come out of the wallet
+// first and the rest is owed; reductions first cancel what is still owed,
+// so only money the rider actually paid is refunded.
+func planFareAdjustment(delta, spendable, owed Money) fareAdjustmentPlan {
+	if delta > 0 {
+		charge := max(0, min(delta, spendable))
+		return fareAdjustmentPlan{Charge: charge, AmountDue: delta - charge}
+	}
+	forgiven := max(0, min(-delta, owed))
+	return fareAdjustmentPlan{Forgiven: forgiven, Refund: -delta - forgiven}
+}
+
+// adjustFare changes the final fare of a completed ride and books the
+// difference as planFareAdjustment decides. Whatever the rider still owes
+// becomes a due on the ride. The driver's earnings move by the difference
+// less commission, and their balance only by the part the rider has paid.
+func adjustFare(ctx context.Context, rideID string, newFare Money, reason string, adminID int) (*FareAdjustment, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	if ride.Status != rideStatusCompleted {
+		return nil, errInvalidRideTransition
+	}
+
+	adj := &FareAdjustment{
+		RideID:       ride.ID,
+		Currency:     ride.Currency,
+		PreviousFare: ride.FinalFare,
+		NewFare:      newFare,
+		Delta:        newFare - ride.FinalFare,
+		Reason:       reason,
+		AdminID:      adminID,
+	}
+	if adj.Delta == 0 {
+		return nil, errFareUnchanged
+	}
+
+	var spendable Money
+	if adj.Delta > 0 && ride.PaymentMethod == paymentMethodWallet {
+		if spendable, err = walletSpendable(ctx, tx, ride.RiderID, ride.Currency); err != nil {
+			return nil, err
+		}
+	}
+	plan := planFareAdjustment(adj.Delta, spendable, ride.AmountDue)
+	adj.AmountDue = plan.AmountDue
+	adj.Refunded = plan.Refund
+
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO fare_adjustments (ride_id, previous_fare, new_fare, delta, currency, reason, admin_id, amount_due, refunded)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 RETURNING id, created_at`,
+		adj.RideID, adj.PreviousFare, adj.NewFare, adj.Delta, adj.Currency, adj.Reason, adj.AdminID,
+		adj.AmountDue, adj.Refunded).Scan(&adj.ID, &adj.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to record fare adjustment: %w", err)
+	}
+	reference := fmt.Sprintf("adjustment-%d", adj.ID)
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE rides SET final_fare = $1, updated_at = NOW() WHERE id = $2`,
+		adj.NewFare, ride.ID); err != nil {
+		return nil, fmt.Errorf("failed to update ride fare: %w", err)
+	}
+
+	if plan.Charge > 0 {
+		if err := debitWallet(ctx, tx, ride.RiderID, plan.Charge, ride.Currency, "fare_adjustment", ride.ID, reference); err != nil {
+			return nil, err
+		}
+	}
+	if plan.Refund > 0 {
+		if err := creditWallet(ctx, tx, ride.RiderID, plan.Refund, ride.Currency, "fare_adjustment", ride.ID, reference); err != nil {
+			return nil, err
+		}
+	}
+
+	// The driver's share of anything still owed stays withheld on the dues.
+	net := adj.Delta - calculateCommission(adj.Delta)
+	credit := net
+	if plan.Forgiven > 0 {
+		released, err := reduceRiderDues(ctx, tx, ride, plan.Forgiven)
+		if err != nil {
+			return nil, err
+		}
+		credit += released
+	}
+	if plan.AmountDue > 0 {
+		withheld := max(0, min(plan.AmountDue, net))
+		if _, err := addRiderDue(ctx, tx, ride, dueKindFareAdjustment, plan.AmountDue, withheld, 0); err != nil {
+			return nil, err
+		}
+		credit -= withheld
+	}
+
+	if err := adjustDriverShare(ctx, tx, ride, adj.Delta, credit, reference); err != nil {
+		return nil, err
+	}
+	if _, err := issueReceipt(ctx, tx, ride.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return adj, nil
+}
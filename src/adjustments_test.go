@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestPlanFareAdjustment(t *testing.T) {
+	cases := []struct {
+		name                   string
+		delta, spendable, owed Money
+		want                   fareAdjustmentPlan
+	}{
+		{"increase covered by the wallet", 2000, 5000, 0, fareAdjustmentPlan{Charge: 2000}},
+		{"increase partly covered by the wallet", 2000, 500, 0, fareAdjustmentPlan{Charge: 500, AmountDue: 1500}},
+		{"increase without a wallet", 2000, 0, 0, fareAdjustmentPlan{AmountDue: 2000}},
+		{"reduction of a paid fare", -2000, 0, 0, fareAdjustmentPlan{Refund: 2000}},
+		{"reduction within what is owed", -2000, 0, 3000, fareAdjustmentPlan{Forgiven: 2000}},
+		{"reduction beyond what is owed", -2000, 0, 500, fareAdjustmentPlan{Forgiven: 500, Refund: 1500}},
+	}
+	for _, c := range cases {
+		if got := planFareAdjustment(c.delta, c.spendable, c.owed); got != c.want {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// AlertSink pages on-call operations staff about a safety incident.
+type AlertSink interface {
+	Name() string
+	SendAlert(incident *Incident) error
+}
+
+// SMSSender delivers text messages, e.g. to a user's trusted contacts.
+type SMSSender interface {
+	Name() string
+	SendSMS(phone, message string) error
+}
+
+var alertSinks = map[string]AlertSink{
+	"log":     logAlertSink{},
+	"webhook": webhookAlertSink{},
+}
+
+// getAlertSink returns the sink named by ALERT_SINK, defaulting to the log.
+func getAlertSink() (AlertSink, error) {
+	name := os.Getenv("ALERT_SINK")
+	if name == "" {
+		name = "log"
+	}
+	sink, ok := alertSinks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown alert sink: %s", name)
+	}
+	return sink, nil
+}
+
+var smsSenders = map[string]SMSSender{
+	"log": logSMSSender{},
+}
+
+// getSMSSender returns the sender named by SMS_PROVIDER, defaulting to the log.
+func getSMSSender() (SMSSender, error) {
+	name := os.Getenv("SMS_PROVIDER")
+	if name == "" {
+		name = "log"
+	}
+	sender, ok := smsSenders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown SMS provider: %s", name)
+	}
+	return sender, nil
+}
+
+type logAlertSink struct{}
+
+func (logAlertSink) Name() string { return "log" }
+
+func (logAlertSink) SendAlert(incident *Incident) error {
+	log.Printf("SOS incident %d on ride %s reported by %s %s at (%v, %v)",
+		incident.ID, incident.RideID, incident.ReportedBy, incident.Reporter, incident.Lat, incident.Lng)
+	return nil
+}
+
+// webhookAlertSink posts the incident as JSON to OPS_ALERT_WEBHOOK_URL, which
+// can point at a paging service or a chat integration.
+type webhookAlertSink struct{}
+
+func (webhookAlertSink) Name() string { return "webhook" }
+
+func (webhookAlertSink) SendAlert(incident *Incident) error {
+	url := os.Getenv("OPS_ALERT_WEBHOOK_URL")
+	if url == "" {
+		return errors.New("OPS_ALERT_WEBHOOK_URL not configured")
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"type":     "sos",
+		"incident": incident,
+	})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+type logSMSSender struct{}
+
+func (logSMSSender) Name() string { return "log" }
+
+func (logSMSSender) SendSMS(phone, message string) error {
+	log.Printf("SMS to %s: %s", phone, message)
+	return nil
+}
@@ -2,85 +2,155 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
 )
 
-type GeoLocationAPIResponse struct {
-	Lat     float64 `json:"lat"`
-	Lng     float64 `json:"lng"`
-	Address string  `json:"address"`
+// DriverLocation is a driver's last reported position. Stale locations are
+// still returned so clients can show them greyed out.
+type DriverLocation struct {
+	DriverID   string    `json:"driver_id"`
+	Lat        float64   `json:"lat"`
+	Lng        float64   `json:"lng"`
+	Address    string    `json:"address,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	AgeSeconds int64     `json:"age_seconds"`
+	Stale      bool      `json:"stale"`
 }
 
-const locationCacheTTL = 5 * time.Minute
+const (
+	driverLocationUpdatedKey        = "drivers:updated_at"
+	defaultDriverLocationStale      = 2 * time.Minute
+	driverLocationRequestsPerMinute = 60
+)
+
+var errDriverLocationUnknown = errors.New("driver location unknown")
 
-func FetchDriverLocation(driverID string) (*GeoLocationAPIResponse, error) {
-	cachedLoc, err := getCachedLocation(driverID)
-	if err == nil && cachedLoc != nil {
-		return cachedLoc, nil
+// FetchDriverLocation reads the live location store in Redis, falling back
+// to the position last written to the drivers table. The address is only
+// filled in from the geocode cache; a miss warms the cache for next time.
+func FetchDriverLocation(ctx context.Context, driverID string) (*DriverLocation, error) {
+	loc := &DriverLocation{DriverID: driverID}
+
+	pos, err := redisClient.GeoPos(ctx, "drivers", driverID).Result()
+	updated, scoreErr := redisClient.ZScore(ctx, driverLocationUpdatedKey, driverID).Result()
+	if err == nil && scoreErr == nil && len(pos) > 0 && pos[0] != nil {
+		loc.Lat, loc.Lng = pos[0].Latitude, pos[0].Longitude
+		loc.UpdatedAt = time.UnixMilli(int64(updated))
+	} else {
+		err := dbPool.QueryRow(ctx,
+			`SELECT ST_Y(current_location::geometry), ST_X(current_location::geometry), last_updated
+			 FROM drivers WHERE driver_id = $1`,
+			driverID).Scan(&loc.Lat, &loc.Lng, &loc.UpdatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errDriverLocationUnknown
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load driver location: %w", err)
+		}
 	}
 
-	if isRateLimited("geo-api") {
-		return nil, errors.New("API rate limit exceeded")
+	loc.setAge(time.Now(), envDuration("DRIVER_LOCATION_STALE_AFTER", defaultDriverLocationStale))
+
+	if address, ok := getCachedLocation(ctx, loc.Lat, loc.Lng); ok {
+		loc.Address = address
+	} else {
+		go reverseGeocode(context.Background(), loc.Lat, loc.Lng)
 	}
+	return loc, nil
+}
 
-	// Only use mock data (OpenStreetMap would be called via ReverseGeocode in caching.go)
-	return mockDriverLocation(driverID)
+// setAge fills in the freshness metadata as of now. Clock skew can put a
+// report slightly in the future, which counts as fresh.
+func (loc *DriverLocation) setAge(now time.Time, staleAfter time.Duration) {
+	age := max(now.Sub(loc.UpdatedAt), 0)
+	loc.AgeSeconds = int64(age.Seconds())
+	loc.Stale = age > staleAfter
 }
 
-func isRateLimited(resource string) bool {
-	ctx := context.Background()
-	key := fmt.Sprintf("rate_limit:%s", resource)
-	
+// isRateLimited counts a caller's requests to a resource in fixed windows.
+// Each caller has its own counter.
+func isRateLimited(ctx context.Context, resource, caller string, limit int, window time.Duration) bool {
+	key := fmt.Sprintf("rate_limit:%s:%s:%d", resource, caller, time.Now().Unix()/int64(window.Seconds()))
+
 	count, err := redisClient.Incr(ctx, key).Result()
 	if err != nil {
 		return true
 	}
-	
+
 	if count == 1 {
-		redisClient.Expire(ctx, key, time.Hour)
+		redisClient.Expire(ctx, key, window)
 	}
-	
-	return count > 100
+
+	return count > int64(limit)
 }
 
-func cacheLocation(driverID string, loc *GeoLocationAPIResponse) error {
-	ctx := context.Background()
-	data, err := json.Marshal(loc)
+// getCachedLocation returns the reverse-geocoded address for a point if it
+// is already in the geocode cache, without calling the geocoder.
+func getCachedLocation(ctx context.Context, lat, lng float64) (string, bool) {
+	address, err := redisClient.Get(ctx, geocodeCacheKey(lat, lng)).Result()
 	if err != nil {
-		return err
+		return "", false
 	}
-	
-	return redisClient.SetEx(ctx, fmt.Sprintf("driver_loc:%s", driverID), data, locationCacheTTL).Err()
+	return address, true
 }
 
-func getCachedLocation(driverID string) (*GeoLocationAPIResponse, error) {
-	ctx := context.Background()
-	data, err := redisClient.Get(ctx, fmt.Sprintf("driver_loc:%s", driverID)).Bytes()
-	if err != nil {
-		return nil, err
-	}
-	
-	var loc GeoLocationAPIResponse
-	if err := json.Unmarshal(data, &loc); err != nil {
-		return nil, err
+// driverLocationHandler returns a driver's location to the rider of a ride
+// the driver is currently assigned to, or to an admin.
+func driverLocationHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
 	}
-	return &loc, nil
-}
+	driverID := mux.Vars(r)["id"]
+	ctx := r.Context()
 
-func mockDriverLocation(driverID string) (*GeoLocationAPIResponse, error) {
-	time.Sleep(100 * time.Millisecond) // Simulate API delay
+	if claims.Role != "admin" {
+		var assigned bool
+		if err := dbPool.QueryRow(ctx,
+			`SELECT EXISTS (
+			     SELECT 1 FROM rides
+			     WHERE driver_id = $1 AND rider_id = $2 AND status IN ('accepted', 'arrived', 'in_progress'))`,
+			driverID, claims.UserID).Scan(&assigned); err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+			return
+		}
+		if !assigned {
+			respondJSON(w, http.StatusForbidden, errorResponse("driver is not assigned to your ride"))
+			return
+		}
+	}
 
-	locations := map[string]GeoLocationAPIResponse{
-		"driver1": {Lat: 0.3135, Lng: 32.5811, Address: "Kampala Road"},
-		"driver2": {Lat: 0.3167, Lng: 32.5825, Address: "Nakasero"},
-		"driver3": {Lat: 0.3150, Lng: 32.5800, Address: "Kololo"},
+	if isRateLimited(ctx, "driver_location", fmt.Sprint(claims.UserID), driverLocationRequestsPerMinute, time.Minute) {
+		respondJSON(w, http.StatusTooManyRequests, errorResponse("rate limit exceeded"))
+		return
 	}
 
-	if loc, ok := locations[driverID]; ok {
-		return &loc, nil
+	loc, err := FetchDriverLocation(ctx, driverID)
+	if errors.Is(err, errDriverLocationUnknown) {
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+		return
 	}
-	return nil, fmt.Errorf("driver not found")
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(loc))
+}
+
+// recordDriverLocation updates the live location store: the geo index behind
+// FindNearbyDrivers and live trip views, and when each driver last reported.
+func recordDriverLocation(ctx context.Context, driverID string, lat, lng float64, at time.Time) error {
+	pipe := redisClient.TxPipeline()
+	pipe.GeoAdd(ctx, "drivers", &redis.GeoLocation{Name: driverID, Longitude: lng, Latitude: lat})
+	pipe.ZAdd(ctx, driverLocationUpdatedKey, redis.Z{Score: float64(at.UnixMilli()), Member: driverID})
+	_, err := pipe.Exec(ctx)
+	return err
 }
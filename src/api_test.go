@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDriverLocationAge(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		updated time.Time
+		age     int64
+		stale   bool
+	}{
+		{now.Add(-30 * time.Second), 30, false},
+		{now.Add(-3 * time.Minute), 180, true},
+		// A report from a driver whose clock runs ahead is fresh.
+		{now.Add(5 * time.Second), 0, false},
+	}
+	for _, c := range cases {
+		loc := &DriverLocation{UpdatedAt: c.updated}
+		loc.setAge(now, defaultDriverLocationStale)
+		if loc.AgeSeconds != c.age || loc.Stale != c.stale {
+			t.Errorf("updated %s ago: got age %d stale %v, want %d %v",
+				now.Sub(c.updated), loc.AgeSeconds, loc.Stale, c.age, c.stale)
+		}
+	}
+}
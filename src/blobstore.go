@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore keeps uploaded files such as driver documents. Keys are
+// slash-separated paths chosen by the caller.
+type BlobStore interface {
+	Name() string
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var errBlobNotFound = errors.New("blob not found")
+
+var blobStores = map[string]BlobStore{
+	"local": localBlobStore{},
+}
+
+// getBlobStore returns the store named by BLOB_STORE, defaulting to the
+// local filesystem.
+func getBlobStore() (BlobStore, error) {
+	name := os.Getenv("BLOB_STORE")
+	if name == "" {
+		name = "local"
+	}
+	store, ok := blobStores[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown blob store: %s", name)
+	}
+	return store, nil
+}
+
+// localBlobStore keeps blobs as files under BLOB_DIR.
+type localBlobStore struct{}
+
+func (localBlobStore) Name() string { return "local" }
+
+func (localBlobStore) path(key string) (string, error) {
+	root := os.Getenv("BLOB_DIR")
+	if root == "" {
+		root = "data/blobs"
+	}
+	root = filepath.Clean(root)
+	p := filepath.Join(root, filepath.FromSlash(key))
+	if key == "" || !strings.HasPrefix(p, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key: %q", key)
+	}
+	return p, nil
+}
+
+// Put writes to a temporary file first so a failed upload never replaces
+// an existing blob.
+func (s localBlobStore) Put(ctx context.Context, key string, r io.Reader) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s localBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return f, err
+}
+
+func (s localBlobStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -2,9 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
 	"time"
 	"log"
@@ -50,13 +48,7 @@ func InitRedis() error {
 }
 
 func CacheDriverLocation(driverID string, lat, lng float64) error {
-	ctx := context.Background()
-	geoLocation := &redis.GeoLocation{
-		Name:      driverID,
-		Longitude: lng,
-		Latitude:  lat,
-	}
-	return redisClient.GeoAdd(ctx, "drivers", geoLocation).Err()
+	return recordDriverLocation(context.Background(), driverID, lat, lng, time.Now())
 }
 
 func FindNearbyDrivers(lat, lng, radius float64) ([]string, error) {
@@ -80,25 +72,3 @@ func FindNearbyDrivers(lat, lng, radius float64) ([]string, error) {
 	}
 	return driverIDs, nil
 }
-
-// OpenStreetMap implementation
-func ReverseGeocode(lat, lng float64) (string, error) {
-	url := fmt.Sprintf("https://nominatim.openstreetmap.org/reverse?format=json&lat=%f&lon=%f", lat, lng)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		DisplayName string `json:"display_name"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
-
-	if result.DisplayName == "" {
-		return fmt.Sprintf("Near %.4f, %.4f", lat, lng), nil
-	}
-	return result.DisplayName, nil
-}
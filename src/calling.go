@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// MaskedSession lets a ride's rider and driver call each other through a
+// proxy number. Each dials the proxy and is connected to the other, so
+// neither sees the other's real number.
+type MaskedSession struct {
+	ProxyNumber string    `json:"proxy_number"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// ProxyNumber is a number in the masking pool. Sessions is how many masked
+// sessions are currently using it.
+type ProxyNumber struct {
+	Number    string    `json:"number"`
+	Active    bool      `json:"active"`
+	Sessions  int       `json:"sessions"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	defaultMaskedCallTTL   = 4 * time.Hour
+	defaultMaskedCallAfter = 30 * time.Minute
+	defaultPhoneCountry    = "256"
+	maskedCallExpiryPeriod = time.Minute
+	// Serialises proxy allocation so two sessions sharing a phone number
+	// never get the same proxy.
+	maskedCallLockKey = 0x6d61736b
+)
+
+var (
+	errCallNotAvailable = errors.New("calling is not available for this ride")
+	errNoPhoneNumber    = errors.New("no phone number on file")
+	errNoProxyNumber    = errors.New("no proxy number available, try again shortly")
+)
+
+// normalizePhone converts a number to E.164. Local numbers starting with 0
+// take the PHONE_COUNTRY_CODE (default 256).
+func normalizePhone(s string) (string, error) {
+	s = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(strings.TrimSpace(s))
+	switch {
+	case strings.HasPrefix(s, "+"):
+	case strings.HasPrefix(s, "00"):
+		s = "+" + s[2:]
+	case strings.HasPrefix(s, "0"):
+		country := os.Getenv("PHONE_COUNTRY_CODE")
+		if country == "" {
+			country = defaultPhoneCountry
+		}
+		s = "+" + strings.TrimPrefix(country, "+") + s[1:]
+	default:
+		s = "+" + s
+	}
+	if !phonePattern.MatchString(s) {
+		return "", fmt.Errorf("invalid phone number: %s", s)
+	}
+	return s, nil
+}
+
+// maskedSessionExpiry says until when the rider and driver of a ride may
+// call each other, starting at now. Calls are allowed from acceptance while
+// the ride runs, and for closeAfter after it ends, e.g. for lost items.
+func maskedSessionExpiry(ride *RideStatus, now time.Time, ttl, closeAfter time.Duration) (time.Time, error) {
+	if ride.AcceptedAt == nil {
+		return time.Time{}, errCallNotAvailable
+	}
+	switch ride.Status {
+	case rideStatusAccepted, rideStatusArrived, rideStatusInProgress:
+		return now.Add(ttl), nil
+	case rideStatusCompleted, rideStatusCancelled:
+		ended := ride.CompletedAt
+		if ended == nil {
+			ended = ride.CancelledAt
+		}
+		if ended != nil && now.Before(ended.Add(closeAfter)) {
+			return ended.Add(closeAfter), nil
+		}
+	}
+	return time.Time{}, errCallNotAvailable
+}
+
+// callParticipantPhones returns the rider's and the driver's numbers.
+func callParticipantPhones(ctx context.Context, q queryRower, ride *RideStatus) (string, string, error) {
+	var riderPhone, driverPhone *string
+	if err := q.QueryRow(ctx,
+		`SELECT (SELECT phone FROM rider_profiles WHERE rider_id = $1),
+		        (SELECT phone FROM driver_applications WHERE driver_id = $2)`,
+		ride.RiderID, ride.DriverID).Scan(&riderPhone, &driverPhone); err != nil {
+		return "", "", fmt.Errorf("failed to load phone numbers: %w", err)
+	}
+	if riderPhone == nil {
+		return "", "", fmt.Errorf("rider has %w", errNoPhoneNumber)
+	}
+	if driverPhone == nil {
+		return "", "", fmt.Errorf("driver has %w", errNoPhoneNumber)
+	}
+	rider, err := normalizePhone(*riderPhone)
+	if err != nil {
+		return "", "", fmt.Errorf("rider has %w", errNoPhoneNumber)
+	}
+	driver, err := normalizePhone(*driverPhone)
+	if err != nil {
+		return "", "", fmt.Errorf("driver has %w", errNoPhoneNumber)
+	}
+	if rider == driver {
+		return "", "", errCallNotAvailable
+	}
+	return rider, driver, nil
+}
+
+// openMaskedSession returns the ride's masked session, opening one if none
+// is live. A proxy number can serve many sessions at once, as long as no
+// two of them share a phone number: an inbound call is resolved by the
+// proxy dialled and the caller's number together.
+func openMaskedSession(ctx context.Context, rideID string) (*MaskedSession, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := maskedSessionExpiry(ride, time.Now(),
+		envDuration("MASKED_CALL_TTL", defaultMaskedCallTTL),
+		envDuration("MASKED_CALL_AFTER", defaultMaskedCallAfter))
+	if err != nil {
+		return nil, err
+	}
+	riderPhone, driverPhone, err := callParticipantPhones(ctx, tx, ride)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &MaskedSession{ExpiresAt: expiresAt}
+	err = tx.QueryRow(ctx,
+		`UPDATE masked_sessions SET expires_at = $4
+		 WHERE ride_id = $1 AND rider_phone = $2 AND driver_phone = $3 AND expires_at > NOW()
+		 RETURNING proxy_number`,
+		ride.ID, riderPhone, driverPhone, expiresAt).Scan(&session.ProxyNumber)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to extend masked session: %w", err)
+	}
+	if err == nil {
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return session, nil
+	}
+
+	// A number changed since the last session; it must not keep working.
+	if _, err := tx.Exec(ctx,
+		`UPDATE masked_sessions SET expires_at = NOW() WHERE ride_id = $1 AND expires_at > NOW()`,
+		ride.ID); err != nil {
+		return nil, fmt.Errorf("failed to end masked session: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, maskedCallLockKey); err != nil {
+		return nil, fmt.Errorf("failed to lock proxy pool: %w", err)
+	}
+	err = tx.QueryRow(ctx,
+		`SELECT p.number
+		 FROM proxy_numbers p
+		 LEFT JOIN masked_sessions s ON s.proxy_number = p.number AND s.expires_at > NOW()
+		 WHERE p.active
+		 GROUP BY p.number
+		 HAVING COUNT(*) FILTER (WHERE s.rider_phone IN ($1, $2) OR s.driver_phone IN ($1, $2)) = 0
+		 ORDER BY COUNT(s.id), p.number
+		 LIMIT 1`,
+		riderPhone, driverPhone).Scan(&session.ProxyNumber)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNoProxyNumber
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate proxy number: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO masked_sessions (ride_id, proxy_number, rider_phone, driver_phone, expires_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		ride.ID, session.ProxyNumber, riderPhone, driverPhone, expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to create masked session: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return session, nil
+}
+
+// rideCallHandler gives the rider or the driver the number to dial to reach
+// the other.
+func rideCallHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	ctx := r.Context()
+	rideID := mux.Vars(r)["id"]
+
+	var riderID int
+	var driverID string
+	err := dbPool.QueryRow(ctx,
+		`SELECT rider_id, driver_id FROM rides WHERE id = $1`,
+		rideID).Scan(&riderID, &driverID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondRideError(w, errRideNotFound)
+		return
+	}
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+	isDriver := claims.Role == "driver" && claims.Username == driverID
+	isRider := claims.Role != "driver" && claims.UserID == riderID
+	if !isDriver && !isRider {
+		respondJSON(w, http.StatusForbidden, errorResponse("only the ride's rider and driver can call each other"))
+		return
+	}
+
+	session, err := openMaskedSession(ctx, rideID)
+	switch {
+	case errors.Is(err, errCallNotAvailable), errors.Is(err, errNoPhoneNumber):
+		respondJSON(w, http.StatusConflict, errorResponse(err.Error()))
+	case errors.Is(err, errNoProxyNumber):
+		respondJSON(w, http.StatusServiceUnavailable, errorResponse(err.Error()))
+	case err != nil:
+		log.Printf("Failed to open masked session for ride %s: %v", rideID, err)
+		respondRideError(w, err)
+	default:
+		respondJSON(w, http.StatusOK, successResponse(session))
+	}
+}
+
+// inboundCallHandler is the provider's webhook for calls to proxy numbers.
+// The caller is connected to the other party of the live session on that
+// proxy that includes the caller's number.
+func inboundCallHandler(w http.ResponseWriter, r *http.Request) {
+	provider, err := getTelephonyProvider()
+	if err != nil {
+		log.Printf("Inbound call: %v", err)
+		respondJSON(w, http.StatusServiceUnavailable, errorResponse("telephony not configured"))
+		return
+	}
+	call, err := provider.ParseInboundCall(r)
+	if errors.Is(err, errInboundCallUnauthorised) {
+		respondJSON(w, http.StatusUnauthorized, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	from, errFrom := normalizePhone(call.From)
+	proxy, errTo := normalizePhone(call.To)
+	if errFrom != nil || errTo != nil {
+		log.Printf("Inbound call %s from %q to %q rejected: unrecognised number", call.RefID, call.From, call.To)
+		provider.Reject(w, call, "This number is not in service.")
+		return
+	}
+
+	var rideID, callerRole, target string
+	err = dbPool.QueryRow(r.Context(),
+		`SELECT ride_id,
+		        CASE WHEN rider_phone = $2 THEN 'rider' ELSE 'driver' END,
+		        CASE WHEN rider_phone = $2 THEN driver_phone ELSE rider_phone END
+		 FROM masked_sessions
+		 WHERE proxy_number = $1 AND expires_at > NOW() AND (rider_phone = $2 OR driver_phone = $2)
+		 ORDER BY created_at DESC
+		 LIMIT 1`,
+		proxy, from).Scan(&rideID, &callerRole, &target)
+	if errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Inbound call %s to %s rejected: no live session", call.RefID, proxy)
+		provider.Reject(w, call, "This number is not in service.")
+		return
+	}
+	if err != nil {
+		log.Printf("Inbound call %s to %s failed: %v", call.RefID, proxy, err)
+		provider.Reject(w, call, "We could not connect your call. Please try again.")
+		return
+	}
+
+	log.Printf("Inbound call %s on ride %s from the %s bridged through %s", call.RefID, rideID, callerRole, proxy)
+	provider.Bridge(w, call, target)
+}
+
+// updateRiderPhoneHandler sets the number a rider is reached on through
+// masked calls.
+func updateRiderPhoneHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		Phone string `json:"phone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	phone, err := normalizePhone(req.Phone)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("a valid phone number is required"))
+		return
+	}
+
+	if _, err := dbPool.Exec(r.Context(),
+		`INSERT INTO rider_profiles (rider_id, phone) VALUES ($1, $2)
+		 ON CONFLICT (rider_id) DO UPDATE SET phone = EXCLUDED.phone, updated_at = NOW()`,
+		claims.UserID, phone); err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(map[string]string{"phone": phone}))
+}
+
+func listProxyNumbersHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT p.number, p.active, COUNT(s.id), p.created_at
+		 FROM proxy_numbers p
+		 LEFT JOIN masked_sessions s ON s.proxy_number = p.number AND s.expires_at > NOW()
+		 GROUP BY p.number
+		 ORDER BY p.number`)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	numbers, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ProxyNumber, error) {
+		var p ProxyNumber
+		err := row.Scan(&p.Number, &p.Active, &p.Sessions, &p.CreatedAt)
+		return p, err
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+		return
+	}
+	if numbers == nil {
+		numbers = []ProxyNumber{}
+	}
+	respondJSON(w, http.StatusOK, successResponse(numbers))
+}
+
+// addProxyNumbersHandler adds numbers to the pool, or puts retired ones
+// back into service.
+func addProxyNumbersHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	var req struct {
+		Numbers []string `json:"numbers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Numbers) == 0 {
+		respondJSON(w, http.StatusBadRequest, errorResponse("numbers are required"))
+		return
+	}
+	numbers := make([]string, len(req.Numbers))
+	for i, n := range req.Numbers {
+		number, err := normalizePhone(n)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+			return
+		}
+		numbers[i] = number
+	}
+
+	if _, err := dbPool.Exec(r.Context(),
+		`INSERT INTO proxy_numbers (number) SELECT unnest($1::text[])
+		 ON CONFLICT (number) DO UPDATE SET active = true`,
+		numbers); err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	log.Printf("Admin %d added proxy numbers %v", claims.UserID, numbers)
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{"numbers": numbers}))
+}
+
+// retireProxyNumberHandler takes a number out of the pool. Sessions already
+// on it keep working until they expire.
+func retireProxyNumberHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	number, err := normalizePhone(mux.Vars(r)["number"])
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	tag, err := dbPool.Exec(r.Context(),
+		`UPDATE proxy_numbers SET active = false WHERE number = $1`, number)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		respondJSON(w, http.StatusNotFound, errorResponse("proxy number not found"))
+		return
+	}
+	log.Printf("Admin %d retired proxy number %s", claims.UserID, number)
+	respondJSON(w, http.StatusOK, successResponse(map[string]string{"number": number}))
+}
+
+// startMaskedCallExpiry cuts masked sessions short once their ride has been
+// over for MASKED_CALL_AFTER, freeing their numbers for reuse.
+func startMaskedCallExpiry() {
+	go func() {
+		ticker := time.NewTicker(maskedCallExpiryPeriod)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := expireMaskedSessions(context.Background()); err != nil {
+				log.Printf("Masked call expiry failed: %v", err)
+			}
+		}
+	}()
+}
+
+func expireMaskedSessions(ctx context.Context) error {
+	closeAfter := envDuration("MASKED_CALL_AFTER", defaultMaskedCallAfter)
+	_, err := dbPool.Exec(ctx,
+		`UPDATE masked_sessions s
+		 SET expires_at = COALESCE(r.completed_at, r.cancelled_at, r.updated_at) + make_interval(secs => $1)
+		 FROM rides r
+		 WHERE r.id = s.ride_id AND r.status IN ('completed', 'cancelled')
+		   AND s.expires_at > COALESCE(r.completed_at, r.cancelled_at, r.updated_at) + make_interval(secs => $1)`,
+		closeAfter.Seconds())
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	t.Setenv("PHONE_COUNTRY_CODE", "")
+	for in, want := range map[string]string{
+		"+256 772 123 456": "+256772123456",
+		"0772-123-456":     "+256772123456",
+		"00256772123456":   "+256772123456",
+		"256772123456":     "+256772123456",
+	} {
+		if got, err := normalizePhone(in); err != nil || got != want {
+			t.Errorf("normalizePhone(%q) = %q (%v), want %q", in, got, err, want)
+		}
+	}
+
+	t.Setenv("PHONE_COUNTRY_CODE", "+254")
+	if got, _ := normalizePhone("0712345678"); got != "+254712345678" {
+		t.Errorf("Expected the configured country code, got %q", got)
+	}
+
+	for _, bad := range []string{"", "12345", "+256-abc-123", "+2567721234567890"} {
+		if _, err := normalizePhone(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestMaskedSessionExpiry(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	accepted := now.Add(-20 * time.Minute)
+	endedRecently := now.Add(-10 * time.Minute)
+	endedLongAgo := now.Add(-time.Hour)
+	ttl, closeAfter := 4*time.Hour, 30*time.Minute
+
+	got, err := maskedSessionExpiry(&RideStatus{Status: rideStatusInProgress, AcceptedAt: &accepted}, now, ttl, closeAfter)
+	if err != nil || !got.Equal(now.Add(ttl)) {
+		t.Errorf("Expected a running ride to allow calls for the TTL, got %v (%v)", got, err)
+	}
+	got, err = maskedSessionExpiry(&RideStatus{Status: rideStatusCompleted, AcceptedAt: &accepted, CompletedAt: &endedRecently}, now, ttl, closeAfter)
+	if err != nil || !got.Equal(endedRecently.Add(closeAfter)) {
+		t.Errorf("Expected calls until %v after the ride, got %v (%v)", closeAfter, got, err)
+	}
+
+	for name, ride := range map[string]*RideStatus{
+		"requested":           {Status: rideStatusRequested},
+		"cancelled unmatched": {Status: rideStatusCancelled, CancelledAt: &endedRecently},
+		"completed long ago":  {Status: rideStatusCompleted, AcceptedAt: &accepted, CompletedAt: &endedLongAgo},
+	} {
+		if _, err := maskedSessionExpiry(ride, now, ttl, closeAfter); err != errCallNotAvailable {
+			t.Errorf("%s: expected calling to be unavailable, got %v", name, err)
+		}
+	}
+}
+
+func TestLocalTelephony(t *testing.T) {
+	t.Setenv("TELEPHONY_WEBHOOK_SECRET", "s3cret")
+	p := localTelephony{}
+
+	req := httptest.NewRequest("POST", "/calls/inbound", strings.NewReader(`{"from":"+256772123456","to":"+256200900100"}`))
+	if _, err := p.ParseInboundCall(req); err != errInboundCallUnauthorised {
+		t.Errorf("Expected a call without the secret to be refused, got %v", err)
+	}
+
+	req = httptest.NewRequest("POST", "/calls/inbound", strings.NewReader(`{"from":"+256772123456","to":"+256200900100"}`))
+	req.Header.Set("X-Telephony-Secret", "s3cret")
+	call, err := p.ParseInboundCall(req)
+	if err != nil || call.From != "+256772123456" || call.To != "+256200900100" {
+		t.Fatalf("Expected the call to parse, got %+v (%v)", call, err)
+	}
+
+	w := httptest.NewRecorder()
+	p.Bridge(w, call, "+256701000111")
+	var answer map[string]string
+	json.NewDecoder(w.Body).Decode(&answer)
+	if answer["action"] != "bridge" || answer["to"] != "+256701000111" || answer["caller_id"] != call.To {
+		t.Errorf("Expected a bridge showing the proxy number, got %v", answer)
+	}
+}
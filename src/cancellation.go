@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// CancellationPolicy decides who pays when a ride is cancelled. Riders cancel
+// for free until a driver accepts or within FreeWindow of requesting; after
+// that they pay RiderFee, which goes to the driver less commission. Drivers
+// who cancel a ride they accepted pay DriverPenalty to the platform, unless
+// they had already waited NoShowAfter at pickup, in which case the rider is
+// treated as a no-show and pays RiderFee instead.
+type CancellationPolicy struct {
+	FreeWindow    time.Duration `json:"free_window"`
+	NoShowAfter   time.Duration `json:"no_show_after"`
+	RiderFee      Money         `json:"rider_fee"`
+	DriverPenalty Money         `json:"driver_penalty"`
+}
+
+type Cancellation struct {
+	RideID      string    `json:"ride_id"`
+	CancelledBy string    `json:"cancelled_by"`
+	Reason      string    `json:"reason,omitempty"`
+	Policy      string    `json:"policy"`
+	Fee         Money     `json:"fee"`
+	Penalty     Money     `json:"penalty"`
+	Currency    string    `json:"currency"`
+	FeeStatus   string    `json:"fee_status"` // none, paid or pending
+	DueID       int64     `json:"due_id,omitempty"`
+	TxRef       string    `json:"tx_ref,omitempty"`
+	PaymentLink string    `json:"payment_link,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const (
+	defaultCancelFreeWindow = 2 * time.Minute
+
+	cancelledByRider  = "rider"
+	cancelledByDriver = "driver"
+)
+
+// Fees are in minor units, so each currency has its own amounts.
+var cancellationPolicies = map[string]CancellationPolicy{
+	"UGX": {RiderFee: 2000, DriverPenalty: 1000},
+	"KES": {RiderFee: 10000, DriverPenalty: 5000},
+}
+
+func cancellationPolicyFor(currency string) CancellationPolicy {
+	policy := cancellationPolicies[currency]
+	policy.FreeWindow = envDuration("CANCEL_FREE_WINDOW", defaultCancelFreeWindow)
+	policy.NoShowAfter = freeWaitingWindow()
+	return policy
+}
+
+// evaluateCancellation applies policy to a ride being cancelled at now and
+// returns the rider fee, the driver penalty and a short explanation.
+func evaluateCancellation(ride *RideStatus, by string, policy CancellationPolicy, now time.Time) (fee, penalty Money, reason string, err error) {
+	switch ride.Status {
+	case rideStatusRequested, rideStatusAccepted, rideStatusArrived:
+	default:
+		return 0, 0, "", errInvalidRideTransition
+	}
+
+	if by == cancelledByDriver {
+		switch {
+		case ride.Status == rideStatusRequested:
+			return 0, 0, "declined before accepting", nil
+		case ride.Status == rideStatusArrived && ride.ArrivedAt != nil && now.Sub(*ride.ArrivedAt) >= policy.NoShowAfter:
+			return policy.RiderFee, 0, "rider did not show up", nil
+		}
+		return 0, policy.DriverPenalty, "driver cancelled after accepting", nil
+	}
+
+	switch {
+	case ride.Status == rideStatusRequested:
+		return 0, 0, "no driver had accepted", nil
+	case now.Sub(ride.CreatedAt) <= policy.FreeWindow:
+		return 0, 0, "within free cancellation window", nil
+	}
+	return policy.RiderFee, 0, "cancelled after driver accepted", nil
+}
+
+func cancelRideHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		Reason   string `json:"reason"`
+		Provider string `json:"provider"`
+		Phone    string `json:"phone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+
+	provider, err := getPaymentProvider(req.Provider)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	by := cancelledByRider
+	if claims.Role == "driver" {
+		by = cancelledByDriver
+	}
+
+	c, driverID, riderID, err := cancelRide(r.Context(), mux.Vars(r)["id"], by, claims, strings.TrimSpace(req.Reason), provider.Name())
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+
+	if err := UpdateNotificationStatus(driverID, c.RideID, rideStatusCancelled); err != nil {
+		log.Printf("Failed to update notification for ride %s: %v", c.RideID, err)
+	}
+	publishRideStatus(&RideStatus{ID: c.RideID, Status: rideStatusCancelled})
+	if by == cancelledByRider {
+		NotifyDriver(driverID, map[string]interface{}{
+			"type":    "ride_cancelled",
+			"ride_id": c.RideID,
+		})
+	} else {
+		msg := "Your driver cancelled the ride."
+		switch {
+		case c.FeeStatus == "pending":
+			msg = fmt.Sprintf("Your ride was cancelled because you did not show up. A fee of %s applies; pay it under your dues before requesting another ride.",
+				currencyFor(c.Currency).Format(c.Fee))
+		case c.Fee > 0:
+			msg = fmt.Sprintf("Your ride was cancelled because you did not show up. A fee of %s was charged to your wallet.",
+				currencyFor(c.Currency).Format(c.Fee))
+		}
+		if err := notifyRider(r.Context(), riderID, c.RideID, "ride_cancelled", msg); err != nil {
+			log.Printf("Failed to notify rider %d of cancellation on ride %s: %v", riderID, c.RideID, err)
+		}
+	}
+
+	// A rider cancelling gets the fee's payment link straight away. A no-show
+	// rider pays it from their dues, which they must clear before their next
+	// ride.
+	if c.DueID != 0 && by == cancelledByRider {
+		due, err := startDuePayment(r.Context(), c.DueID, claims, provider, req.Phone)
+		if err != nil {
+			log.Printf("Failed to start cancellation fee payment for ride %s: %v", c.RideID, err)
+		} else {
+			c.TxRef, c.PaymentLink = due.TxRef, due.PaymentLink
+		}
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(c))
+}
+
+// cancelRide cancels a ride that has not started on behalf of its rider or
+// driver. The driver goes back to idle, any promo use and wallet hold are
+// released, and the policy's fee or penalty is booked. Wallet
+// rides pay the fee from the hold; whatever that does not cover, or the
+// whole fee on other rides, is owed as a due.
+func cancelRide(ctx context.Context, rideID, by string, claims *Claims, reason, provider string) (*Cancellation, string, int, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, "", 0, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if (by == cancelledByRider && ride.RiderID != claims.UserID) ||
+		(by == cancelledByDriver && ride.DriverID != claims.Username) {
+		return nil, "", 0, errRideNotFound
+	}
+
+	fee, penalty, policy, err := evaluateCancellation(ride, by, cancellationPolicyFor(ride.Currency), time.Now())
+	if err != nil {
+		return nil, "", 0, err
+	}
+	c := &Cancellation{
+		RideID:      ride.ID,
+		CancelledBy: by,
+		Reason:      reason,
+		Policy:      policy,
+		Fee:         fee,
+		Penalty:     penalty,
+		Currency:    ride.Currency,
+		FeeStatus:   "none",
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE rides SET status = 'cancelled', cancelled_at = NOW(), updated_at = NOW() WHERE id = $1`,
+		ride.ID); err != nil {
+		return nil, "", 0, fmt.Errorf("failed to cancel ride: %w", err)
+	}
+	if err := setDriverState(ctx, tx, ride.DriverID, driverStateIdle); err != nil {
+		return nil, "", 0, err
+	}
+	if err := releasePromoRedemption(ctx, tx, ride.ID); err != nil {
+		return nil, "", 0, err
+	}
+
+	owed := c.Fee
+	switch {
+	case ride.PaymentMethod == paymentMethodWallet && c.Fee > 0:
+		charged, err := captureWalletHold(ctx, tx, ride.ID, c.Fee)
+		if err != nil {
+			return nil, "", 0, fmt.Errorf("failed to charge cancellation fee: %w", err)
+		}
+		owed = c.Fee - charged
+	case ride.PaymentMethod == paymentMethodWallet:
+		if err := releaseWalletHold(ctx, tx, ride.ID); err != nil && !errors.Is(err, errNoWalletHold) {
+			return nil, "", 0, err
+		}
+	}
+	if c.Fee > 0 {
+		if err := bookCancellationFee(ctx, tx, ride, c, owed); err != nil {
+			return nil, "", 0, err
+		}
+	}
+
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO ride_cancellations (ride_id, cancelled_by, reason, policy, fee, penalty, currency,
+		                                 fee_status, due_id, provider)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10)
+		 RETURNING created_at`,
+		c.RideID, c.CancelledBy, c.Reason, c.Policy, c.Fee, c.Penalty, c.Currency,
+		c.FeeStatus, c.DueID, provider).Scan(&c.CreatedAt); err != nil {
+		return nil, "", 0, fmt.Errorf("failed to record cancellation: %w", err)
+	}
+	if c.Penalty > 0 {
+		if err := adjustDriverBalance(ctx, tx, ride.DriverID, -c.Penalty, c.Currency, "cancellation_penalty", ride.ID, ""); err != nil {
+			return nil, "", 0, err
+		}
+		if err := recordLedgerEntry(ctx, tx, platformAccount, "cancellation_penalty", ride.ID, c.Penalty, c.Currency, ""); err != nil {
+			return nil, "", 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, "", 0, errors.New("failed to commit transaction")
+	}
+	return c, ride.DriverID, ride.RiderID, nil
+}
+
+// bookCancellationFee passes a cancellation fee to the driver who was on
+// the way, less the platform's commission. The part the rider's wallet did
+// not cover is owed as a due, and its shares are only booked once it is
+// paid.
+func bookCancellationFee(ctx context.Context, tx pgx.Tx, ride *RideStatus, c *Cancellation, owed Money) error {
+	c.FeeStatus = "paid"
+	if owed > 0 {
+		commission := calculateCommission(owed)
+		id, err := addRiderDue(ctx, tx, ride, dueKindCancellationFee, owed, owed-commission, commission)
+		if err != nil {
+			return err
+		}
+		c.DueID = id
+		c.FeeStatus = "pending"
+	}
+
+	charged := c.Fee - owed
+	if charged <= 0 {
+		return nil
+	}
+	commission := calculateCommission(charged)
+	if err := adjustDriverBalance(ctx, tx, ride.DriverID, charged-commission, ride.Currency, "cancellation_fee", ride.ID, ""); err != nil {
+		return err
+	}
+	return recordLedgerEntry(ctx, tx, platformAccount, "commission", ride.ID, commission, ride.Currency, "")
+}
+
+// verifyCancellationFeeHandler checks the payment of a ride's cancellation
+// fee, which is collected as one of the rider's dues.
+func verifyCancellationFeeHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var dueID int64
+	err := dbPool.QueryRow(r.Context(),
+		`SELECT c.due_id
+		 FROM ride_cancellations c
+		 JOIN rides r ON r.id = c.ride_id
+		 WHERE c.ride_id = $1 AND r.rider_id = $2 AND c.due_id IS NOT NULL`,
+		mux.Vars(r)["id"], claims.UserID).Scan(&dueID)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, errorResponse("cancellation fee not found"))
+		return
+	}
+
+	respondDueVerification(r.Context(), w, dueID, claims.UserID)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEvaluateCancellation(t *testing.T) {
+	now := time.Now()
+	policy := CancellationPolicy{FreeWindow: 2 * time.Minute, NoShowAfter: 3 * time.Minute, RiderFee: 2000, DriverPenalty: 1000}
+
+	cases := []struct {
+		name         string
+		status       string
+		age          time.Duration
+		by           string
+		fee, penalty Money
+	}{
+		{"rider before accept", rideStatusRequested, 10 * time.Minute, cancelledByRider, 0, 0},
+		{"rider within free window", rideStatusAccepted, time.Minute, cancelledByRider, 0, 0},
+		{"rider after free window", rideStatusAccepted, 5 * time.Minute, cancelledByRider, 2000, 0},
+		{"driver declines", rideStatusRequested, time.Minute, cancelledByDriver, 0, 0},
+		{"driver after accept", rideStatusAccepted, time.Minute, cancelledByDriver, 0, 1000},
+		{"rider after arrival", rideStatusArrived, time.Minute, cancelledByRider, 0, 0},
+		{"driver before no-show", rideStatusArrived, 2 * time.Minute, cancelledByDriver, 0, 1000},
+		{"driver after no-show", rideStatusArrived, 10 * time.Minute, cancelledByDriver, 2000, 0},
+	}
+	for _, c := range cases {
+		// age is time since request; arrival, when set, is age ago too
+		created := now.Add(-c.age)
+		ride := &RideStatus{Status: c.status, CreatedAt: created}
+		if c.status == rideStatusArrived {
+			ride.ArrivedAt = &created
+		}
+		fee, penalty, _, err := evaluateCancellation(ride, c.by, policy, now)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", c.name, err)
+		}
+		if fee != c.fee || penalty != c.penalty {
+			t.Errorf("%s: expected fee %d penalty %d, got %d and %d", c.name, c.fee, c.penalty, fee, penalty)
+		}
+	}
+
+	for _, status := range []string{rideStatusInProgress, rideStatusCompleted, rideStatusCancelled} {
+		ride := &RideStatus{Status: status, CreatedAt: now}
+		if _, _, _, err := evaluateCancellation(ride, cancelledByRider, policy, now); !errors.Is(err, errInvalidRideTransition) {
+			t.Errorf("%s ride: expected errInvalidRideTransition, got %v", status, err)
+		}
+	}
+}
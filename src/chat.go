@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// ChatMessage is one message in a ride's chat between its rider and driver.
+type ChatMessage struct {
+	ID          int64      `json:"id"`
+	RideID      string     `json:"ride_id"`
+	SenderRole  string     `json:"sender_role"` // rider or driver
+	Body        string     `json:"body"`
+	Template    string     `json:"template,omitempty"`
+	ClientID    string     `json:"client_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+// ChatReceipt acknowledges every message from the other party up to and
+// including UpToID.
+type ChatReceipt struct {
+	RideID string    `json:"ride_id"`
+	By     string    `json:"by"` // rider or driver
+	UpToID int64     `json:"up_to_id"`
+	State  string    `json:"state"` // delivered or read
+	At     time.Time `json:"at"`
+}
+
+// chatFrame is a chat command sent over a WebSocket or the REST fallback.
+// Type is "chat" to send a message or "chat_ack" to acknowledge messages.
+type chatFrame struct {
+	Type     string `json:"type"`
+	RideID   string `json:"ride_id"`
+	ClientID string `json:"client_id"`
+	Body     string `json:"body"`
+	Template string `json:"template"`
+	UpToID   int64  `json:"up_to_id"`
+	State    string `json:"state"`
+}
+
+// chatRide is what chat needs to know about a ride.
+type chatRide struct {
+	ID         string
+	RiderID    int
+	DriverID   string
+	Status     string
+	AcceptedAt *time.Time
+	EndedAt    *time.Time
+	ClosedAt   *time.Time
+}
+
+const (
+	chatRoleRider  = "rider"
+	chatRoleDriver = "driver"
+
+	chatReceiptDelivered = "delivered"
+	chatReceiptRead      = "read"
+
+	maxChatBody           = 1000
+	maxChatClientID       = 64
+	chatMessagesPerMinute = 30
+	chatHistoryLimit      = 200
+	defaultChatCloseAfter = 10 * time.Minute
+	chatCloserPeriod      = 30 * time.Second
+)
+
+// chatTemplates are the quick replies each side can send by ID.
+var chatTemplates = map[string]map[string]string{
+	chatRoleRider: {
+		"on_my_way":     "I'm on my way to the pickup point.",
+		"at_pickup":     "I'm at the pickup point.",
+		"running_late":  "I'm running a few minutes late, please wait.",
+		"where_are_you": "Where are you?",
+		"thanks":        "Thank you!",
+	},
+	chatRoleDriver: {
+		"on_my_way": "I'm on my way to you.",
+		"arrived":   "I've arrived at the pickup point.",
+		"traffic":   "I'm stuck in traffic, I'll be there soon.",
+		"cant_find": "I can't find you. Please message me where you are.",
+		"thanks":    "Thank you for riding!",
+	},
+}
+
+var (
+	errChatClosed       = errors.New("chat is closed for this ride")
+	errNotChatMember    = errors.New("only the ride's rider and driver can use its chat")
+	errInvalidChatFrame = errors.New("invalid chat message")
+	errChatTooFast      = errors.New("too many messages, slow down")
+)
+
+// chatOpen says whether a ride's chat accepts messages at now. Chat opens
+// when a driver accepts the ride and closes closeAfter after it ends, or
+// when the closer has already closed it.
+func chatOpen(ride *chatRide, now time.Time, closeAfter time.Duration) bool {
+	if ride.AcceptedAt == nil || ride.ClosedAt != nil {
+		return false
+	}
+	switch ride.Status {
+	case rideStatusAccepted, rideStatusArrived, rideStatusInProgress:
+		return true
+	case rideStatusCompleted, rideStatusCancelled:
+		return ride.EndedAt != nil && now.Sub(*ride.EndedAt) < closeAfter
+	}
+	return false
+}
+
+// chatMessageBody resolves a message to its text: a quick-reply template
+// for the sender's side, or free text.
+func chatMessageBody(role, template, body string) (string, error) {
+	if template != "" {
+		text, ok := chatTemplates[role][template]
+		if !ok {
+			return "", fmt.Errorf("unknown template: %s", template)
+		}
+		return text, nil
+	}
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", errors.New("body or template is required")
+	}
+	if len(body) > maxChatBody {
+		return "", fmt.Errorf("body must be at most %d characters", maxChatBody)
+	}
+	return body, nil
+}
+
+// chatRole is the caller's side of the ride's chat.
+func chatRole(claims *Claims, ride *chatRide) (string, error) {
+	switch {
+	case claims.Role == "driver" && claims.Username == ride.DriverID:
+		return chatRoleDriver, nil
+	case claims.Role != "driver" && claims.UserID == ride.RiderID:
+		return chatRoleRider, nil
+	}
+	return "", errNotChatMember
+}
+
+func loadChatRide(ctx context.Context, q queryRower, rideID string) (*chatRide, error) {
+	ride := &chatRide{ID: rideID}
+	err := q.QueryRow(ctx,
+		`SELECT rider_id, driver_id, status, accepted_at, COALESCE(completed_at, cancelled_at), chat_closed_at
+		 FROM rides WHERE id = $1`,
+		rideID).Scan(&ride.RiderID, &ride.DriverID, &ride.Status, &ride.AcceptedAt, &ride.EndedAt, &ride.ClosedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errRideNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ride: %w", err)
+	}
+	return ride, nil
+}
+
+// publishChatEvent sends a chat event to both ends of the ride: the rider's
+// ride stream and the driver's socket. Senders get their own messages back,
+// with the server ID, as confirmation.
+func publishChatEvent(ride *chatRide, eventType string, data interface{}) {
+	if err := publishRideEvent(context.Background(), ride.ID, eventType, data); err != nil {
+		log.Printf("Failed to publish %s for ride %s: %v", eventType, ride.ID, err)
+	}
+	NotifyDriver(ride.DriverID, map[string]interface{}{
+		"type":    eventType,
+		"ride_id": ride.ID,
+		"data":    data,
+	})
+}
+
+// handleChatFrame runs a chat command from a socket or the REST fallback.
+// Sending the same client_id twice stores the message once.
+func handleChatFrame(ctx context.Context, claims *Claims, f chatFrame) (interface{}, error) {
+	ride, err := loadChatRide(ctx, dbPool, f.RideID)
+	if err != nil {
+		return nil, err
+	}
+	role, err := chatRole(claims, ride)
+	if err != nil {
+		return nil, err
+	}
+
+	switch f.Type {
+	case "chat":
+		if !chatOpen(ride, time.Now(), envDuration("CHAT_CLOSE_AFTER", defaultChatCloseAfter)) {
+			return nil, errChatClosed
+		}
+		return sendChatMessage(ctx, ride, role, f)
+	case "chat_ack":
+		return ackChatMessages(ctx, ride, role, f)
+	}
+	return nil, errInvalidChatFrame
+}
+
+func sendChatMessage(ctx context.Context, ride *chatRide, role string, f chatFrame) (*ChatMessage, error) {
+	body, err := chatMessageBody(role, f.Template, f.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidChatFrame, err)
+	}
+	if len(f.ClientID) > maxChatClientID {
+		return nil, fmt.Errorf("%w: client_id must be at most %d characters", errInvalidChatFrame, maxChatClientID)
+	}
+	sender := ride.DriverID
+	if role == chatRoleRider {
+		sender = strconv.Itoa(ride.RiderID)
+	}
+	if isRateLimited(ctx, "chat", role+":"+sender, chatMessagesPerMinute, time.Minute) {
+		return nil, errChatTooFast
+	}
+
+	msg := &ChatMessage{RideID: ride.ID, SenderRole: role, Body: body, Template: f.Template, ClientID: f.ClientID}
+	err = dbPool.QueryRow(ctx,
+		`WITH inserted AS (
+		     INSERT INTO ride_messages (ride_id, sender_role, sender_id, body, template, client_id)
+		     VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''))
+		     ON CONFLICT (ride_id, sender_role, client_id) DO NOTHING
+		     RETURNING id, created_at, delivered_at, read_at)
+		 SELECT * FROM inserted
+		 UNION ALL
+		 SELECT id, created_at, delivered_at, read_at FROM ride_messages
+		 WHERE ride_id = $1 AND sender_role = $2 AND client_id = $6 AND NOT EXISTS (SELECT 1 FROM inserted)`,
+		ride.ID, role, sender, msg.Body, msg.Template, msg.ClientID).
+		Scan(&msg.ID, &msg.CreatedAt, &msg.DeliveredAt, &msg.ReadAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+
+	publishChatEvent(ride, "chat", msg)
+	return msg, nil
+}
+
+// ackChatMessages marks the other party's messages up to f.UpToID delivered
+// or read. Read implies delivered.
+func ackChatMessages(ctx context.Context, ride *chatRide, role string, f chatFrame) (*ChatReceipt, error) {
+	if f.UpToID <= 0 || (f.State != chatReceiptDelivered && f.State != chatReceiptRead) {
+		return nil, fmt.Errorf("%w: up_to_id and a state of delivered or read are required", errInvalidChatFrame)
+	}
+
+	receipt := &ChatReceipt{RideID: ride.ID, By: role, UpToID: f.UpToID, State: f.State}
+	err := dbPool.QueryRow(ctx,
+		`UPDATE ride_messages
+		 SET delivered_at = COALESCE(delivered_at, NOW()),
+		     read_at = CASE WHEN $4 = 'read' THEN COALESCE(read_at, NOW()) ELSE read_at END
+		 WHERE ride_id = $1 AND sender_role <> $2 AND id <= $3
+		   AND (delivered_at IS NULL OR ($4 = 'read' AND read_at IS NULL))
+		 RETURNING NOW()`,
+		ride.ID, role, f.UpToID, f.State).Scan(&receipt.At)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Already acknowledged; nothing new to tell the sender.
+		receipt.At = time.Now()
+		return receipt, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acknowledge messages: %w", err)
+	}
+
+	publishChatEvent(ride, "chat_receipt", receipt)
+	return receipt, nil
+}
+
+// chatSocketReply runs a chat frame read from a WebSocket and returns what
+// to send back: nothing on success, since the result arrives as a chat
+// event, or an error frame.
+func chatSocketReply(claims *Claims, raw []byte, rideID string) map[string]interface{} {
+	var f chatFrame
+	if err := json.Unmarshal(raw, &f); err != nil || (f.Type != "chat" && f.Type != "chat_ack") {
+		return nil
+	}
+	if rideID != "" {
+		f.RideID = rideID
+	}
+	if _, err := handleChatFrame(context.Background(), claims, f); err != nil {
+		msg := err.Error()
+		if !errors.Is(err, errInvalidChatFrame) && !errors.Is(err, errChatClosed) && !errors.Is(err, errChatTooFast) &&
+			!errors.Is(err, errNotChatMember) && !errors.Is(err, errRideNotFound) {
+			log.Printf("Chat frame failed for ride %s: %v", f.RideID, err)
+			msg = "could not send message"
+		}
+		return map[string]interface{}{"type": "chat_error", "ride_id": f.RideID, "client_id": f.ClientID, "error": msg}
+	}
+	return nil
+}
+
+func respondChatError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidChatFrame):
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+	case errors.Is(err, errNotChatMember):
+		respondJSON(w, http.StatusForbidden, errorResponse(err.Error()))
+	case errors.Is(err, errChatClosed):
+		respondJSON(w, http.StatusConflict, errorResponse(err.Error()))
+	case errors.Is(err, errChatTooFast):
+		respondJSON(w, http.StatusTooManyRequests, errorResponse(err.Error()))
+	default:
+		respondRideError(w, err)
+	}
+}
+
+// listChatMessagesHandler returns a ride's chat, oldest first, so clients
+// can catch up after reconnecting. ?after= returns only newer messages.
+func listChatMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	ctx := r.Context()
+
+	ride, err := loadChatRide(ctx, dbPool, mux.Vars(r)["id"])
+	if err != nil {
+		respondChatError(w, err)
+		return
+	}
+	if _, err := chatRole(claims, ride); err != nil {
+		respondChatError(w, err)
+		return
+	}
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+
+	rows, err := dbPool.Query(ctx,
+		`SELECT id, ride_id, sender_role, body, COALESCE(template, ''), COALESCE(client_id, ''),
+		        created_at, delivered_at, read_at
+		 FROM ride_messages
+		 WHERE ride_id = $1 AND id > $2
+		 ORDER BY id
+		 LIMIT $3`,
+		ride.ID, after, chatHistoryLimit)
+	if err != nil {
+		respondChatError(w, err)
+		return
+	}
+	messages, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ChatMessage, error) {
+		var m ChatMessage
+		err := row.Scan(&m.ID, &m.RideID, &m.SenderRole, &m.Body, &m.Template, &m.ClientID,
+			&m.CreatedAt, &m.DeliveredAt, &m.ReadAt)
+		return m, err
+	})
+	if err != nil {
+		respondChatError(w, err)
+		return
+	}
+	if messages == nil {
+		messages = []ChatMessage{}
+	}
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"open":     chatOpen(ride, time.Now(), envDuration("CHAT_CLOSE_AFTER", defaultChatCloseAfter)),
+		"messages": messages,
+	}))
+}
+
+// postChatMessageHandler sends a message for clients without a socket.
+func postChatMessageHandler(w http.ResponseWriter, r *http.Request) {
+	serveChatFrame(w, r, "chat")
+}
+
+// ackChatMessagesHandler acknowledges messages for clients without a socket.
+func ackChatMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	serveChatFrame(w, r, "chat_ack")
+}
+
+func serveChatFrame(w http.ResponseWriter, r *http.Request, frameType string) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var f chatFrame
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	f.Type, f.RideID = frameType, mux.Vars(r)["id"]
+
+	result, err := handleChatFrame(r.Context(), claims, f)
+	if err != nil {
+		respondChatError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(result))
+}
+
+func chatTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	role := chatRoleRider
+	if claims.Role == "driver" {
+		role = chatRoleDriver
+	}
+	respondJSON(w, http.StatusOK, successResponse(chatTemplates[role]))
+}
+
+// startChatCloser closes the chat of rides that ended more than
+// CHAT_CLOSE_AFTER ago and tells both ends.
+func startChatCloser() {
+	go func() {
+		ticker := time.NewTicker(chatCloserPeriod)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := closeEndedChats(context.Background()); err != nil {
+				log.Printf("Chat closer failed: %v", err)
+			}
+		}
+	}()
+}
+
+func closeEndedChats(ctx context.Context) error {
+	closeAfter := envDuration("CHAT_CLOSE_AFTER", defaultChatCloseAfter)
+	rows, err := dbPool.Query(ctx,
+		`UPDATE rides SET chat_closed_at = NOW()
+		 WHERE chat_closed_at IS NULL AND status IN ('completed', 'cancelled')
+		   AND COALESCE(completed_at, cancelled_at, updated_at) < NOW() - make_interval(secs => $1)
+		 RETURNING id, rider_id, driver_id`,
+		closeAfter.Seconds())
+	if err != nil {
+		return err
+	}
+	closed, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (chatRide, error) {
+		var ride chatRide
+		err := row.Scan(&ride.ID, &ride.RiderID, &ride.DriverID)
+		return ride, err
+	})
+	if err != nil {
+		return err
+	}
+	for i := range closed {
+		publishChatEvent(&closed[i], "chat_closed", map[string]string{"ride_id": closed[i].ID})
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatOpen(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	accepted := now.Add(-30 * time.Minute)
+	endedRecently := now.Add(-5 * time.Minute)
+	endedLongAgo := now.Add(-15 * time.Minute)
+
+	tests := []struct {
+		name string
+		ride chatRide
+		want bool
+	}{
+		{"requested", chatRide{Status: rideStatusRequested}, false},
+		{"accepted", chatRide{Status: rideStatusAccepted, AcceptedAt: &accepted}, true},
+		{"in progress", chatRide{Status: rideStatusInProgress, AcceptedAt: &accepted}, true},
+		{"just completed", chatRide{Status: rideStatusCompleted, AcceptedAt: &accepted, EndedAt: &endedRecently}, true},
+		{"completed long ago", chatRide{Status: rideStatusCompleted, AcceptedAt: &accepted, EndedAt: &endedLongAgo}, false},
+		{"cancelled before accepting", chatRide{Status: rideStatusCancelled, EndedAt: &endedRecently}, false},
+		{"closed early", chatRide{Status: rideStatusCompleted, AcceptedAt: &accepted, EndedAt: &endedRecently, ClosedAt: &now}, false},
+	}
+	for _, tt := range tests {
+		if got := chatOpen(&tt.ride, now, 10*time.Minute); got != tt.want {
+			t.Errorf("%s: expected open=%v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestChatMessageBody(t *testing.T) {
+	if body, err := chatMessageBody(chatRoleDriver, "arrived", ""); err != nil || body != chatTemplates[chatRoleDriver]["arrived"] {
+		t.Errorf("Expected the driver's arrived template, got %q (%v)", body, err)
+	}
+	if body, err := chatMessageBody(chatRoleRider, "", "  by the blue gate "); err != nil || body != "by the blue gate" {
+		t.Errorf("Expected trimmed free text, got %q (%v)", body, err)
+	}
+
+	for _, bad := range []struct{ role, template, body string }{
+		{chatRoleRider, "arrived", ""},
+		{chatRoleRider, "", "   "},
+		{chatRoleDriver, "", strings.Repeat("x", maxChatBody+1)},
+	} {
+		if _, err := chatMessageBody(bad.role, bad.template, bad.body); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestChatRole(t *testing.T) {
+	ride := &chatRide{RiderID: 7, DriverID: "sam"}
+
+	if role, err := chatRole(&Claims{UserID: 7, Role: "rider"}, ride); err != nil || role != chatRoleRider {
+		t.Errorf("Expected the rider, got %q (%v)", role, err)
+	}
+	if role, err := chatRole(&Claims{Username: "sam", Role: "driver"}, ride); err != nil || role != chatRoleDriver {
+		t.Errorf("Expected the driver, got %q (%v)", role, err)
+	}
+	for _, c := range []*Claims{
+		{UserID: 8, Role: "rider"},
+		{Username: "ann", Role: "driver"},
+		{UserID: 7, Username: "ann", Role: "driver"},
+	} {
+		if _, err := chatRole(c, ride); err != errNotChatMember {
+			t.Errorf("Expected %+v to be refused, got %v", c, err)
+		}
+	}
+}
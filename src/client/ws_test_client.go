@@ -9,14 +9,16 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-func runWebSocketTest(driverID string) {
+// runWebSocketTest connects as driverID. The server only accepts the
+// driver's own JWT, sent as the token query parameter.
+func runWebSocketTest(driverID, token string) {
 	u := url.URL{
 		Scheme:   "ws",
 		Host:     "app:8080",
 		Path:     "/ws",
-		RawQuery: "driver_id=" + driverID,
+		RawQuery: url.Values{"driver_id": {driverID}, "token": {token}}.Encode(),
 	}
-	log.Printf("Connecting to %s", u.String())
+	log.Printf("Connecting to %s as driver %s", u.Host+u.Path, driverID)
 
 	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
 	if err != nil {
@@ -74,7 +76,15 @@ func runWebSocketTest(driverID string) {
 
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatal("Usage: /ws_test_client <driver_id>")
+		log.Fatal("Usage: /ws_test_client <driver_id> [token]")
 	}
-	runWebSocketTest(os.Args[1])
+	// The token defaults to TOKEN from the environment
+	token := os.Getenv("TOKEN")
+	if len(os.Args) > 2 {
+		token = os.Args[2]
+	}
+	if token == "" {
+		log.Fatal("a driver token is required: pass it as the second argument or set TOKEN")
+	}
+	runWebSocketTest(os.Args[1], token)
 }
@@ -86,11 +86,12 @@ func runMigrations(ctx context.Context) error {
 	        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 	        driver_id VARCHAR(255) NOT NULL,
 	        rider_id INTEGER NOT NULL,
-	        status VARCHAR(50) NOT NULL CHECK (status IN ('requested', 'accepted', 'in_progress', 'completed', 'cancelled')),
+	        status VARCHAR(50) NOT NULL CHECK (status IN ('requested', 'accepted', 'arrived', 'in_progress', 'completed', 'cancelled')),
 	        start_location GEOGRAPHY(POINT) NOT NULL,
 	        end_location GEOGRAPHY(POINT),
 	        estimated_eta INTEGER,
-	        price_estimate NUMERIC(10,2),
+	        price_estimate BIGINT,
+	        currency VARCHAR(3) NOT NULL DEFAULT 'UGX',
 	        created_at TIMESTAMP NOT NULL DEFAULT NOW(),
 	        updated_at TIMESTAMP NOT NULL DEFAULT NOW()
 	    )`); err != nil {
@@ -118,12 +119,31 @@ func runMigrations(ctx context.Context) error {
 }
 
 type RideStatus struct {
-    ID         string    `json:"ride_id"`
-    DriverID   string    `json:"driver_id"`
-    RiderID    int       `json:"rider_id"`
-    Status     string    `json:"status"`
-    Price      float64   `json:"price,omitempty"`
-    ETA        int       `json:"eta,omitempty"`
-    CreatedAt  time.Time `json:"created_at"`
-    UpdatedAt  time.Time `json:"updated_at"`
+    ID            string    `json:"ride_id"`
+    DriverID      string    `json:"driver_id"`
+    RiderID       int       `json:"rider_id"`
+    Status        string    `json:"status"`
+    Currency      string    `json:"currency,omitempty"`
+    Price         Money     `json:"price,omitempty"`
+    Discount      Money     `json:"discount,omitempty"`
+    PromoCode     string    `json:"promo_code,omitempty"`
+    FinalFare     Money     `json:"final_fare,omitempty"`
+    MeteredFare   Money     `json:"metered_fare,omitempty"`
+    FareBasis     string    `json:"fare_basis,omitempty"` // "quote" or "metered"
+    DistanceKm    float64   `json:"distance_km,omitempty"`
+    AmountDue     Money     `json:"amount_due,omitempty"`
+    CashCollected Money     `json:"cash_collected,omitempty"`
+    PaymentMethod string    `json:"payment_method,omitempty"`
+    ETA           int       `json:"eta,omitempty"`
+    PickupAddress string    `json:"pickup_address,omitempty"`
+    DropoffAddress string   `json:"dropoff_address,omitempty"`
+    RiderRating   *float64  `json:"rider_rating,omitempty"` // shown to the driver
+    CreatedAt     time.Time  `json:"created_at"`
+    UpdatedAt     time.Time  `json:"updated_at"`
+    WaitingCharge Money     `json:"waiting_charge,omitempty"`
+    AcceptedAt    *time.Time `json:"accepted_at,omitempty"`
+    ArrivedAt     *time.Time `json:"arrived_at,omitempty"`
+    StartedAt     *time.Time `json:"started_at,omitempty"`
+    CompletedAt   *time.Time `json:"completed_at,omitempty"`
+    CancelledAt   *time.Time `json:"cancelled_at,omitempty"`
 }
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// RiderDue is money a rider still owes on a ride, paid through a payment
+// link. Riders with pending dues cannot request another ride.
+type RiderDue struct {
+	ID          int64      `json:"id"`
+	RideID      string     `json:"ride_id"`
+	Kind        string     `json:"kind"`
+	Amount      Money      `json:"amount"`
+	Currency    string     `json:"currency"`
+	Status      string     `json:"status"` // pending, paid, cancelled or refunded
+	TxRef       string     `json:"tx_ref,omitempty"`
+	PaymentLink string     `json:"payment_link,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
+
+	driverShare   Money
+	commission    Money
+	provider      string
+	linkCreatedAt *time.Time
+}
+
+const (
+	dueKindFare            = "fare"
+	dueKindFareAdjustment  = "fare_adjustment"
+	dueKindCancellationFee = "cancellation_fee"
+)
+
+// dueEntryTypes names the driver ledger entry for each kind of due.
+var dueEntryTypes = map[string]string{
+	dueKindFare:            "ride_earnings",
+	dueKindFareAdjustment:  "fare_adjustment",
+	dueKindCancellationFee: "cancellation_fee",
+}
+
+var (
+	errDueNotFound      = errors.New("due not found")
+	errOutstandingDues  = errors.New("pay what you owe from earlier rides before requesting another")
+	errDuePaymentFailed = errors.New("could not start the payment")
+)
+
+const riderDueColumns = `id, ride_id, kind, amount, driver_share, commission, currency, status, COALESCE(tx_ref, ''),
+	COALESCE(provider, ''), COALESCE(payment_link, ''), link_created_at, created_at, paid_at`
+
+func scanRiderDue(row pgx.Row) (*RiderDue, error) {
+	var d RiderDue
+	err := row.Scan(&d.ID, &d.RideID, &d.Kind, &d.Amount, &d.driverShare, &d.commission, &d.Currency, &d.Status, &d.TxRef,
+		&d.provider, &d.PaymentLink, &d.linkCreatedAt, &d.CreatedAt, &d.PaidAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errDueNotFound
+	}
+	return &d, err
+}
+
+// addRiderDue records amount the rider still owes on ride, of which
+// driverShare goes to the driver and commission to the platform once paid,
+// updates the ride's amount_due and returns the due's ID. Nothing is
+// recorded for a zero amount.
+func addRiderDue(ctx context.Context, tx pgx.Tx, ride *RideStatus, kind string, amount, driverShare, commission Money) (int64, error) {
+	if amount <= 0 {
+		return 0, nil
+	}
+	var id int64
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO rider_dues (ride_id, rider_id, kind, amount, driver_share, commission, currency)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING id`,
+		ride.ID, ride.RiderID, kind, amount, driverShare, commission, ride.Currency).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to record amount due: %w", err)
+	}
+	return id, refreshRideAmountDue(ctx, tx, ride)
+}
+
+// refreshRideAmountDue stores the sum of the ride's pending dues on it.
+func refreshRideAmountDue(ctx context.Context, tx pgx.Tx, ride *RideStatus) error {
+	if err := tx.QueryRow(ctx,
+		`UPDATE rides
+		 SET amount_due = (SELECT COALESCE(SUM(amount), 0) FROM rider_dues WHERE ride_id = $1 AND status = 'pending')
+		 WHERE id = $1
+		 RETURNING amount_due`,
+		ride.ID).Scan(&ride.AmountDue); err != nil {
+		return fmt.Errorf("failed to update amount due: %w", err)
+	}
+	return nil
+}
+
+// reduceRiderDues takes amount off the locked ride's pending dues, newest
+// first, and returns how much of the driver's withheld share they no longer
+// hold. A link may already be out for the old amount, so a reduced due is
+// cancelled and what is left is owed again as a new due.
+func reduceRiderDues(ctx context.Context, tx pgx.Tx, ride *RideStatus, amount Money) (Money, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT `+riderDueColumns+`
+		 FROM rider_dues
+		 WHERE ride_id = $1 AND status = 'pending'
+		 ORDER BY created_at DESC, id DESC
+		 FOR UPDATE`,
+		ride.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load dues: %w", err)
+	}
+	dues, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*RiderDue, error) {
+		return scanRiderDue(row)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var released Money
+	for _, due := range dues {
+		if amount <= 0 {
+			break
+		}
+		cut := min(amount, due.Amount)
+		amount -= cut
+		if _, err := tx.Exec(ctx,
+			`UPDATE rider_dues SET status = 'cancelled', updated_at = NOW() WHERE id = $1`,
+			due.ID); err != nil {
+			return 0, fmt.Errorf("failed to cancel due: %w", err)
+		}
+		rest := due.Amount - cut
+		share := min(due.driverShare, rest)
+		released += due.driverShare - share
+		if _, err := addRiderDue(ctx, tx, ride, due.Kind, rest, share, min(due.commission, rest-share)); err != nil {
+			return 0, err
+		}
+	}
+	return released, refreshRideAmountDue(ctx, tx, ride)
+}
+
+// lockRiderDue locks a rider's due together with its ride, taking the ride
+// first like every other ride update.
+func lockRiderDue(ctx context.Context, tx pgx.Tx, dueID int64, riderID int) (*RiderDue, *RideStatus, error) {
+	var rideID string
+	err := tx.QueryRow(ctx,
+		`SELECT ride_id FROM rider_dues WHERE id = $1 AND rider_id = $2`,
+		dueID, riderID).Scan(&rideID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, errDueNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load due: %w", err)
+	}
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, nil, err
+	}
+	due, err := scanRiderDue(tx.QueryRow(ctx,
+		`SELECT `+riderDueColumns+` FROM rider_dues WHERE id = $1 FOR UPDATE`,
+		dueID))
+	if err != nil {
+		return nil, nil, err
+	}
+	return due, ride, nil
+}
+
+// settleRiderDue marks a locked due paid and credits the driver's share and
+// the platform's commission.
+func settleRiderDue(ctx context.Context, tx pgx.Tx, due *RiderDue, ride *RideStatus) error {
+	if err := tx.QueryRow(ctx,
+		`UPDATE rider_dues SET status = 'paid', paid_at = NOW(), updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING paid_at`,
+		due.ID).Scan(&due.PaidAt); err != nil {
+		return fmt.Errorf("failed to mark due paid: %w", err)
+	}
+	due.Status = "paid"
+	if due.driverShare > 0 {
+		if err := adjustDriverBalance(ctx, tx, ride.DriverID, due.driverShare, due.Currency,
+			dueEntryTypes[due.Kind], ride.ID, due.TxRef); err != nil {
+			return err
+		}
+	}
+	if due.commission > 0 {
+		if err := recordLedgerEntry(ctx, tx, platformAccount, "commission", ride.ID, due.commission, due.Currency, due.TxRef); err != nil {
+			return err
+		}
+	}
+	if due.Kind == dueKindCancellationFee {
+		if _, err := tx.Exec(ctx,
+			`UPDATE ride_cancellations SET fee_status = 'paid', updated_at = NOW() WHERE ride_id = $1 AND due_id = $2`,
+			ride.ID, due.ID); err != nil {
+			return fmt.Errorf("failed to update cancellation fee: %w", err)
+		}
+	}
+	return refreshRideAmountDue(ctx, tx, ride)
+}
+
+// refundRiderDue returns a payment made on a due that was cancelled after
+// its link went out to the rider's wallet.
+func refundRiderDue(ctx context.Context, tx pgx.Tx, due *RiderDue, ride *RideStatus) error {
+	if err := creditWallet(ctx, tx, ride.RiderID, due.Amount, due.Currency, "due_refund", ride.ID, due.TxRef); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE rider_dues SET status = 'refunded', updated_at = NOW() WHERE id = $1`,
+		due.ID); err != nil {
+		return fmt.Errorf("failed to mark due refunded: %w", err)
+	}
+	due.Status = "refunded"
+	return nil
+}
+
+// hasPendingDues reports whether the rider owes anything on earlier rides.
+func hasPendingDues(ctx context.Context, riderID int) (bool, error) {
+	var pending bool
+	err := dbPool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM rider_dues WHERE rider_id = $1 AND status = 'pending')`,
+		riderID).Scan(&pending)
+	return pending, err
+}
+
+func riderDuesHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT `+riderDueColumns+`
+		 FROM rider_dues
+		 WHERE rider_id = $1 AND status = 'pending'
+		 ORDER BY created_at`,
+		claims.UserID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	defer rows.Close()
+
+	dues := []*RiderDue{}
+	for rows.Next() {
+		due, err := scanRiderDue(rows)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+			return
+		}
+		dues = append(dues, due)
+	}
+	respondJSON(w, http.StatusOK, successResponse(dues))
+}
+
+// payRiderDueHandler returns a payment link for a due. Asking again while
+// the link is younger than PAYMENT_LINK_TTL returns the same link.
+func payRiderDueHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		Provider string `json:"provider"`
+		Phone    string `json:"phone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	provider, err := getPaymentProvider(req.Provider)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	due, err := startDuePayment(r.Context(), id, claims, provider, req.Phone)
+	if errors.Is(err, errDueNotFound) {
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start payment of due %d: %v", id, err)
+		respondJSON(w, http.StatusBadGateway, errorResponse(errDuePaymentFailed.Error()))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(due))
+}
+
+// startDuePayment hands out the due's payment link, reusing a fresh one. A
+// lapsed link is checked first, so a payment made on it is not lost.
+func startDuePayment(ctx context.Context, dueID int64, claims *Claims, provider PaymentProvider, phone string) (*RiderDue, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	due, ride, err := lockRiderDue(ctx, tx, dueID, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if due.Status != "pending" {
+		return due, nil
+	}
+	if due.TxRef != "" && due.linkCreatedAt != nil {
+		if paymentLinkFresh(*due.linkCreatedAt, time.Now(), paymentLinkTTL()) {
+			return due, nil
+		}
+		previous, err := getPaymentProvider(due.provider)
+		if err != nil {
+			return nil, err
+		}
+		paid, err := previous.VerifyPayment(due.TxRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check lapsed payment: %w", err)
+		}
+		if paid {
+			if err := settleRiderDue(ctx, tx, due, ride); err != nil {
+				return nil, err
+			}
+			if err := tx.Commit(ctx); err != nil {
+				return nil, errors.New("failed to commit transaction")
+			}
+			return due, nil
+		}
+	}
+
+	due.TxRef = fmt.Sprintf("due-%d-%d", due.ID, time.Now().UnixNano())
+	if _, err := tx.Exec(ctx,
+		`UPDATE rider_dues SET tx_ref = $1, provider = $2, payment_link = NULL, link_created_at = NOW(), updated_at = NOW()
+		 WHERE id = $3`,
+		due.TxRef, provider.Name(), due.ID); err != nil {
+		return nil, fmt.Errorf("failed to record payment: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+
+	due.PaymentLink, err = provider.InitiatePayment(PaymentRequest{
+		TxRef:    due.TxRef,
+		Amount:   due.Amount,
+		Currency: due.Currency,
+		Email:    claims.Email,
+		Phone:    phone,
+		RideID:   due.RideID,
+	})
+	if err != nil {
+		dbPool.Exec(ctx,
+			`UPDATE rider_dues SET tx_ref = NULL, provider = NULL, link_created_at = NULL, updated_at = NOW()
+			 WHERE id = $1 AND tx_ref = $2`,
+			due.ID, due.TxRef)
+		return nil, err
+	}
+	if _, err := dbPool.Exec(ctx,
+		`UPDATE rider_dues SET payment_link = $1, updated_at = NOW() WHERE id = $2 AND tx_ref = $3`,
+		due.PaymentLink, due.ID, due.TxRef); err != nil {
+		log.Printf("Failed to store payment link for due %d: %v", due.ID, err)
+	}
+	return due, nil
+}
+
+func verifyRiderDueHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	respondDueVerification(r.Context(), w, id, claims.UserID)
+}
+
+// respondDueVerification checks the payment on a rider's due with its
+// provider, records it if it went through and responds with the outcome.
+func respondDueVerification(ctx context.Context, w http.ResponseWriter, dueID int64, riderID int) {
+	due, err := scanRiderDue(dbPool.QueryRow(ctx,
+		`SELECT `+riderDueColumns+` FROM rider_dues WHERE id = $1 AND rider_id = $2`,
+		dueID, riderID))
+	if errors.Is(err, errDueNotFound) {
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+
+	if (due.Status == "pending" || due.Status == "cancelled") && due.TxRef != "" {
+		provider, err := getPaymentProvider(due.provider)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+			return
+		}
+		verified, err := provider.VerifyPayment(due.TxRef)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+			return
+		}
+		if verified {
+			due, err = completeRiderDue(ctx, due.ID, riderID, due.TxRef)
+			if err != nil {
+				log.Printf("Failed to complete due %d: %v", dueID, err)
+				respondJSON(w, http.StatusInternalServerError, errorResponse("failed to record payment"))
+				return
+			}
+		}
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"id":       due.ID,
+		"tx_ref":   due.TxRef,
+		"status":   due.Status,
+		"verified": due.Status == "paid" || due.Status == "refunded",
+	}))
+}
+
+// completeRiderDue settles a due whose payment under txRef was verified,
+// or refunds it if the due was cancelled in the meantime. Moving it out of
+// pending or cancelled makes this happen exactly once.
+func completeRiderDue(ctx context.Context, dueID int64, riderID int, txRef string) (*RiderDue, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	due, ride, err := lockRiderDue(ctx, tx, dueID, riderID)
+	if err != nil {
+		return nil, err
+	}
+	if due.TxRef != txRef {
+		return due, nil
+	}
+	switch due.Status {
+	case "pending":
+		err = settleRiderDue(ctx, tx, due, ride)
+	case "cancelled":
+		err = refundRiderDue(ctx, tx, due, ride)
+	default:
+		return due, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return due, tx.Commit(ctx)
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const defaultCommissionRate = 0.20
+
+type EarningsSummary struct {
+	Period     time.Time `json:"period"`
+	Currency   string    `json:"currency"`
+	Trips      int       `json:"trips"`
+	Gross      Money     `json:"gross"`
+	Commission Money     `json:"commission"`
+	Net        Money     `json:"net"`
+	Tips       Money     `json:"tips"`
+}
+
+type DriverEarnings struct {
+	DriverID string            `json:"driver_id"`
+	Currency string            `json:"currency"`
+	Balance  Money             `json:"balance"`
+	Daily    []EarningsSummary `json:"daily"`
+	Weekly   []EarningsSummary `json:"weekly"`
+}
+
+const (
+	earningsDailyDays   = 7
+	earningsWeeklyWeeks = 4
+)
+
+type DriverBalance struct {
+	DriverID     string        `json:"driver_id"`
+	Currency     string        `json:"currency"`
+	Balance      Money         `json:"balance"`
+	Owed         Money         `json:"owed"`
+	Transactions []LedgerEntry `json:"transactions"`
+}
+
+func driverAccount(driverID string) string {
+	return "driver:" + driverID
+}
+
+const platformAccount = "platform"
+
+// commissionRate is the platform's share of each fare, e.g. 0.2 for 20%.
+func commissionRate() float64 {
+	rate := envFloat("PLATFORM_COMMISSION_RATE", defaultCommissionRate)
+	if rate < 0 || rate > 1 {
+		return defaultCommissionRate
+	}
+	return rate
+}
+
+func calculateCommission(fare Money) Money {
+	return fare.scale(commissionRate())
+}
+
+// adjustDriverBalance moves a driver's running balance. A positive balance is
+// owed to the driver; a negative one is commission the driver owes the platform.
+// The balance keeps the currency of the driver's first trip.
+func adjustDriverBalance(ctx context.Context, tx pgx.Tx, driverID string, amount Money, currency, entryType, rideID, reference string) error {
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO driver_balances (driver_id, balance, currency) VALUES ($1, $2, $3)
+		 ON CONFLICT (driver_id) DO UPDATE
+		 SET balance = driver_balances.balance + EXCLUDED.balance, updated_at = NOW()
+		 WHERE driver_balances.currency = EXCLUDED.currency`,
+		driverID, amount, currency)
+	if err != nil {
+		return fmt.Errorf("failed to update driver balance: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errCurrencyMismatch
+	}
+	return recordLedgerEntry(ctx, tx, driverAccount(driverID), entryType, rideID, amount, currency, reference)
+}
+
+// settleDriverShare splits a completed fare between driver and platform and
+// records the trip's earnings. Promo discounts are funded by the platform, so
+// the driver earns on the undiscounted fare. Cash stays with the driver, so
+// only the commission (less any promo discount) is booked against them;
+// digital fares credit the driver's net share, which nets off any cash
+// commission they still owe. It runs after settleRidePayment, so the part
+// of the share the rider has not paid yet is left for their due.
+func settleDriverShare(ctx context.Context, tx pgx.Tx, ride *RideStatus, fare Money) error {
+	gross := fare + ride.Discount
+	commission := calculateCommission(gross)
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO driver_earnings (ride_id, driver_id, gross_fare, commission, net_amount, currency, payment_method)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		ride.ID, ride.DriverID, gross, commission, gross-commission, ride.Currency, ride.PaymentMethod); err != nil {
+		return fmt.Errorf("failed to record driver earnings: %w", err)
+	}
+
+	if ride.PaymentMethod == paymentMethodCash {
+		if err := adjustDriverBalance(ctx, tx, ride.DriverID, ride.Discount-commission, ride.Currency, "cash_commission", ride.ID, ""); err != nil {
+			return err
+		}
+	} else {
+		// Whatever the rider still owes is held back until they pay it.
+		net := gross - commission
+		credit := net - dueDriverShare(ride.PaymentMethod, ride.AmountDue, net)
+		if err := adjustDriverBalance(ctx, tx, ride.DriverID, credit, ride.Currency, "ride_earnings", ride.ID, ""); err != nil {
+			return err
+		}
+	}
+	return recordLedgerEntry(ctx, tx, platformAccount, "commission", ride.ID, commission, ride.Currency, "")
+}
+
+// dueDriverShare is the part of a fare due that goes to the driver once the
+// rider pays it. A cash driver was already charged commission on the whole
+// fare, so all of it is theirs; on digital fares it is their net share, up
+// to the amount due.
+func dueDriverShare(method string, due, net Money) Money {
+	if method == paymentMethodCash {
+		return due
+	}
+	return max(0, min(due, net))
+}
+
+// adjustDriverShare applies a post-trip fare change to the driver's earnings,
+// with the matching commission change booked to the platform. credit is what
+// moves on the driver's balance now; the rest waits on the rider's dues.
+func adjustDriverShare(ctx context.Context, tx pgx.Tx, ride *RideStatus, delta, credit Money, reference string) error {
+	commissionDelta := calculateCommission(delta)
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE driver_earnings
+		 SET gross_fare = gross_fare + $1, commission = commission + $2, net_amount = net_amount + $1 - $2
+		 WHERE ride_id = $3`,
+		delta, commissionDelta, ride.ID); err != nil {
+		return fmt.Errorf("failed to adjust driver earnings: %w", err)
+	}
+
+	if credit != 0 {
+		if err := adjustDriverBalance(ctx, tx, ride.DriverID, credit, ride.Currency, "fare_adjustment", ride.ID, reference); err != nil {
+			return err
+		}
+	}
+	return recordLedgerEntry(ctx, tx, platformAccount, "commission_adjustment", ride.ID, commissionDelta, ride.Currency, reference)
+}
+
+func getDriverBalance(ctx context.Context, driverID string) (*DriverBalance, error) {
+	balance := &DriverBalance{DriverID: driverID, Currency: defaultCurrency}
+	err := dbPool.QueryRow(ctx,
+		`SELECT currency, balance FROM driver_balances WHERE driver_id = $1`,
+		driverID).Scan(&balance.Currency, &balance.Balance)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	balance.Owed = max(0, -balance.Balance)
+
+	balance.Transactions, err = listLedgerEntries(ctx, driverAccount(driverID), walletHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+func driverBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	balance, err := getDriverBalance(r.Context(), claims.Username)
+	if err != nil {
+		log.Printf("Failed to load balance for driver %s: %v", claims.Username, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(balance))
+}
+
+// settleDriverBalanceHandler starts a mobile money collection for the
+// commission a driver owes from cash trips.
+func settleDriverBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	var req struct {
+		Provider string `json:"provider"`
+		Phone    string `json:"phone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+
+	provider, err := getPaymentProvider(req.Provider)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	settlement, err := startDriverSettlement(r.Context(), claims, provider, req.Phone)
+	switch {
+	case errors.Is(err, errNothingToSettle):
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	case errors.Is(err, errSettlementInProgress):
+		respondJSON(w, http.StatusConflict, errorResponse(err.Error()))
+		return
+	case err != nil:
+		log.Printf("Failed to start settlement for driver %s: %v", claims.Username, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(settlement))
+}
+
+// DriverSettlement is a mobile money collection paying down what a driver
+// owes.
+type DriverSettlement struct {
+	TxRef       string    `json:"tx_ref"`
+	Amount      Money     `json:"amount"`
+	Currency    string    `json:"currency"`
+	Status      string    `json:"status"`
+	PaymentLink string    `json:"payment_link,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	provider string
+}
+
+var (
+	errNothingToSettle      = errors.New("nothing to settle")
+	errSettlementInProgress = errors.New("a settlement is already in progress")
+)
+
+// startDriverSettlement opens a settlement for what the driver owes. A
+// driver has at most one pending settlement: while it is younger than
+// PAYMENT_LINK_TTL it is handed out again, and once it lapses it is checked
+// with the provider and either completed or failed before a new one starts.
+func startDriverSettlement(ctx context.Context, claims *Claims, provider PaymentProvider, phone string) (*DriverSettlement, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	var pending DriverSettlement
+	err = tx.QueryRow(ctx,
+		`SELECT tx_ref, amount, currency, status, COALESCE(payment_link, ''), created_at, provider
+		 FROM driver_settlements
+		 WHERE driver_id = $1 AND status = 'pending'
+		 FOR UPDATE`,
+		claims.Username).Scan(&pending.TxRef, &pending.Amount, &pending.Currency, &pending.Status,
+		&pending.PaymentLink, &pending.CreatedAt, &pending.provider)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return nil, fmt.Errorf("failed to load pending settlement: %w", err)
+	case paymentLinkFresh(pending.CreatedAt, time.Now(), paymentLinkTTL()):
+		return &pending, nil
+	default:
+		previous, err := getPaymentProvider(pending.provider)
+		if err != nil {
+			return nil, err
+		}
+		paid, err := previous.VerifyPayment(pending.TxRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check lapsed settlement: %w", err)
+		}
+		if paid {
+			if err := creditDriverSettlement(ctx, tx, pending.TxRef); err != nil {
+				return nil, err
+			}
+			if err := tx.Commit(ctx); err != nil {
+				return nil, errors.New("failed to commit transaction")
+			}
+			pending.Status = "completed"
+			return &pending, nil
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE driver_settlements SET status = 'failed', updated_at = NOW() WHERE tx_ref = $1`,
+			pending.TxRef); err != nil {
+			return nil, fmt.Errorf("failed to close lapsed settlement: %w", err)
+		}
+	}
+
+	settlement := &DriverSettlement{Currency: defaultCurrency, Status: "pending", provider: provider.Name()}
+	var balance Money
+	err = tx.QueryRow(ctx,
+		`SELECT currency, balance FROM driver_balances WHERE driver_id = $1 FOR UPDATE`,
+		claims.Username).Scan(&settlement.Currency, &balance)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to load driver balance: %w", err)
+	}
+	settlement.Amount = max(0, -balance)
+	if settlement.Amount <= 0 {
+		if err := tx.Commit(ctx); err != nil {
+			return nil, errors.New("failed to commit transaction")
+		}
+		return nil, errNothingToSettle
+	}
+
+	settlement.TxRef = fmt.Sprintf("settle-%s-%d", claims.Username, time.Now().UnixNano())
+	err = tx.QueryRow(ctx,
+		`INSERT INTO driver_settlements (tx_ref, driver_id, amount, currency, provider, status)
+		 VALUES ($1, $2, $3, $4, $5, 'pending')
+		 RETURNING created_at`,
+		settlement.TxRef, claims.Username, settlement.Amount, settlement.Currency, settlement.provider).Scan(&settlement.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, errSettlementInProgress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record settlement: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+
+	settlement.PaymentLink, err = provider.InitiatePayment(PaymentRequest{
+		TxRef:    settlement.TxRef,
+		Amount:   settlement.Amount,
+		Currency: settlement.Currency,
+		Email:    claims.Email,
+		Phone:    phone,
+	})
+	if err != nil {
+		dbPool.Exec(ctx,
+			`UPDATE driver_settlements SET status = 'failed', updated_at = NOW() WHERE tx_ref = $1`, settlement.TxRef)
+		return nil, err
+	}
+	if _, err := dbPool.Exec(ctx,
+		`UPDATE driver_settlements SET payment_link = $1, updated_at = NOW() WHERE tx_ref = $2`,
+		settlement.PaymentLink, settlement.TxRef); err != nil {
+		log.Printf("Failed to store payment link for settlement %s: %v", settlement.TxRef, err)
+	}
+	return settlement, nil
+}
+
+func verifyDriverSettlementHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		TxRef string `json:"tx_ref"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+
+	var providerName, status string
+	err := dbPool.QueryRow(r.Context(),
+		`SELECT provider, status FROM driver_settlements WHERE tx_ref = $1 AND driver_id = $2`,
+		req.TxRef, claims.Username).Scan(&providerName, &status)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, errorResponse("settlement not found"))
+		return
+	}
+
+	if status == "pending" {
+		provider, err := getPaymentProvider(providerName)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+			return
+		}
+		verified, err := provider.VerifyPayment(req.TxRef)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+			return
+		}
+		if verified {
+			if err := completeDriverSettlement(r.Context(), req.TxRef); err != nil {
+				log.Printf("Failed to complete settlement %s: %v", req.TxRef, err)
+				respondJSON(w, http.StatusInternalServerError, errorResponse("failed to credit balance"))
+				return
+			}
+			status = "completed"
+		}
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"tx_ref":   req.TxRef,
+		"status":   status,
+		"verified": status == "completed",
+	}))
+}
+
+func completeDriverSettlement(ctx context.Context, txRef string) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := creditDriverSettlement(ctx, tx, txRef); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// creditDriverSettlement marks a verified settlement completed and credits
+// the driver's balance. The pending-to-completed transition makes it happen
+// exactly once.
+func creditDriverSettlement(ctx context.Context, tx pgx.Tx, txRef string) error {
+	var driverID, currency string
+	var amount Money
+	err := tx.QueryRow(ctx,
+		`UPDATE driver_settlements SET status = 'completed', updated_at = NOW()
+		 WHERE tx_ref = $1 AND status = 'pending'
+		 RETURNING driver_id, amount, currency`,
+		txRef).Scan(&driverID, &amount, &currency)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return adjustDriverBalance(ctx, tx, driverID, amount, currency, "settlement", "", txRef)
+}
+
+// summarizeEarnings groups a driver's trip earnings since the given time into
+// periods of the given date_trunc unit ("day" or "week"), one row per
+// currency earned in.
+func summarizeEarnings(ctx context.Context, driverID, unit string, since time.Time) ([]EarningsSummary, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT date_trunc($2, created_at) AS period, currency, COUNT(*),
+		        SUM(gross_fare)::bigint, SUM(commission)::bigint, SUM(net_amount)::bigint, SUM(tip)::bigint
+		 FROM driver_earnings
+		 WHERE driver_id = $1 AND created_at >= $3
+		 GROUP BY period, currency
+		 ORDER BY period DESC, currency`,
+		driverID, unit, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []EarningsSummary{}
+	for rows.Next() {
+		var e EarningsSummary
+		if err := rows.Scan(&e.Period, &e.Currency, &e.Trips, &e.Gross, &e.Commission, &e.Net, &e.Tips); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, e)
+	}
+	return summaries, rows.Err()
+}
+
+func driverEarningsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	ctx := r.Context()
+	earnings := &DriverEarnings{DriverID: claims.Username}
+	balance, err := getDriverBalance(ctx, claims.Username)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	earnings.Currency = balance.Currency
+	earnings.Balance = balance.Balance
+
+	today := time.Now().Truncate(24 * time.Hour)
+	earnings.Daily, err = summarizeEarnings(ctx, claims.Username, "day", today.AddDate(0, 0, -(earningsDailyDays-1)))
+	if err != nil {
+		log.Printf("Failed to summarize daily earnings for %s: %v", claims.Username, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	earnings.Weekly, err = summarizeEarnings(ctx, claims.Username, "week", today.AddDate(0, 0, -7*earningsWeeklyWeeks))
+	if err != nil {
+		log.Printf("Failed to summarize weekly earnings for %s: %v", claims.Username, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(earnings))
+}
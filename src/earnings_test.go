@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculateCommission(t *testing.T) {
+	t.Setenv("PLATFORM_COMMISSION_RATE", "0.15")
+	if got := calculateCommission(10000); got != 1500 {
+		t.Errorf("Expected commission 1500, got %v", got)
+	}
+
+	t.Setenv("PLATFORM_COMMISSION_RATE", "1.5")
+	if got := calculateCommission(10000); got != 2000 {
+		t.Errorf("Out-of-range rate should fall back to default, got %v", got)
+	}
+}
+
+func TestPayoutRetryDelay(t *testing.T) {
+	cases := map[int]time.Duration{
+		1: 5 * time.Minute,
+		2: 10 * time.Minute,
+		4: 40 * time.Minute,
+	}
+	for attempts, want := range cases {
+		if got := payoutRetryDelay(attempts); got != want {
+			t.Errorf("attempt %d: expected %v, got %v", attempts, want, got)
+		}
+	}
+}
+
+func TestDueDriverShare(t *testing.T) {
+	cases := []struct {
+		name           string
+		method         string
+		due, net, want Money
+	}{
+		{"wallet shortfall holds back part of the share", paymentMethodWallet, 3000, 8000, 3000},
+		{"unpaid link fare holds back the whole share", paymentMethodFlutterwave, 10000, 8000, 8000},
+		{"paid fare holds nothing back", paymentMethodWallet, 0, 8000, 0},
+		{"cash shortfall is all the driver's", paymentMethodCash, 3000, 8000, 3000},
+	}
+	for _, c := range cases {
+		if got := dueDriverShare(c.method, c.due, c.net); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
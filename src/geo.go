@@ -0,0 +1,63 @@
+package main
+
+import "math"
+
+const earthRadiusKm = 6371.0
+
+// City is an operating region with its own currency and rate card.
+// Locations are matched to a city by bounding box.
+type City struct {
+	Code       string  `json:"code"`
+	Name       string  `json:"name"`
+	Currency   string  `json:"currency"`
+	BaseFare   Money   `json:"base_fare"`
+	PerKm      Money   `json:"per_km"`
+	PerMin     Money   `json:"per_min"`
+	WaitPerMin Money   `json:"wait_per_min"`
+	MinLat     float64 `json:"-"`
+	MaxLat     float64 `json:"-"`
+	MinLng     float64 `json:"-"`
+	MaxLng     float64 `json:"-"`
+}
+
+var cities = []City{
+	{Code: "kampala", Name: "Kampala", Currency: "UGX", BaseFare: 3000, PerKm: 1000, PerMin: 100, WaitPerMin: 200,
+		MinLat: 0.20, MaxLat: 0.45, MinLng: 32.45, MaxLng: 32.70},
+	{Code: "entebbe", Name: "Entebbe", Currency: "UGX", BaseFare: 3500, PerKm: 1200, PerMin: 100, WaitPerMin: 200,
+		MinLat: 0.00, MaxLat: 0.12, MinLng: 32.40, MaxLng: 32.52},
+	{Code: "nairobi", Name: "Nairobi", Currency: "KES", BaseFare: 10000, PerKm: 5000, PerMin: 500, WaitPerMin: 500,
+		MinLat: -1.45, MaxLat: -1.15, MinLng: 36.65, MaxLng: 37.10},
+}
+
+// outOfAreaRates prices rides that start outside every operating city.
+var outOfAreaRates = City{Currency: defaultCurrency, BaseFare: 3000, PerKm: 1000, PerMin: 100, WaitPerMin: 200}
+
+// cityForLocation returns the operating city containing the point, or nil if
+// the point is outside every region.
+func cityForLocation(lat, lng float64) *City {
+	for i := range cities {
+		c := &cities[i]
+		if lat >= c.MinLat && lat <= c.MaxLat && lng >= c.MinLng && lng <= c.MaxLng {
+			return c
+		}
+	}
+	return nil
+}
+
+// ratesForLocation returns the city containing the point, falling back to
+// the out-of-area rate card (which has no city code).
+func ratesForLocation(lat, lng float64) *City {
+	if c := cityForLocation(lat, lng); c != nil {
+		return c
+	}
+	return &outOfAreaRates
+}
+
+// haversineKm returns the great-circle distance between two points.
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
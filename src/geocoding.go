@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Geocoder turns coordinates into a human-readable address.
+type Geocoder interface {
+	Name() string
+	ReverseGeocode(ctx context.Context, lat, lng float64) (string, error)
+	// RateLimit is the most requests per second the provider accepts.
+	RateLimit() int
+}
+
+const (
+	defaultGeocodeCacheTTL   = 30 * 24 * time.Hour
+	defaultGeocoderTimeout   = 5 * time.Second
+	defaultGeocoderUserAgent = "ride-sharing-backend/1.0"
+	// Four decimal places is roughly 11m, close enough to share an address.
+	geocodePrecision = 4
+)
+
+var (
+	errGeocoderRateLimited = errors.New("geocoder rate limit exceeded")
+	errNoAddress           = errors.New("no address found")
+)
+
+var geocoders = map[string]Geocoder{
+	"nominatim": nominatimGeocoder{},
+	"selfhosted": nominatimGeocoder{
+		name:    "selfhosted",
+		urlEnv:  "GEOCODER_URL",
+		rateEnv: "GEOCODER_RATE_LIMIT",
+	},
+	"photon": photonGeocoder{},
+	"mock":   mockGeocoder{},
+}
+
+// getGeocoder returns the geocoder named by GEOCODER, defaulting to the
+// public Nominatim server.
+func getGeocoder() (Geocoder, error) {
+	name := os.Getenv("GEOCODER")
+	if name == "" {
+		name = "nominatim"
+	}
+	g, ok := geocoders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown geocoder: %s", name)
+	}
+	return g, nil
+}
+
+func geocodeCacheKey(lat, lng float64) string {
+	return fmt.Sprintf("geocode:%.*f,%.*f", geocodePrecision, lat, geocodePrecision, lng)
+}
+
+// reverseGeocode looks the address up in the Redis cache first. Cache misses
+// go to the configured geocoder, within its per-second rate limit.
+func reverseGeocode(ctx context.Context, lat, lng float64) (string, error) {
+	key := geocodeCacheKey(lat, lng)
+	if address, err := redisClient.Get(ctx, key).Result(); err == nil {
+		return address, nil
+	} else if !errors.Is(err, redis.Nil) {
+		log.Printf("Geocode cache read failed: %v", err)
+	}
+
+	g, err := getGeocoder()
+	if err != nil {
+		return "", err
+	}
+	if !allowGeocoderRequest(ctx, g.Name(), g.RateLimit()) {
+		return "", errGeocoderRateLimited
+	}
+	address, err := g.ReverseGeocode(ctx, lat, lng)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", g.Name(), err)
+	}
+
+	if err := redisClient.Set(ctx, key, address, envDuration("GEOCODE_CACHE_TTL", defaultGeocodeCacheTTL)).Err(); err != nil {
+		log.Printf("Geocode cache write failed: %v", err)
+	}
+	return address, nil
+}
+
+// allowGeocoderRequest counts requests to a provider in one-second windows
+// shared by every instance through Redis.
+func allowGeocoderRequest(ctx context.Context, provider string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+	key := fmt.Sprintf("rate_limit:geocoder:%s:%d", provider, time.Now().Unix())
+	count, err := redisClient.Incr(ctx, key).Result()
+	if err != nil {
+		return false
+	}
+	if count == 1 {
+		redisClient.Expire(ctx, key, 2*time.Second)
+	}
+	return count <= int64(limit)
+}
+
+// storeRideAddresses geocodes a new ride's pickup and dropoff onto the ride
+// row. It runs after the ride is created so geocoding never delays a request;
+// addresses that cannot be resolved are left empty.
+func storeRideAddresses(rideID string, pickupLat, pickupLng, dropoffLat, dropoffLng float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	lookup := func(lat, lng float64) *string {
+		if lat == 0 && lng == 0 {
+			return nil
+		}
+		address, err := reverseGeocode(ctx, lat, lng)
+		if err != nil {
+			log.Printf("Failed to geocode (%.5f, %.5f) for ride %s: %v", lat, lng, rideID, err)
+			return nil
+		}
+		return &address
+	}
+	pickup := lookup(pickupLat, pickupLng)
+	dropoff := lookup(dropoffLat, dropoffLng)
+	if pickup == nil && dropoff == nil {
+		return
+	}
+
+	if _, err := dbPool.Exec(ctx,
+		`UPDATE rides SET pickup_address = COALESCE($1, pickup_address), dropoff_address = COALESCE($2, dropoff_address)
+		 WHERE id = $3`,
+		pickup, dropoff, rideID); err != nil {
+		log.Printf("Failed to store addresses for ride %s: %v", rideID, err)
+	}
+}
+
+// geocoderClient has no timeout of its own; geocoderGet bounds each request
+// with GEOCODER_TIMEOUT through its context.
+var geocoderClient = &http.Client{}
+
+func geocoderGet(ctx context.Context, endpoint string, result interface{}) error {
+	timeout := envDuration("GEOCODER_TIMEOUT", defaultGeocoderTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	// Nominatim's usage policy requires an identifying User-Agent.
+	userAgent := os.Getenv("GEOCODER_USER_AGENT")
+	if userAgent == "" {
+		userAgent = defaultGeocoderUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := geocoderClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("geocoder returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// nominatimGeocoder queries a Nominatim server: the public one by default,
+// or a self-hosted instance when urlEnv is set.
+type nominatimGeocoder struct {
+	name    string
+	urlEnv  string
+	rateEnv string
+}
+
+func (g nominatimGeocoder) Name() string {
+	if g.name == "" {
+		return "nominatim"
+	}
+	return g.name
+}
+
+// RateLimit is one request per second on the public server, as its usage
+// policy requires.
+func (g nominatimGeocoder) RateLimit() int {
+	if g.rateEnv == "" {
+		return 1
+	}
+	return envInt(g.rateEnv, 20)
+}
+
+func (g nominatimGeocoder) ReverseGeocode(ctx context.Context, lat, lng float64) (string, error) {
+	base := "https://nominatim.openstreetmap.org"
+	if g.urlEnv != "" {
+		base = os.Getenv(g.urlEnv)
+		if base == "" {
+			return "", fmt.Errorf("%s not configured", g.urlEnv)
+		}
+	}
+	endpoint := fmt.Sprintf("%s/reverse?format=jsonv2&lat=%f&lon=%f", strings.TrimRight(base, "/"), lat, lng)
+
+	var result struct {
+		DisplayName string `json:"display_name"`
+	}
+	if err := geocoderGet(ctx, endpoint, &result); err != nil {
+		return "", err
+	}
+	if result.DisplayName == "" {
+		return "", errNoAddress
+	}
+	return result.DisplayName, nil
+}
+
+// photonGeocoder queries a Photon server at PHOTON_URL.
+type photonGeocoder struct{}
+
+func (photonGeocoder) Name() string { return "photon" }
+
+func (photonGeocoder) RateLimit() int { return envInt("PHOTON_RATE_LIMIT", 20) }
+
+type photonProperties struct {
+	Name        string `json:"name"`
+	HouseNumber string `json:"housenumber"`
+	Street      string `json:"street"`
+	District    string `json:"district"`
+	City        string `json:"city"`
+	Country     string `json:"country"`
+}
+
+func (photonGeocoder) ReverseGeocode(ctx context.Context, lat, lng float64) (string, error) {
+	base := os.Getenv("PHOTON_URL")
+	if base == "" {
+		return "", errors.New("PHOTON_URL not configured")
+	}
+	endpoint := fmt.Sprintf("%s/reverse?lat=%f&lon=%f&limit=1", strings.TrimRight(base, "/"), lat, lng)
+
+	var result struct {
+		Features []struct {
+			Properties photonProperties `json:"properties"`
+		} `json:"features"`
+	}
+	if err := geocoderGet(ctx, endpoint, &result); err != nil {
+		return "", err
+	}
+	if len(result.Features) == 0 {
+		return "", errNoAddress
+	}
+	address := result.Features[0].Properties.format()
+	if address == "" {
+		return "", errNoAddress
+	}
+	return address, nil
+}
+
+// format joins the non-empty address parts, most specific first.
+func (p photonProperties) format() string {
+	street := strings.TrimSpace(p.HouseNumber + " " + p.Street)
+	var parts []string
+	for _, part := range []string{p.Name, street, p.District, p.City, p.Country} {
+		if part != "" && (len(parts) == 0 || parts[len(parts)-1] != part) {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// mockGeocoder answers offline, for development and tests.
+type mockGeocoder struct{}
+
+func (mockGeocoder) Name() string { return "mock" }
+
+func (mockGeocoder) RateLimit() int { return 0 }
+
+func (mockGeocoder) ReverseGeocode(ctx context.Context, lat, lng float64) (string, error) {
+	address := fmt.Sprintf("Near %.4f, %.4f", lat, lng)
+	if c := cityForLocation(lat, lng); c != nil {
+		address += ", " + c.Name
+	}
+	return address, nil
+}
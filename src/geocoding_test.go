@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGeocodeCacheKey(t *testing.T) {
+	// Points a few metres apart share a cache entry.
+	if a, b := geocodeCacheKey(0.313612, 32.581141), geocodeCacheKey(0.313640, 32.581120); a != b {
+		t.Errorf("Expected nearby points to share a key, got %q and %q", a, b)
+	}
+	if got := geocodeCacheKey(-1.2921, 36.8219); got != "geocode:-1.2921,36.8219" {
+		t.Errorf("Unexpected cache key %q", got)
+	}
+}
+
+func TestPhotonPropertiesFormat(t *testing.T) {
+	p := photonProperties{Name: "Garden City", HouseNumber: "64", Street: "Yusuf Lule Road", City: "Kampala", Country: "Uganda"}
+	if got := p.format(); got != "Garden City, 64 Yusuf Lule Road, Kampala, Uganda" {
+		t.Errorf("Unexpected address %q", got)
+	}
+	p = photonProperties{Name: "Kampala", City: "Kampala", Country: "Uganda"}
+	if got := p.format(); got != "Kampala, Uganda" {
+		t.Errorf("Expected repeated parts to be dropped, got %q", got)
+	}
+}
+
+func TestSelfHostedNominatim(t *testing.T) {
+	var userAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		if r.URL.Path != "/reverse" || r.URL.Query().Get("lat") != "0.313600" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"display_name":"Kampala Road, Kampala, Uganda"}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("GEOCODER_URL", srv.URL)
+	got, err := geocoders["selfhosted"].ReverseGeocode(context.Background(), 0.3136, 32.5811)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Kampala Road, Kampala, Uganda" {
+		t.Errorf("Unexpected address %q", got)
+	}
+	if userAgent != defaultGeocoderUserAgent {
+		t.Errorf("Expected User-Agent %q, got %q", defaultGeocoderUserAgent, userAgent)
+	}
+}
+
+func TestMockGeocoder(t *testing.T) {
+	got, _ := mockGeocoder{}.ReverseGeocode(context.Background(), 0.3136, 32.5811)
+	if got != "Near 0.3136, 32.5811, Kampala" {
+		t.Errorf("Unexpected address %q", got)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RideSummary is one ride in a rider's history or a driver's trips.
+// RatingGiven is the caller's rating of the other party and RatingReceived
+// the other party's rating of the caller.
+type RideSummary struct {
+	RideID         string     `json:"ride_id"`
+	Status         string     `json:"status"`
+	PickupAddress  string     `json:"pickup_address,omitempty"`
+	DropoffAddress string     `json:"dropoff_address,omitempty"`
+	Currency       string     `json:"currency"`
+	Fare           Money      `json:"fare"`
+	PaymentMethod  string     `json:"payment_method,omitempty"`
+	DriverID       string     `json:"driver_id,omitempty"`
+	DriverName     string     `json:"driver_name,omitempty"`
+	Vehicle        string     `json:"vehicle,omitempty"`
+	RiderID        int        `json:"rider_id,omitempty"`
+	Earnings       *Money     `json:"earnings,omitempty"` // driver's net, once settled
+	RatingGiven    *int       `json:"rating_given,omitempty"`
+	RatingReceived *int       `json:"rating_received,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	CancelledAt    *time.Time `json:"cancelled_at,omitempty"`
+}
+
+// HistoryPage is a page of rides, newest first. NextCursor is empty on the
+// last page.
+type HistoryPage struct {
+	Rides      []RideSummary `json:"rides"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// historyFilter is a parsed history query. From is inclusive and To
+// exclusive; a zero time means no bound.
+type historyFilter struct {
+	Statuses []string
+	From, To time.Time
+	Limit    int
+	Cursor   *historyCursor
+}
+
+// historyCursor is the position after the last ride of a page. Rides are
+// ordered by creation time, with the ID breaking ties.
+type historyCursor struct {
+	CreatedAt time.Time
+	RideID    string
+}
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+var rideStatuses = map[string]bool{
+	rideStatusRequested: true, rideStatusAccepted: true, rideStatusArrived: true,
+	rideStatusInProgress: true, rideStatusCompleted: true, rideStatusCancelled: true,
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func (c historyCursor) encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + "|" + c.RideID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeHistoryCursor(s string) (*historyCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	micros, rideID, ok := strings.Cut(string(raw), "|")
+	if !ok || rideID == "" {
+		return nil, errInvalidCursor
+	}
+	n, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &historyCursor{CreatedAt: time.UnixMicro(n).UTC(), RideID: rideID}, nil
+}
+
+// parseHistoryDate accepts an RFC 3339 time or a YYYY-MM-DD date. A date
+// used as an upper bound covers the whole day.
+func parseHistoryDate(s string, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parseHistoryFilter reads ?status=completed,cancelled&from=&to=&limit=&cursor=.
+func parseHistoryFilter(q url.Values) (historyFilter, error) {
+	f := historyFilter{Limit: defaultHistoryLimit}
+
+	if v := q.Get("status"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if !rideStatuses[s] {
+				return f, fmt.Errorf("unknown status: %s", s)
+			}
+			f.Statuses = append(f.Statuses, s)
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		t, err := parseHistoryDate(v, false)
+		if err != nil {
+			return f, errors.New("from must be a YYYY-MM-DD date or RFC 3339 time")
+		}
+		f.From = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := parseHistoryDate(v, true)
+		if err != nil {
+			return f, errors.New("to must be a YYYY-MM-DD date or RFC 3339 time")
+		}
+		f.To = t
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, errors.New("from must be before to")
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return f, errors.New("limit must be a positive integer")
+		}
+		f.Limit = min(n, maxHistoryLimit)
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeHistoryCursor(v)
+		if err != nil {
+			return f, err
+		}
+		f.Cursor = c
+	}
+	return f, nil
+}
+
+// nullableTime passes a zero time to SQL as NULL.
+func nullableTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// loadRideHistory returns a page of the rides of a rider (viewer "rider",
+// subject their user ID) or a driver (viewer "driver", subject their driver
+// ID). It fetches one extra ride to know whether there is a next page.
+func loadRideHistory(ctx context.Context, viewer string, subject any, f historyFilter) (*HistoryPage, error) {
+	owner := `r.rider_id = $1`
+	other := ratedByDriver
+	if viewer == ratedByDriver {
+		owner = `r.driver_id = $1`
+		other = ratedByRider
+	}
+
+	var cursorAt *time.Time
+	var cursorID *string
+	if f.Cursor != nil {
+		cursorAt, cursorID = &f.Cursor.CreatedAt, &f.Cursor.RideID
+	}
+	var statuses []string
+	if len(f.Statuses) > 0 {
+		statuses = f.Statuses
+	}
+
+	rows, err := dbPool.Query(ctx,
+		`SELECT r.id, r.status, COALESCE(r.pickup_address, ''), COALESCE(r.dropoff_address, ''),
+		        r.currency, COALESCE(r.final_fare, r.price_estimate, 0), COALESCE(r.payment_method, ''),
+		        r.driver_id, COALESCE(d.name, ''), `+vehicleLabelSQL+`, r.rider_id, e.net_amount,
+		        given.stars, received.stars, r.created_at, r.completed_at, r.cancelled_at
+		 FROM rides r
+		 LEFT JOIN drivers d ON d.driver_id = r.driver_id
+		 LEFT JOIN vehicles v ON v.id = r.vehicle_id
+		 LEFT JOIN driver_earnings e ON e.ride_id = r.id
+		 LEFT JOIN ratings given ON given.ride_id = r.id AND given.rated_by = $2
+		 LEFT JOIN ratings received ON received.ride_id = r.id AND received.rated_by = $3
+		 WHERE `+owner+`
+		   AND ($4::text[] IS NULL OR r.status = ANY($4))
+		   AND ($5::timestamp IS NULL OR r.created_at >= $5)
+		   AND ($6::timestamp IS NULL OR r.created_at < $6)
+		   AND ($7::timestamp IS NULL OR (r.created_at, r.id) < ($7, $8::uuid))
+		 ORDER BY r.created_at DESC, r.id DESC
+		 LIMIT $9`,
+		subject, viewer, other, statuses, nullableTime(f.From), nullableTime(f.To), cursorAt, cursorID, f.Limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rides: %w", err)
+	}
+	rides, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (RideSummary, error) {
+		var s RideSummary
+		err := row.Scan(&s.RideID, &s.Status, &s.PickupAddress, &s.DropoffAddress,
+			&s.Currency, &s.Fare, &s.PaymentMethod,
+			&s.DriverID, &s.DriverName, &s.Vehicle, &s.RiderID, &s.Earnings,
+			&s.RatingGiven, &s.RatingReceived, &s.CreatedAt, &s.CompletedAt, &s.CancelledAt)
+		return s, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rides: %w", err)
+	}
+
+	page := &HistoryPage{Rides: []RideSummary{}}
+	if len(rides) > f.Limit {
+		rides = rides[:f.Limit]
+		last := rides[len(rides)-1]
+		page.NextCursor = historyCursor{CreatedAt: last.CreatedAt, RideID: last.RideID}.encode()
+	}
+	for _, s := range rides {
+		// Riders see their driver; drivers see the rider's ID and their own
+		// earnings, but not themselves.
+		if viewer == ratedByRider {
+			s.RiderID, s.Earnings = 0, nil
+		} else {
+			s.DriverID, s.DriverName = "", ""
+		}
+		page.Rides = append(page.Rides, s)
+	}
+	return page, nil
+}
+
+func rideHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	f, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	page, err := loadRideHistory(r.Context(), ratedByRider, claims.UserID, f)
+	if err != nil {
+		log.Printf("Failed to load ride history for rider %d: %v", claims.UserID, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(page))
+}
+
+func driverTripsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	f, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	page, err := loadRideHistory(r.Context(), ratedByDriver, claims.Username, f)
+	if err != nil {
+		log.Printf("Failed to load trips for driver %s: %v", claims.Username, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(page))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistoryCursorRoundTrip(t *testing.T) {
+	c := historyCursor{
+		CreatedAt: time.Date(2026, 4, 2, 8, 30, 15, 123456000, time.UTC),
+		RideID:    "7b1f3c6e-3f0a-4c1e-9d52-5a1c2b3d4e5f",
+	}
+	got, err := decodeHistoryCursor(c.encode())
+	if err != nil {
+		t.Fatalf("Expected the cursor to decode, got %v", err)
+	}
+	if !got.CreatedAt.Equal(c.CreatedAt) || got.RideID != c.RideID {
+		t.Errorf("Expected %+v, got %+v", c, got)
+	}
+
+	for _, bad := range []string{"not base64!", "bm8tc2VwYXJhdG9y", "eHx5"} {
+		if _, err := decodeHistoryCursor(bad); err != errInvalidCursor {
+			t.Errorf("Expected %q to be rejected, got %v", bad, err)
+		}
+	}
+}
+
+func TestParseHistoryFilter(t *testing.T) {
+	f, err := parseHistoryFilter(url.Values{})
+	if err != nil || f.Limit != defaultHistoryLimit || f.Statuses != nil || !f.From.IsZero() || f.Cursor != nil {
+		t.Fatalf("Expected defaults, got %+v (%v)", f, err)
+	}
+
+	f, err = parseHistoryFilter(url.Values{
+		"status": {"completed, cancelled"},
+		"from":   {"2026-03-01"},
+		"to":     {"2026-03-31"},
+		"limit":  {"500"},
+	})
+	if err != nil {
+		t.Fatalf("Expected a valid filter, got %v", err)
+	}
+	if !reflect.DeepEqual(f.Statuses, []string{"completed", "cancelled"}) {
+		t.Errorf("Unexpected statuses %v", f.Statuses)
+	}
+	if !f.From.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || !f.To.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the to date to cover its whole day, got %v to %v", f.From, f.To)
+	}
+	if f.Limit != maxHistoryLimit {
+		t.Errorf("Expected the limit to be capped at %d, got %d", maxHistoryLimit, f.Limit)
+	}
+
+	f, err = parseHistoryFilter(url.Values{"to": {"2026-03-31T12:00:00+03:00"}})
+	if err != nil || !f.To.Equal(time.Date(2026, 3, 31, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected an RFC 3339 bound to be used as is, got %v (%v)", f.To, err)
+	}
+
+	for _, q := range []url.Values{
+		{"status": {"finished"}},
+		{"from": {"yesterday"}},
+		{"from": {"2026-03-10"}, "to": {"2026-03-01"}},
+		{"limit": {"0"}},
+		{"cursor": {"%%%"}},
+	} {
+		if _, err := parseHistoryFilter(q); err == nil {
+			t.Errorf("Expected %v to be rejected", q)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// LedgerEntry is a single money movement against an account. Accounts are
+// namespaced strings such as "wallet:1001" so riders, drivers and the platform
+// share one append-only ledger.
+type LedgerEntry struct {
+	ID        int64     `json:"id"`
+	Account   string    `json:"account"`
+	RideID    string    `json:"ride_id,omitempty"`
+	EntryType string    `json:"type"`
+	Amount    Money     `json:"amount"`
+	Currency  string    `json:"currency"`
+	Reference string    `json:"reference,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func walletAccount(riderID int) string {
+	return "wallet:" + strconv.Itoa(riderID)
+}
+
+func recordLedgerEntry(ctx context.Context, tx pgx.Tx, account, entryType, rideID string, amount Money, currency, reference string) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO ledger_entries (account, ride_id, entry_type, amount, currency, reference)
+		 VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6)`,
+		account, rideID, entryType, amount, currency, reference)
+	if err != nil {
+		return fmt.Errorf("failed to record %s ledger entry: %w", entryType, err)
+	}
+	return nil
+}
+
+func listLedgerEntries(ctx context.Context, account string, limit int) ([]LedgerEntry, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT id, account, COALESCE(ride_id::text, ''), entry_type, amount, currency, reference, created_at
+		 FROM ledger_entries
+		 WHERE account = $1
+		 ORDER BY created_at DESC, id DESC
+		 LIMIT $2`,
+		account, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(&e.ID, &e.Account, &e.RideID, &e.EntryType, &e.Amount, &e.Currency, &e.Reference, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
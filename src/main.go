@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/fatih/color"
@@ -91,11 +92,25 @@ func main() {
     initRateLimiter()
     log.Println(success("Rate limiter initialized"))
 
-    // 6. Create and configure router
+    // 6. Start background jobs
+    startPayoutScheduler()
+    log.Println(success("Payout scheduler started"))
+    startWaitingMonitor()
+    log.Println(success("Waiting monitor started"))
+    startTrackMaintenance()
+    log.Println(success("Track maintenance started"))
+    startShiftMonitor()
+    log.Println(success("Shift monitor started"))
+    startChatCloser()
+    log.Println(success("Chat closer started"))
+    startMaskedCallExpiry()
+    log.Println(success("Masked call expiry started"))
+
+    // 7. Create and configure router
     r := configureRouter()
     log.Println(success("Router configured"))
 
-    // 7. Start server
+    // 8. Start server
     port := getPort()
     server := &http.Server{
         Addr:         ":" + port,
@@ -151,6 +166,10 @@ func configureRouter() *mux.Router {
     r.HandleFunc("/auth/validate", validateTokenHandler).Methods("GET")
     r.Handle("/metrics", promhttp.Handler())
     r.HandleFunc("/ws", WSHandler)
+    r.HandleFunc("/ws/rider", riderWSHandler)
+    r.HandleFunc("/share/{token}", sharedTripHandler).Methods("GET")
+    r.HandleFunc("/share/{token}/events", sharedTripEventsHandler).Methods("GET")
+    r.HandleFunc("/calls/inbound", inboundCallHandler).Methods("POST")
     r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
         w.Write([]byte("OK"))
     })
@@ -161,8 +180,104 @@ func configureRouter() *mux.Router {
     api.Use(metricsMiddleware)
     {
         api.HandleFunc("/request-ride", requestRideHandler).Methods("POST")
+        api.HandleFunc("/rides/quote", quoteRideHandler).Methods("POST")
         api.HandleFunc("/drivers", listDriversHandler).Methods("GET")
+        api.HandleFunc("/drivers/{id}/location", driverLocationHandler).Methods("GET")
         api.HandleFunc("/ride-status/{id}", rideStatusHandler).Methods("GET")
+        api.HandleFunc("/rides", rideHistoryHandler).Methods("GET")
+        api.HandleFunc("/rides/{id}/accept", acceptRideHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/decline", declineRideHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/arrive", arriveRideHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/start", startRideHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/location", recordRideLocationHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/track", rideTrackHandler).Methods("GET")
+        api.HandleFunc("/rides/{id}/share", createShareHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/share", revokeShareHandler).Methods("DELETE")
+        api.HandleFunc("/rides/{id}/sos", sosHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/cancel", cancelRideHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/cancel/verify", verifyCancellationFeeHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/complete", completeRideHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/tip", tipRideHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/tip/verify", verifyTipHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/receipt", rideReceiptHandler).Methods("GET")
+        api.HandleFunc("/rides/{id}/rating", rateRideHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/messages", listChatMessagesHandler).Methods("GET")
+        api.HandleFunc("/rides/{id}/messages", postChatMessageHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/messages/ack", ackChatMessagesHandler).Methods("POST")
+        api.HandleFunc("/rides/{id}/call", rideCallHandler).Methods("POST")
+        api.HandleFunc("/chat/templates", chatTemplatesHandler).Methods("GET")
+
+        api.HandleFunc("/places/search", placesSearchHandler).Methods("GET")
+        api.HandleFunc("/places/autocomplete", placesAutocompleteHandler).Methods("GET")
+
+        api.HandleFunc("/places/recent", recentDestinationsHandler).Methods("GET")
+        api.HandleFunc("/saved-places", listSavedPlacesHandler).Methods("GET")
+        api.HandleFunc("/saved-places", createSavedPlaceHandler).Methods("POST")
+        api.HandleFunc("/saved-places/{id}", updateSavedPlaceHandler).Methods("PUT")
+        api.HandleFunc("/saved-places/{id}", deleteSavedPlaceHandler).Methods("DELETE")
+
+        api.HandleFunc("/rider/notifications", riderNotificationsHandler).Methods("GET")
+        api.HandleFunc("/rider/rating", riderRatingHandler).Methods("GET")
+        api.HandleFunc("/rider/phone", updateRiderPhoneHandler).Methods("PUT")
+        api.HandleFunc("/rider/dues", riderDuesHandler).Methods("GET")
+        api.HandleFunc("/rider/dues/{id}/pay", payRiderDueHandler).Methods("POST")
+        api.HandleFunc("/rider/dues/{id}/verify", verifyRiderDueHandler).Methods("POST")
+
+        api.HandleFunc("/trusted-contacts", listTrustedContactsHandler).Methods("GET")
+        api.HandleFunc("/trusted-contacts", addTrustedContactHandler).Methods("POST")
+        api.HandleFunc("/trusted-contacts/{id}", deleteTrustedContactHandler).Methods("DELETE")
+
+        api.HandleFunc("/wallet", getWalletHandler).Methods("GET")
+        api.HandleFunc("/wallet/topup", topUpWalletHandler).Methods("POST")
+        api.HandleFunc("/wallet/topup/verify", verifyTopUpHandler).Methods("POST")
+
+        api.HandleFunc("/driver/application", getApplicationHandler).Methods("GET")
+        api.HandleFunc("/driver/application", saveApplicationHandler).Methods("PUT")
+        api.HandleFunc("/driver/application/documents/{type}", uploadDocumentHandler).Methods("PUT")
+        api.HandleFunc("/driver/application/submit", submitApplicationHandler).Methods("POST")
+        api.HandleFunc("/driver/vehicles", listDriverVehiclesHandler).Methods("GET")
+        api.HandleFunc("/driver/vehicles", addDriverVehicleHandler).Methods("POST")
+        api.HandleFunc("/driver/vehicle", selectVehicleHandler).Methods("PUT")
+        api.HandleFunc("/driver/online", goOnlineHandler).Methods("POST")
+        api.HandleFunc("/driver/offline", goOfflineHandler).Methods("POST")
+        api.HandleFunc("/driver/heartbeat", driverHeartbeatHandler).Methods("POST")
+        api.HandleFunc("/driver/shifts", driverShiftsHandler).Methods("GET")
+        api.HandleFunc("/driver/rating", driverRatingHandler).Methods("GET")
+        api.HandleFunc("/driver/trips", driverTripsHandler).Methods("GET")
+        api.HandleFunc("/driver/balance", driverBalanceHandler).Methods("GET")
+        api.HandleFunc("/driver/balance/settle", settleDriverBalanceHandler).Methods("POST")
+        api.HandleFunc("/driver/balance/settle/verify", verifyDriverSettlementHandler).Methods("POST")
+        api.HandleFunc("/driver/earnings", driverEarningsHandler).Methods("GET")
+        api.HandleFunc("/driver/payout-account", updatePayoutAccountHandler).Methods("PUT")
+        api.HandleFunc("/driver/payouts", listPayoutsHandler).Methods("GET")
+
+        api.HandleFunc("/fleet", createFleetHandler).Methods("POST")
+        api.HandleFunc("/fleet", getFleetHandler).Methods("GET")
+        api.HandleFunc("/fleet/vehicles", addFleetVehicleHandler).Methods("POST")
+        api.HandleFunc("/fleet/vehicles/{id}", updateFleetVehicleHandler).Methods("PATCH")
+        api.HandleFunc("/fleet/vehicles/{id}/drivers/{driver_id}", authoriseFleetDriverHandler).Methods("PUT")
+        api.HandleFunc("/fleet/vehicles/{id}/drivers/{driver_id}", revokeFleetDriverHandler).Methods("DELETE")
+        api.HandleFunc("/fleet/drivers", addFleetDriverHandler).Methods("POST")
+        api.HandleFunc("/fleet/drivers/{id}", removeFleetDriverHandler).Methods("DELETE")
+        api.HandleFunc("/fleet/trips", fleetTripsHandler).Methods("GET")
+        api.HandleFunc("/fleet/earnings", fleetEarningsHandler).Methods("GET")
+
+        api.HandleFunc("/admin/promos", createPromoHandler).Methods("POST")
+        api.HandleFunc("/admin/promos", listPromosHandler).Methods("GET")
+        api.HandleFunc("/admin/rides/{id}/adjust-fare", adjustFareHandler).Methods("POST")
+        api.HandleFunc("/admin/rating-reviews", listRatingReviewsHandler).Methods("GET")
+        api.HandleFunc("/admin/rating-reviews/{id}", updateRatingReviewHandler).Methods("PATCH")
+        api.HandleFunc("/admin/proxy-numbers", listProxyNumbersHandler).Methods("GET")
+        api.HandleFunc("/admin/proxy-numbers", addProxyNumbersHandler).Methods("POST")
+        api.HandleFunc("/admin/proxy-numbers/{number}", retireProxyNumberHandler).Methods("DELETE")
+        api.HandleFunc("/admin/incidents", listIncidentsHandler).Methods("GET")
+        api.HandleFunc("/admin/incidents/{id}", getIncidentHandler).Methods("GET")
+        api.HandleFunc("/admin/incidents/{id}", updateIncidentHandler).Methods("PATCH")
+        api.HandleFunc("/admin/priority-rides", listPriorityRidesHandler).Methods("GET")
+        api.HandleFunc("/admin/driver-applications", listApplicationsHandler).Methods("GET")
+        api.HandleFunc("/admin/driver-applications/{id}/documents/{type}", documentFileHandler).Methods("GET")
+        api.HandleFunc("/admin/driver-applications/{id}/approve", approveApplicationHandler).Methods("POST")
+        api.HandleFunc("/admin/driver-applications/{id}/reject", rejectApplicationHandler).Methods("POST")
 
         r.HandleFunc("/payment/initiate", initiatePaymentHandler).Methods("POST")
 		r.HandleFunc("/payment/verify", verifyPaymentHandler).Methods("POST")
@@ -180,10 +295,90 @@ func configureRouter() *mux.Router {
                 "auth_validate": "GET /auth/validate",
                 "auth_logout":   "POST /auth/logout (protected)",
                 "request_ride":  "POST /request-ride (protected)",
+                "quote_ride":    "POST /rides/quote (protected)",
                 "list_drivers":  "GET /drivers (protected)",
+                "driver_location": "GET /drivers/:id/location (protected, assigned rider or admin)",
                 "ride_status":   "GET /ride-status/:id (protected)",
+                "ride_history":  "GET /rides?status=&from=&to=&limit=&cursor= (protected)",
+                "accept_ride":   "POST /rides/:id/accept (protected, driver)",
+                "decline_ride":  "POST /rides/:id/decline (protected, driver)",
+                "arrive_ride":   "POST /rides/:id/arrive (protected, driver)",
+                "start_ride":    "POST /rides/:id/start (protected, driver)",
+                "ride_location": "POST /rides/:id/location (protected, driver)",
+                "ride_track":    "GET /rides/:id/track (protected)",
+                "share_trip":    "POST /rides/:id/share (protected)",
+                "revoke_share":  "DELETE /rides/:id/share (protected)",
+                "shared_trip":   "GET /share/:token",
+                "shared_trip_events": "GET /share/:token/events (server-sent events)",
+                "ride_sos":      "POST /rides/:id/sos (protected)",
+                "cancel_ride":   "POST /rides/:id/cancel (protected)",
+                "cancel_verify": "POST /rides/:id/cancel/verify (protected)",
+                "complete_ride": "POST /rides/:id/complete (protected, driver)",
+                "tip_ride":      "POST /rides/:id/tip (protected)",
+                "tip_verify":    "POST /rides/:id/tip/verify (protected)",
+                "ride_receipt":  "GET /rides/:id/receipt?format=json|pdf&version= (protected)",
+                "rate_ride":     "POST /rides/:id/rating (protected, rider or driver)",
+                "ride_messages": "GET /rides/:id/messages?after=, POST /rides/:id/messages (protected, rider or driver)",
+                "ack_messages":  "POST /rides/:id/messages/ack (protected, rider or driver)",
+                "chat_templates": "GET /chat/templates (protected)",
+                "ride_call":     "POST /rides/:id/call (protected, rider or driver)",
+                "inbound_call":  "POST /calls/inbound (telephony provider webhook)",
+                "places_search": "GET /places/search?q=&lat=&lng=&limit= (protected)",
+                "places_autocomplete": "GET /places/autocomplete?q=&lat=&lng=&limit= (protected)",
+                "recent_destinations": "GET /places/recent?limit= (protected)",
+                "saved_places":  "GET /saved-places (protected)",
+                "save_place":    "POST /saved-places (protected)",
+                "update_saved_place": "PUT /saved-places/:id (protected)",
+                "delete_saved_place": "DELETE /saved-places/:id (protected)",
+                "rider_notifications": "GET /rider/notifications (protected)",
+                "rider_rating":  "GET /rider/rating (protected)",
+                "rider_phone":   "PUT /rider/phone (protected)",
+                "rider_dues":    "GET /rider/dues, POST /rider/dues/:id/pay, POST /rider/dues/:id/verify (protected)",
+                "trusted_contacts": "GET /trusted-contacts (protected)",
+                "add_trusted_contact": "POST /trusted-contacts (protected)",
+                "delete_trusted_contact": "DELETE /trusted-contacts/:id (protected)",
+                "wallet":        "GET /wallet (protected)",
+                "wallet_topup":  "POST /wallet/topup (protected)",
+                "topup_verify":  "POST /wallet/topup/verify (protected)",
+                "driver_application": "GET|PUT /driver/application (protected, driver)",
+                "upload_document":    "PUT /driver/application/documents/:type (protected, driver, multipart)",
+                "submit_application": "POST /driver/application/submit (protected, driver)",
+                "driver_vehicles":    "GET|POST /driver/vehicles (protected, driver)",
+                "select_vehicle":     "PUT /driver/vehicle (protected, driver)",
+                "driver_online":  "POST /driver/online (protected, driver)",
+                "driver_offline": "POST /driver/offline (protected, driver)",
+                "driver_heartbeat": "POST /driver/heartbeat (protected, driver)",
+                "driver_shifts":  "GET /driver/shifts (protected, driver)",
+                "driver_rating":  "GET /driver/rating (protected, driver)",
+                "driver_trips":   "GET /driver/trips?status=&from=&to=&limit=&cursor= (protected, driver)",
+                "driver_balance": "GET /driver/balance (protected, driver)",
+                "settle_balance": "POST /driver/balance/settle (protected, driver)",
+                "settle_verify":  "POST /driver/balance/settle/verify (protected, driver)",
+                "driver_earnings": "GET /driver/earnings (protected, driver)",
+                "payout_account":  "PUT /driver/payout-account (protected, driver)",
+                "driver_payouts":  "GET /driver/payouts (protected, driver)",
+                "fleet":            "GET|POST /fleet (protected, fleet owner)",
+                "fleet_vehicles":   "POST /fleet/vehicles, PATCH /fleet/vehicles/:id (protected, fleet owner)",
+                "fleet_authorise":  "PUT|DELETE /fleet/vehicles/:id/drivers/:driver_id (protected, fleet owner)",
+                "fleet_drivers":    "POST /fleet/drivers, DELETE /fleet/drivers/:id (protected, fleet owner)",
+                "fleet_trips":      "GET /fleet/trips?vehicle_id=&driver_id=&limit= (protected, fleet owner)",
+                "fleet_earnings":   "GET /fleet/earnings?days= (protected, fleet owner)",
+                "create_promo":    "POST /admin/promos (protected, admin)",
+                "list_promos":     "GET /admin/promos (protected, admin)",
+                "adjust_fare":     "POST /admin/rides/:id/adjust-fare (protected, admin)",
+                "rating_reviews":  "GET /admin/rating-reviews?status=, PATCH /admin/rating-reviews/:id (protected, admin)",
+                "proxy_numbers":   "GET|POST /admin/proxy-numbers, DELETE /admin/proxy-numbers/:number (protected, admin)",
+                "list_incidents":  "GET /admin/incidents?status= (protected, admin)",
+                "get_incident":    "GET /admin/incidents/:id (protected, admin)",
+                "update_incident": "PATCH /admin/incidents/:id (protected, admin)",
+                "priority_rides":  "GET /admin/priority-rides (protected, admin)",
+                "list_applications":   "GET /admin/driver-applications?status= (protected, admin)",
+                "application_document": "GET /admin/driver-applications/:id/documents/:type (protected, admin)",
+                "approve_application": "POST /admin/driver-applications/:id/approve (protected, admin)",
+                "reject_application":  "POST /admin/driver-applications/:id/reject (protected, admin)",
                 "metrics":       "GET /metrics",
-                "websocket":     "GET /ws?driver_id=DRIVER_ID",
+                "websocket":     "GET /ws?driver_id=DRIVER_ID&token=TOKEN",
+                "rider_websocket": "GET /ws/rider?ride_id=RIDE_ID&token=TOKEN&last_event_id=",
             },
         })
     })
@@ -229,6 +424,40 @@ func getPort() string {
 	return "8080"
 }
 
+// envFloat reads a numeric setting, falling back to def when unset or invalid.
+func envFloat(name string, def float64) float64 {
+	if v := os.Getenv(name); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+		log.Printf("Ignoring invalid %s=%q", name, v)
+	}
+	return def
+}
+
+// envInt reads an integer setting, falling back to def when unset or invalid.
+func envInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+		log.Printf("Ignoring invalid %s=%q", name, v)
+	}
+	return def
+}
+
+// envDuration reads a duration setting such as "15m", falling back to def
+// when unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Ignoring invalid %s=%q", name, v)
+	}
+	return def
+}
+
 func initiatePaymentHandler(w http.ResponseWriter, r *http.Request) {
 	claims, err := validateRequest(r)
 	if err != nil {
@@ -237,15 +466,21 @@ func initiatePaymentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		RideID string  `json:"ride_id"`
-		Amount float64 `json:"amount"`
+		RideID   string `json:"ride_id"`
+		Amount   Money  `json:"amount"`
+		Currency string `json:"currency"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
 		return
 	}
+	currency, err := lookupCurrency(req.Currency)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
 
-	paymentLink, err := ProcessPayment(req.RideID, req.Amount, claims.Email)
+	paymentLink, err := ProcessPayment(req.RideID, req.Amount, currency.Code, claims.Email)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
 		return
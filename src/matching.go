@@ -4,14 +4,12 @@ import (
     "context"
     "encoding/json"
     "log"
-    "math"
     "net/http"
     "strings"
     "time"
     "errors"
 
     "github.com/gorilla/mux"
-    "github.com/redis/go-redis/v9"
     "github.com/jackc/pgx/v5"
 )
 
@@ -21,6 +19,12 @@ type RideRequest struct {
     DropoffLat float64 `json:"dropoff_lat,omitempty"`
     DropoffLng float64 `json:"dropoff_lng,omitempty"`
     VehicleType string `json:"vehicle_type,omitempty"`
+    PaymentMethod string `json:"payment_method,omitempty"`
+    PromoCode   string  `json:"promo_code,omitempty"`
+    // Place IDs from /places/search or saved places ("saved:<id>") can be
+    // sent instead of coordinates.
+    PickupPlaceID  string `json:"pickup_place_id,omitempty"`
+    DropoffPlaceID string `json:"dropoff_place_id,omitempty"`
 }
 
 type RideResponse struct {
@@ -44,8 +48,6 @@ type Driver struct {
 const (
     maxMatchingAttempts = 3
     searchRadiusKm      = 5.0
-    baseFare           = 5.0
-    pricePerKm         = 1.5
 )
 
 func rideStatusHandler(w http.ResponseWriter, r *http.Request) {
@@ -60,17 +62,21 @@ func rideStatusHandler(w http.ResponseWriter, r *http.Request) {
 
     var status RideStatus
     err := dbPool.QueryRow(context.Background(),
-        `SELECT id, driver_id, rider_id, status, price_estimate, estimated_eta, created_at, updated_at
+        `SELECT id, driver_id, rider_id, status, currency, price_estimate, estimated_eta,
+                COALESCE(pickup_address, ''), COALESCE(dropoff_address, ''), created_at, updated_at
          FROM rides WHERE id = $1 AND (rider_id = $2 OR driver_id = $3)`,
         rideID, claims.UserID, claims.Username).Scan(
         &status.ID, &status.DriverID, &status.RiderID,
-        &status.Status, &status.Price, &status.ETA,
-        &status.CreatedAt, &status.UpdatedAt)
+        &status.Status, &status.Currency, &status.Price, &status.ETA,
+        &status.PickupAddress, &status.DropoffAddress, &status.CreatedAt, &status.UpdatedAt)
 
     if err != nil {
         respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
         return
     }
+    if claims.Username == status.DriverID {
+        status.RiderRating = riderRating(r.Context(), dbPool, status.RiderID)
+    }
 
     respondJSON(w, http.StatusOK, status)
 }
@@ -89,6 +95,10 @@ func requestRideHandler(w http.ResponseWriter, r *http.Request) {
         respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request format"))
         return
     }
+    if err := resolveRidePlaces(r.Context(), claims.UserID, &req); err != nil {
+        respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+        return
+    }
 
     // Validate coordinates
     if !validCoordinates(req.PickupLat, req.PickupLng) {
@@ -96,16 +106,57 @@ func requestRideHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    if req.PaymentMethod == "" {
+        req.PaymentMethod = paymentMethodFlutterwave
+    }
+    if !validPaymentMethod(req.PaymentMethod) {
+        respondJSON(w, http.StatusBadRequest, errorResponse("Invalid payment method"))
+        return
+    }
+    req.VehicleType = strings.ToLower(strings.TrimSpace(req.VehicleType))
+    if _, ok := vehicleClasses[req.VehicleType]; req.VehicleType != "" && !ok {
+        respondJSON(w, http.StatusBadRequest, errorResponse("Invalid vehicle type"))
+        return
+    }
+
+    // Riders pay what they owe on earlier rides first
+    owing, err := hasPendingDues(r.Context(), claims.UserID)
+    if err != nil {
+        respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+        return
+    }
+    if owing {
+        respondJSON(w, http.StatusPaymentRequired, errorResponse(errOutstandingDues.Error()))
+        return
+    }
+
     // Find and assign driver
     result, err := matchDriver(claims.UserID, req)
+    if errors.Is(err, errInsufficientWalletFunds) {
+        respondJSON(w, http.StatusPaymentRequired, errorResponse(err.Error()))
+        return
+    }
+    if errors.Is(err, errCurrencyMismatch) {
+        respondJSON(w, http.StatusConflict, errorResponse("wallet currency does not match the ride currency"))
+        return
+    }
+    if errors.Is(err, errInvalidPromo) {
+        respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+        return
+    }
     if err != nil {
         log.Printf("Ride matching failed: %v", err)
         respondJSON(w, http.StatusServiceUnavailable, errorResponse(err.Error()))
         return
     }
 
-    // Cache driver location
-    go cacheDriverLocation(result.DriverID, req.PickupLat, req.PickupLng)
+    go storeRideAddresses(result.ID, req.PickupLat, req.PickupLng, req.DropoffLat, req.DropoffLng)
+    publishRideStatus(result)
+    NotifyDriver(result.DriverID, map[string]interface{}{
+        "type":         "pending_ride",
+        "ride_id":      result.ID,
+        "rider_rating": riderRating(r.Context(), dbPool, claims.UserID),
+    })
 
     respondJSON(w, http.StatusOK, successResponse(result))
 }
@@ -124,11 +175,22 @@ func matchDriver(riderID int, req RideRequest) (*RideStatus, error) {
     // Try multiple times to find a driver
     for attempt := 0; attempt < maxMatchingAttempts; attempt++ {
         match, lastErr = findNearestDriver(ctx, tx.(pgx.Tx), riderID, req)
-        if lastErr == nil {
+        if lastErr == nil || errors.Is(lastErr, errInvalidPromo) {
             break
         }
         time.Sleep(time.Duration(attempt+1) * 500 * time.Millisecond)
     }
+    if lastErr != nil {
+        return nil, lastErr
+    }
+
+    // Reserve the quoted fare on the rider's wallet; if the wallet cannot
+    // cover it the whole match is rolled back.
+    if req.PaymentMethod == paymentMethodWallet {
+        if err := placeWalletHold(ctx, tx, riderID, match.ID, match.Price, match.Currency); err != nil {
+            return nil, err
+        }
+    }
 
     if err := tx.Commit(ctx); err != nil {
         return nil, errors.New("failed to commit transaction")
@@ -138,44 +200,18 @@ func matchDriver(riderID int, req RideRequest) (*RideStatus, error) {
 }
 
 func findNearestDriver(ctx context.Context, tx pgx.Tx, riderID int, req RideRequest) (*RideStatus, error) {
-    var driver struct {
-        ID       string
-        Name     string
-        Rating   float64
-        Vehicle  string
-        Distance float64 // in meters
-    }
-
-    // Find nearest available driver within radius
-    err := tx.QueryRow(ctx,
-        `SELECT 
-            d.driver_id,
-            d.name,
-            d.rating,
-            d.vehicle_model,
-            ST_DistanceSphere(
-                d.current_location, 
-                ST_SetSRID(ST_MakePoint($1, $2), 4326)
-            ) AS distance
-        FROM drivers d
-        WHERE d.available = true
-        AND ST_DWithin(
-            d.current_location,
-            ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography,
-            $3 * 1000)  -- Convert km to meters
-        ORDER BY distance
-        LIMIT 1
-        FOR UPDATE SKIP LOCKED`,
-        req.PickupLng, req.PickupLat, searchRadiusKm).Scan(
-        &driver.ID, &driver.Name, &driver.Rating, &driver.Vehicle, &driver.Distance)
-
+    driver, err := nearestIdleDriver(ctx, tx, req.PickupLat, req.PickupLng, req.VehicleType, "")
     if err != nil {
-        return nil, errors.New("no available drivers nearby")
+        return nil, err
     }
 
     // Calculate price and ETA
     distanceKm := driver.Distance / 1000
-    price := calculatePrice(distanceKm)
+    quote, promo, err := priceRide(ctx, tx, riderID, req, tripDistanceKm(req, distanceKm))
+    if err != nil {
+        return nil, err
+    }
+    price := quote.Total
     eta := calculateETA(distanceKm)
 
     // Create ride record
@@ -184,26 +220,31 @@ func findNearestDriver(ctx context.Context, tx pgx.Tx, riderID int, req RideRequ
         `INSERT INTO rides (
             driver_id, rider_id, status, 
             start_location, end_location,
-            estimated_eta, price_estimate
+            estimated_eta, price_estimate, currency, payment_method,
+            promo_code, discount_amount, fare_breakdown, vehicle_id
         ) VALUES ($1, $2, 'requested',
             ST_SetSRID(ST_MakePoint($3, $4), 4326),
             ST_SetSRID(ST_MakePoint($5, $6), 4326),
-            $7, $8)
+            $7, $8, $9, $10, NULLIF($11, ''), $12, $13, $14)
         RETURNING id`,
         driver.ID, riderID,
         req.PickupLng, req.PickupLat,
         req.DropoffLng, req.DropoffLat,
-        eta, price).Scan(&rideID)
+        eta, price, quote.Currency, req.PaymentMethod,
+        quote.PromoCode, quote.Discount, quote, driver.VehicleID).Scan(&rideID)
 
     if err != nil {
         return nil, errors.New("failed to create ride record")
     }
 
-    // Mark driver as unavailable
-    _, err = tx.Exec(ctx,
-        `UPDATE drivers SET available = false WHERE driver_id = $1`,
-        driver.ID)
-    if err != nil {
+    if promo != nil {
+        if err := recordPromoRedemption(ctx, tx, promo, riderID, rideID, quote.Discount, quote.Currency); err != nil {
+            return nil, err
+        }
+    }
+
+    // Hold the driver for this ride
+    if err := setDriverState(ctx, tx, driver.ID, driverStateOffered); err != nil {
         return nil, errors.New("failed to update driver status")
     }
 
@@ -217,27 +258,68 @@ func findNearestDriver(ctx context.Context, tx pgx.Tx, riderID int, req RideRequ
     }
 
     return &RideStatus{
-        ID:        rideID,
-        DriverID:  driver.ID,
-        RiderID:   riderID,
-        Status:    "requested",
-        Price:     price,
-        ETA:       eta,
-        CreatedAt: time.Now(),
+        ID:            rideID,
+        DriverID:      driver.ID,
+        RiderID:       riderID,
+        Status:        "requested",
+        Currency:      quote.Currency,
+        Price:         price,
+        Discount:      quote.Discount,
+        PromoCode:     quote.PromoCode,
+        PaymentMethod: req.PaymentMethod,
+        ETA:           eta,
+        CreatedAt:     time.Now(),
     }, nil
 }
 
-func calculatePrice(distanceKm float64) float64 {
-    price := baseFare + (distanceKm * pricePerKm)
-    
-    // Apply surge pricing during rush hours
-    hour := time.Now().Hour()
-    if (hour >= 7 && hour <= 9) || (hour >= 17 && hour <= 19) {
-        price *= 1.2 // 20% surge
-    }
+// matchedDriver is the driver picked for a ride offer.
+type matchedDriver struct {
+    ID        string
+    Name      string
+    Rating    float64
+    VehicleID int64
+    Vehicle   string
+    Distance  float64 // in meters
+}
 
-    // Round to 2 decimal places
-    return math.Round(price*100) / 100
+// nearestIdleDriver finds and locks the nearest idle driver within
+// searchRadiusKm of the pickup whose onboarding is approved, in a vehicle
+// of class if one is given. Drivers already offered rideID are skipped, so
+// a ride that is re-offered goes to someone new.
+func nearestIdleDriver(ctx context.Context, tx pgx.Tx, lat, lng float64, class, rideID string) (*matchedDriver, error) {
+    var driver matchedDriver
+    err := tx.QueryRow(ctx,
+        `SELECT 
+            d.driver_id,
+            d.name,
+            d.rating,
+            v.id,
+            `+vehicleLabelSQL+`,
+            ST_DistanceSphere(
+                d.current_location, 
+                ST_SetSRID(ST_MakePoint($1, $2), 4326)
+            ) AS distance
+        FROM drivers d
+        JOIN vehicles v ON v.id = d.vehicle_id AND v.active
+        WHERE d.state = 'idle'
+        AND `+eligibleDriverSQL+`
+        AND ($4 = '' OR v.class = $4)
+        AND NOT EXISTS (
+            SELECT 1 FROM driver_notifications n
+            WHERE n.driver_id = d.driver_id AND n.ride_id::text = $5)
+        AND ST_DWithin(
+            d.current_location,
+            ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography,
+            $3 * 1000)  -- Convert km to meters
+        ORDER BY distance
+        LIMIT 1
+        FOR UPDATE OF d SKIP LOCKED`,
+        lng, lat, searchRadiusKm, class, rideID).Scan(
+        &driver.ID, &driver.Name, &driver.Rating, &driver.VehicleID, &driver.Vehicle, &driver.Distance)
+    if err != nil {
+        return nil, errors.New("no available drivers nearby")
+    }
+    return &driver, nil
 }
 
 func calculateETA(distanceKm float64) int {
@@ -248,14 +330,15 @@ func calculateETA(distanceKm float64) int {
 func listDriversHandler(w http.ResponseWriter, r *http.Request) {
     rows, err := dbPool.Query(r.Context(),
         `SELECT 
-            driver_id, 
-            ST_X(current_location::geometry) as lat, 
-            ST_Y(current_location::geometry) as lng,
-            name,
-            rating,
-            vehicle_model
-        FROM drivers 
-        WHERE available = true`)
+            d.driver_id, 
+            ST_Y(d.current_location::geometry) as lat,
+            ST_X(d.current_location::geometry) as lng,
+            d.name,
+            d.rating,
+            `+vehicleLabelSQL+`
+        FROM drivers d
+        JOIN vehicles v ON v.id = d.vehicle_id AND v.active
+        WHERE d.state = 'idle' AND `+eligibleDriverSQL)
     if err != nil {
         respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
         return
@@ -308,9 +391,5 @@ func successResponse(data interface{}) RideResponse {
 }
 
 func cacheDriverLocation(driverID string, lat, lng float64) error {
-    return redisClient.GeoAdd(context.Background(), "drivers", &redis.GeoLocation{
-        Name:      driverID,
-        Longitude: lng,
-        Latitude:  lat,
-    }).Err()
+    return recordDriverLocation(context.Background(), driverID, lat, lng, time.Now())
 }
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// TrackPoint is one GPS breadcrumb reported by the driver's app.
+type TrackPoint struct {
+	Lat        float64   `json:"lat"`
+	Lng        float64   `json:"lng"`
+	Accuracy   float64   `json:"accuracy,omitempty"` // metres, 0 if unknown
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// TrackStats summarises a filtered trip track.
+type TrackStats struct {
+	DistanceKm float64 `json:"distance_km"`
+	Points     int     `json:"points"`
+	Dropped    int     `json:"dropped"`
+}
+
+const (
+	defaultFareTolerance = 0.15
+	maxPingsPerRequest   = 500
+	maxPingAge           = 24 * time.Hour
+	maxPingAccuracyM     = 50.0
+	maxPlausibleKmh      = 160.0
+	minMovementKm        = 0.01 // ignore jitter below 10m while stopped
+)
+
+var errNoTrack = errors.New("not enough GPS points to meter the trip")
+
+func recordRideLocationHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	var req struct {
+		Points []TrackPoint `json:"points"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	if len(req.Points) == 0 || len(req.Points) > maxPingsPerRequest {
+		respondJSON(w, http.StatusBadRequest, errorResponse(fmt.Sprintf("send between 1 and %d points", maxPingsPerRequest)))
+		return
+	}
+	now := time.Now().UTC()
+	for i := range req.Points {
+		p := &req.Points[i]
+		if !validCoordinates(p.Lat, p.Lng) || p.Accuracy < 0 {
+			respondJSON(w, http.StatusBadRequest, errorResponse("Invalid coordinates"))
+			return
+		}
+		if p.RecordedAt.IsZero() {
+			p.RecordedAt = now
+		}
+		if p.RecordedAt.After(now.Add(time.Minute)) || p.RecordedAt.Before(now.Add(-maxPingAge)) {
+			respondJSON(w, http.StatusBadRequest, errorResponse("recorded_at must be within the last 24 hours"))
+			return
+		}
+	}
+
+	rideID := mux.Vars(r)["id"]
+	wp, err := recordRideLocations(r.Context(), rideID, claims.Username, req.Points)
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+
+	last := req.Points[len(req.Points)-1]
+	go recordDriverLocation(context.Background(), claims.Username, last.Lat, last.Lng, last.RecordedAt)
+	if err := touchDriver(r.Context(), claims.Username); err != nil {
+		log.Printf("Failed to record heartbeat for driver %s: %v", claims.Username, err)
+	}
+	publishDriverLocation(rideID, wp, last)
+
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"ride_id":  rideID,
+		"recorded": len(req.Points),
+	}))
+}
+
+// rideWaypoints is what a location ping is measured against for the ETA.
+type rideWaypoints struct {
+	Status     string
+	PickupLat  float64
+	PickupLng  float64
+	DropoffLat float64
+	DropoffLng float64
+}
+
+// recordRideLocations stores breadcrumbs for a ride the driver is currently
+// on. Points from the approach to pickup are kept too but only those after
+// the trip started are metered.
+func recordRideLocations(ctx context.Context, rideID, driverID string, points []TrackPoint) (*rideWaypoints, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	var owner string
+	var wp rideWaypoints
+	err = tx.QueryRow(ctx,
+		`SELECT driver_id, status,
+		        ST_Y(start_location::geometry), ST_X(start_location::geometry),
+		        COALESCE(ST_Y(end_location::geometry), 0), COALESCE(ST_X(end_location::geometry), 0)
+		 FROM rides WHERE id = $1`,
+		rideID).Scan(&owner, &wp.Status, &wp.PickupLat, &wp.PickupLng, &wp.DropoffLat, &wp.DropoffLng)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && owner != driverID) {
+		return nil, errRideNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ride: %w", err)
+	}
+	switch wp.Status {
+	case rideStatusAccepted, rideStatusArrived, rideStatusInProgress:
+	default:
+		return nil, errInvalidRideTransition
+	}
+
+	for _, p := range points {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO ride_locations (ride_id, lat, lng, accuracy, recorded_at)
+			 VALUES ($1, $2, $3, NULLIF($4, 0), $5)`,
+			rideID, p.Lat, p.Lng, p.Accuracy, p.RecordedAt); err != nil {
+			return nil, fmt.Errorf("failed to store location: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return &wp, nil
+}
+
+// etaFrom returns minutes from a driver at lat, lng to the pickup or, once
+// the trip has started, to the dropoff. ok is false when there is no dropoff
+// to measure against.
+func (wp *rideWaypoints) etaFrom(lat, lng float64) (eta int, ok bool) {
+	switch {
+	case wp.Status == rideStatusArrived:
+		return 0, true
+	case wp.Status != rideStatusInProgress:
+		return etaMinutes(haversineKm(lat, lng, wp.PickupLat, wp.PickupLng)), true
+	case wp.DropoffLat != 0 || wp.DropoffLng != 0:
+		return etaMinutes(haversineKm(lat, lng, wp.DropoffLat, wp.DropoffLng)), true
+	}
+	return 0, false
+}
+
+// publishDriverLocation streams the driver's latest position to the rider
+// with an ETA to the pickup or, once the trip has started, to the dropoff.
+func publishDriverLocation(rideID string, wp *rideWaypoints, p TrackPoint) {
+	data := map[string]interface{}{
+		"lat":         p.Lat,
+		"lng":         p.Lng,
+		"recorded_at": p.RecordedAt,
+		"status":      wp.Status,
+	}
+	if eta, ok := wp.etaFrom(p.Lat, p.Lng); ok {
+		data["eta"] = eta
+	}
+	if err := publishRideEvent(context.Background(), rideID, "location", data); err != nil {
+		log.Printf("Failed to publish location for ride %s: %v", rideID, err)
+	}
+}
+
+// measureTrack returns the distance travelled along a time-ordered track.
+// Inaccurate fixes, out-of-order points and single-point spikes are dropped;
+// jumps that would need an implausible speed (teleports, e.g. after a GPS
+// reset) are not counted as distance; and movement under minMovementKm is
+// treated as jitter.
+func measureTrack(points []TrackPoint) TrackStats {
+	var kept []TrackPoint
+	stats := TrackStats{}
+	for _, p := range points {
+		if p.Accuracy > maxPingAccuracyM {
+			stats.Dropped++
+			continue
+		}
+		if n := len(kept); n > 0 && !p.RecordedAt.After(kept[n-1].RecordedAt) {
+			stats.Dropped++
+			continue
+		}
+		kept = append(kept, p)
+	}
+
+	// A spike jumps away and straight back: both legs are implausibly fast
+	// but skipping the point leaves a plausible one.
+	filtered := kept[:0:0]
+	for i, p := range kept {
+		if i > 0 && i < len(kept)-1 {
+			prev := filtered[len(filtered)-1]
+			next := kept[i+1]
+			if speedKmh(prev, p) > maxPlausibleKmh && speedKmh(p, next) > maxPlausibleKmh &&
+				speedKmh(prev, next) <= maxPlausibleKmh {
+				stats.Dropped++
+				continue
+			}
+		}
+		filtered = append(filtered, p)
+	}
+
+	stats.Points = len(filtered)
+	if len(filtered) < 2 {
+		return stats
+	}
+	anchor := filtered[0]
+	for _, p := range filtered[1:] {
+		d := haversineKm(anchor.Lat, anchor.Lng, p.Lat, p.Lng)
+		if d < minMovementKm {
+			continue
+		}
+		if speedKmh(anchor, p) <= maxPlausibleKmh {
+			stats.DistanceKm += d
+		}
+		anchor = p
+	}
+	stats.DistanceKm = math.Round(stats.DistanceKm*1000) / 1000
+	return stats
+}
+
+func speedKmh(a, b TrackPoint) float64 {
+	hours := b.RecordedAt.Sub(a.RecordedAt).Hours()
+	if hours <= 0 {
+		return math.Inf(1)
+	}
+	return haversineKm(a.Lat, a.Lng, b.Lat, b.Lng) / hours
+}
+
+// fareTolerance is how far, as a fraction of the upfront quote, the metered
+// fare may drift before the rider is charged the metered fare instead.
+func fareTolerance() float64 {
+	t := envFloat("FARE_TOLERANCE", defaultFareTolerance)
+	if t < 0 {
+		return defaultFareTolerance
+	}
+	return t
+}
+
+// chooseFare applies the tolerance rule: riders pay the upfront quote unless
+// the metered fare differs from it by more than tolerance, in either
+// direction.
+func chooseFare(quoted, metered Money, tolerance float64) (Money, string) {
+	diff := metered - quoted
+	if diff < 0 {
+		diff = -diff
+	}
+	if quoted > 0 && float64(diff) <= float64(quoted)*tolerance {
+		return quoted, "quote"
+	}
+	return metered, "metered"
+}
+
+// meterFare reprices quote for the distance and duration actually travelled,
+// keeping the quoted base fare, rates, surge and discount.
+func meterFare(quote FareQuote, distanceKm float64, duration time.Duration) *FareQuote {
+	metered := quote
+	metered.price(distanceKm, duration.Minutes())
+	return &metered
+}
+
+// meterRide computes the metered fare for a ride from its recorded track
+// between starting and end, stores both it and the basis chosen against the
+// upfront quote, and returns the fare the rider pays before waiting charges.
+// Rides that were never started or have too few usable points keep their
+// quoted price.
+func meterRide(ctx context.Context, tx pgx.Tx, ride *RideStatus, end time.Time) (Money, error) {
+	ride.FareBasis = "quote"
+	fare := ride.Price
+
+	var distanceKm *float64
+	var durationS *int
+	var breakdown *FareQuote
+	metered, stats, err := meterRideTrack(ctx, tx, ride, end)
+	switch {
+	case errors.Is(err, errNoTrack):
+		log.Printf("Ride %s charged at quote: %v", ride.ID, err)
+	case err != nil:
+		return 0, err
+	default:
+		seconds := int(end.Sub(*ride.StartedAt).Seconds())
+		distanceKm, durationS, breakdown = &stats.DistanceKm, &seconds, metered
+		ride.MeteredFare = metered.Total
+		ride.DistanceKm = stats.DistanceKm
+		fare, ride.FareBasis = chooseFare(ride.Price, metered.Total, fareTolerance())
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE rides
+		 SET metered_fare = $1, actual_distance_km = $2, actual_duration_s = $3,
+		     fare_basis = $4, metered_breakdown = $5
+		 WHERE id = $6`,
+		breakdownTotal(breakdown), distanceKm, durationS, ride.FareBasis, breakdown, ride.ID); err != nil {
+		return 0, fmt.Errorf("failed to store metered fare: %w", err)
+	}
+	return fare, nil
+}
+
+func breakdownTotal(q *FareQuote) *Money {
+	if q == nil {
+		return nil
+	}
+	return &q.Total
+}
+
+func meterRideTrack(ctx context.Context, tx pgx.Tx, ride *RideStatus, end time.Time) (*FareQuote, TrackStats, error) {
+	if ride.StartedAt == nil {
+		return nil, TrackStats{}, errNoTrack
+	}
+
+	var breakdown []byte
+	if err := tx.QueryRow(ctx, `SELECT fare_breakdown FROM rides WHERE id = $1`, ride.ID).Scan(&breakdown); err != nil {
+		return nil, TrackStats{}, fmt.Errorf("failed to load fare breakdown: %w", err)
+	}
+	if len(breakdown) == 0 {
+		return nil, TrackStats{}, errNoTrack
+	}
+	var quote FareQuote
+	if err := json.Unmarshal(breakdown, &quote); err != nil {
+		return nil, TrackStats{}, fmt.Errorf("invalid fare breakdown: %w", err)
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT lat, lng, COALESCE(accuracy, 0), recorded_at
+		 FROM ride_locations
+		 WHERE ride_id = $1 AND recorded_at >= $2 AND recorded_at <= $3
+		 ORDER BY recorded_at`,
+		ride.ID, *ride.StartedAt, end)
+	if err != nil {
+		return nil, TrackStats{}, fmt.Errorf("failed to load track: %w", err)
+	}
+	points, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (TrackPoint, error) {
+		var p TrackPoint
+		err := row.Scan(&p.Lat, &p.Lng, &p.Accuracy, &p.RecordedAt)
+		return p, err
+	})
+	if err != nil {
+		return nil, TrackStats{}, fmt.Errorf("failed to load track: %w", err)
+	}
+
+	stats := measureTrack(points)
+	if stats.Points < 2 {
+		return nil, stats, errNoTrack
+	}
+	return meterFare(quote, stats.DistanceKm, end.Sub(*ride.StartedAt)), stats, nil
+}
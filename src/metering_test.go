@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// track builds points along a meridian, one per minute, at the given
+// latitudes. 0.009 degrees of latitude is roughly 1 km.
+func track(start time.Time, lats ...float64) []TrackPoint {
+	points := make([]TrackPoint, len(lats))
+	for i, lat := range lats {
+		points[i] = TrackPoint{Lat: lat, Lng: 32.58, RecordedAt: start.Add(time.Duration(i) * time.Minute)}
+	}
+	return points
+}
+
+func TestMeasureTrack(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	stats := measureTrack(track(start, 0.300, 0.309, 0.318))
+	if math.Abs(stats.DistanceKm-2.0) > 0.01 || stats.Points != 3 {
+		t.Errorf("Expected about 2 km over 3 points, got %+v", stats)
+	}
+
+	// The third point jumps about 55 km away for one fix and comes back.
+	stats = measureTrack(track(start, 0.300, 0.309, 0.800, 0.318))
+	if math.Abs(stats.DistanceKm-2.0) > 0.01 || stats.Dropped != 1 {
+		t.Errorf("Expected the spike to be dropped, got %+v", stats)
+	}
+
+	// A teleport that stays put is not counted, but travel after it is.
+	stats = measureTrack(track(start, 0.300, 0.309, 0.800, 0.809))
+	if math.Abs(stats.DistanceKm-2.0) > 0.01 {
+		t.Errorf("Expected the teleport to be skipped, got %+v", stats)
+	}
+
+	// Standing still with a few metres of jitter adds nothing.
+	stats = measureTrack(track(start, 0.30000, 0.30003, 0.29998, 0.30002))
+	if stats.DistanceKm != 0 {
+		t.Errorf("Expected jitter to be ignored, got %+v", stats)
+	}
+
+	points := track(start, 0.300, 0.309, 0.318)
+	points[1].Accuracy = 200
+	points = append(points, TrackPoint{Lat: 0.5, Lng: 32.58, RecordedAt: start})
+	stats = measureTrack(points)
+	if stats.Dropped != 2 || math.Abs(stats.DistanceKm-2.0) > 0.01 {
+		t.Errorf("Expected inaccurate and out-of-order points dropped, got %+v", stats)
+	}
+}
+
+func TestChooseFare(t *testing.T) {
+	cases := []struct {
+		metered Money
+		want    Money
+		basis   string
+	}{
+		{10000, 10000, "quote"},
+		{11500, 10000, "quote"},
+		{8500, 10000, "quote"},
+		{11600, 11600, "metered"},
+		{8000, 8000, "metered"},
+	}
+	for _, c := range cases {
+		got, basis := chooseFare(10000, c.metered, 0.15)
+		if got != c.want || basis != c.basis {
+			t.Errorf("metered %d: expected %d (%s), got %d (%s)", c.metered, c.want, c.basis, got, basis)
+		}
+	}
+}
+
+func TestMeterFare(t *testing.T) {
+	offPeak := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	quote := buildFareQuote(&cities[0], 3, offPeak)
+	quote.Discount = 500
+	quote.Total -= 500
+
+	metered := meterFare(*quote, 5.04, 20*time.Minute)
+	if metered.DistanceFare != 5000 || metered.TimeFare != 2000 || metered.Total != 9500 {
+		t.Errorf("Unexpected metered fare: %+v", metered)
+	}
+	if quote.DistanceFare != 3000 {
+		t.Errorf("Metering should not change the quote, got %+v", quote)
+	}
+}
@@ -0,0 +1,51 @@
+-- Payment method chosen at request time and the fare actually charged
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS payment_method VARCHAR(20) NOT NULL DEFAULT 'flutterwave';
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS final_fare NUMERIC(10,2);
+
+-- Rider wallets (held = funds reserved for rides that have not settled yet)
+CREATE TABLE wallets (
+    rider_id INTEGER PRIMARY KEY,
+    balance NUMERIC(12,2) NOT NULL DEFAULT 0 CHECK (balance >= 0),
+    held NUMERIC(12,2) NOT NULL DEFAULT 0 CHECK (held >= 0),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (held <= balance)
+);
+
+-- One hold per wallet-paid ride, captured at the final fare or released
+CREATE TABLE wallet_holds (
+    ride_id UUID PRIMARY KEY REFERENCES rides(id),
+    rider_id INTEGER NOT NULL REFERENCES wallets(rider_id),
+    amount NUMERIC(12,2) NOT NULL CHECK (amount >= 0),
+    captured_amount NUMERIC(12,2),
+    status VARCHAR(20) NOT NULL CHECK (status IN ('held', 'captured', 'released')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Top-ups collected through a payment provider
+CREATE TABLE wallet_topups (
+    tx_ref VARCHAR(100) PRIMARY KEY,
+    rider_id INTEGER NOT NULL,
+    amount NUMERIC(12,2) NOT NULL CHECK (amount > 0),
+    provider VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'completed', 'failed')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Append-only money movements (accounts look like 'wallet:1001')
+CREATE TABLE ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    account VARCHAR(100) NOT NULL,
+    ride_id UUID REFERENCES rides(id),
+    entry_type VARCHAR(30) NOT NULL,
+    amount NUMERIC(12,2) NOT NULL,
+    reference VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_wallet_holds_rider ON wallet_holds(rider_id) WHERE status = 'held';
+CREATE INDEX idx_wallet_topups_rider ON wallet_topups(rider_id);
+CREATE INDEX idx_ledger_account ON ledger_entries(account, created_at DESC);
+CREATE INDEX idx_ledger_ride ON ledger_entries(ride_id);
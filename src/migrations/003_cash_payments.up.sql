@@ -0,0 +1,24 @@
+-- Cash the driver confirmed collecting when completing a cash ride
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS cash_collected NUMERIC(10,2);
+
+-- Running driver balance: positive is owed to the driver, negative is
+-- platform commission the driver owes from cash trips
+CREATE TABLE driver_balances (
+    driver_id VARCHAR(255) PRIMARY KEY REFERENCES drivers(driver_id),
+    balance NUMERIC(12,2) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Mobile money collections that pay down a negative driver balance
+CREATE TABLE driver_settlements (
+    tx_ref VARCHAR(100) PRIMARY KEY,
+    driver_id VARCHAR(255) NOT NULL REFERENCES drivers(driver_id),
+    amount NUMERIC(12,2) NOT NULL CHECK (amount > 0),
+    provider VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'completed', 'failed')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_driver_settlements_driver ON driver_settlements(driver_id);
@@ -0,0 +1,34 @@
+-- Mobile money account drivers are paid out to
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS payout_network VARCHAR(20) CHECK (payout_network IN ('mtn', 'airtel'));
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS payout_phone VARCHAR(20);
+
+-- Per-trip earnings (net_amount = gross_fare - commission)
+CREATE TABLE driver_earnings (
+    ride_id UUID PRIMARY KEY REFERENCES rides(id),
+    driver_id VARCHAR(255) NOT NULL REFERENCES drivers(driver_id),
+    gross_fare NUMERIC(12,2) NOT NULL,
+    commission NUMERIC(12,2) NOT NULL,
+    net_amount NUMERIC(12,2) NOT NULL,
+    payment_method VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Batched mobile money disbursements of driver balances
+CREATE TABLE payouts (
+    id BIGSERIAL PRIMARY KEY,
+    driver_id VARCHAR(255) NOT NULL REFERENCES drivers(driver_id),
+    amount NUMERIC(12,2) NOT NULL CHECK (amount > 0),
+    network VARCHAR(20) NOT NULL,
+    phone VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'processing', 'paid', 'failed', 'returned')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    provider_reference VARCHAR(100),
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_driver_earnings_driver ON driver_earnings(driver_id, created_at);
+CREATE INDEX idx_payouts_driver ON payouts(driver_id, created_at DESC);
+CREATE INDEX idx_payouts_due ON payouts(next_attempt_at) WHERE status IN ('pending', 'failed');
@@ -0,0 +1,31 @@
+-- Promo applied to a ride and the discount taken off its price estimate
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS promo_code VARCHAR(40);
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS discount_amount NUMERIC(10,2) NOT NULL DEFAULT 0;
+
+CREATE TABLE promo_codes (
+    code VARCHAR(40) PRIMARY KEY,
+    discount_type VARCHAR(10) NOT NULL CHECK (discount_type IN ('percent', 'fixed')),
+    discount_value NUMERIC(10,2) NOT NULL CHECK (discount_value > 0),
+    max_discount NUMERIC(10,2),              -- cap for percentage discounts
+    expires_at TIMESTAMP,
+    max_uses INTEGER CHECK (max_uses > 0),   -- global limit, NULL = unlimited
+    per_user_limit INTEGER NOT NULL DEFAULT 1,
+    uses INTEGER NOT NULL DEFAULT 0,
+    city VARCHAR(50),                        -- restrict to an operating city
+    vehicle_class VARCHAR(50),               -- restrict to a vehicle class
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_by INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (max_uses IS NULL OR uses <= max_uses)
+);
+
+CREATE TABLE promo_redemptions (
+    id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(40) NOT NULL REFERENCES promo_codes(code),
+    rider_id INTEGER NOT NULL,
+    ride_id UUID NOT NULL UNIQUE REFERENCES rides(id),
+    discount NUMERIC(10,2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_promo_redemptions_rider ON promo_redemptions(code, rider_id);
@@ -0,0 +1,32 @@
+-- Tips are passed to the driver in full
+ALTER TABLE driver_earnings ADD COLUMN IF NOT EXISTS tip NUMERIC(12,2) NOT NULL DEFAULT 0;
+
+CREATE TABLE tips (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    rider_id INTEGER NOT NULL,
+    driver_id VARCHAR(255) NOT NULL REFERENCES drivers(driver_id),
+    amount NUMERIC(10,2) NOT NULL CHECK (amount > 0),
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'completed', 'failed')),
+    tx_ref VARCHAR(100) UNIQUE,
+    provider VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- At most one live (pending or completed) tip per ride
+CREATE UNIQUE INDEX idx_tips_ride ON tips(ride_id) WHERE status <> 'failed';
+
+-- Admin corrections to a completed ride's fare
+CREATE TABLE fare_adjustments (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    previous_fare NUMERIC(10,2) NOT NULL,
+    new_fare NUMERIC(10,2) NOT NULL,
+    delta NUMERIC(10,2) NOT NULL,
+    reason TEXT NOT NULL,
+    admin_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_fare_adjustments_ride ON fare_adjustments(ride_id);
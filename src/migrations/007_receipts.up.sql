@@ -0,0 +1,13 @@
+-- Itemised pricing output captured when the ride is created
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS fare_breakdown JSONB;
+
+-- Every issued receipt is kept so earlier versions can be re-issued
+CREATE TABLE receipts (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    version INTEGER NOT NULL,
+    receipt_number VARCHAR(50) NOT NULL UNIQUE,
+    data JSONB NOT NULL,
+    issued_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (ride_id, version)
+);
@@ -0,0 +1,72 @@
+-- Money is stored as BIGINT minor units with an explicit ISO 4217 currency.
+-- Everything before this migration was UGX, which has no minor unit, so
+-- existing amounts convert one-to-one.
+
+ALTER TABLE rides
+    ALTER COLUMN price_estimate TYPE BIGINT USING ROUND(price_estimate)::BIGINT,
+    ALTER COLUMN final_fare TYPE BIGINT USING ROUND(final_fare)::BIGINT,
+    ALTER COLUMN cash_collected TYPE BIGINT USING ROUND(cash_collected)::BIGINT,
+    ALTER COLUMN discount_amount TYPE BIGINT USING ROUND(discount_amount)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+-- A wallet holds a single currency, fixed by its first credit
+ALTER TABLE wallets
+    ALTER COLUMN balance TYPE BIGINT USING ROUND(balance)::BIGINT,
+    ALTER COLUMN held TYPE BIGINT USING ROUND(held)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+ALTER TABLE wallet_holds
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount)::BIGINT,
+    ALTER COLUMN captured_amount TYPE BIGINT USING ROUND(captured_amount)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+ALTER TABLE wallet_topups
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+ALTER TABLE ledger_entries
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+-- Driver balances likewise stay in the currency of the driver's first trip
+ALTER TABLE driver_balances
+    ALTER COLUMN balance TYPE BIGINT USING ROUND(balance)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+ALTER TABLE driver_settlements
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+ALTER TABLE driver_earnings
+    ALTER COLUMN gross_fare TYPE BIGINT USING ROUND(gross_fare)::BIGINT,
+    ALTER COLUMN commission TYPE BIGINT USING ROUND(commission)::BIGINT,
+    ALTER COLUMN net_amount TYPE BIGINT USING ROUND(net_amount)::BIGINT,
+    ALTER COLUMN tip TYPE BIGINT USING ROUND(tip)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+ALTER TABLE payouts
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+-- discount_value stays NUMERIC: it is a percentage or, for fixed codes, an
+-- amount in minor units of the code's currency
+ALTER TABLE promo_codes
+    ALTER COLUMN max_discount TYPE BIGINT USING ROUND(max_discount)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3);
+
+UPDATE promo_codes SET currency = 'UGX'
+WHERE currency IS NULL AND (discount_type = 'fixed' OR max_discount IS NOT NULL);
+
+ALTER TABLE promo_redemptions
+    ALTER COLUMN discount TYPE BIGINT USING ROUND(discount)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+ALTER TABLE tips
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
+
+ALTER TABLE fare_adjustments
+    ALTER COLUMN previous_fare TYPE BIGINT USING ROUND(previous_fare)::BIGINT,
+    ALTER COLUMN new_fare TYPE BIGINT USING ROUND(new_fare)::BIGINT,
+    ALTER COLUMN delta TYPE BIGINT USING ROUND(delta)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'UGX';
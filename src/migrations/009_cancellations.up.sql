@@ -0,0 +1,18 @@
+-- Timestamps the cancellation policy is evaluated against
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS accepted_at TIMESTAMP;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP;
+
+CREATE TABLE ride_cancellations (
+    ride_id UUID PRIMARY KEY REFERENCES rides(id),
+    cancelled_by VARCHAR(10) NOT NULL CHECK (cancelled_by IN ('rider', 'driver')),
+    reason TEXT NOT NULL DEFAULT '',
+    policy TEXT NOT NULL,
+    fee BIGINT NOT NULL DEFAULT 0 CHECK (fee >= 0),
+    penalty BIGINT NOT NULL DEFAULT 0 CHECK (penalty >= 0),
+    currency VARCHAR(3) NOT NULL DEFAULT 'UGX',
+    fee_status VARCHAR(20) NOT NULL CHECK (fee_status IN ('none', 'pending', 'paid', 'failed')),
+    tx_ref VARCHAR(100) UNIQUE,
+    provider VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
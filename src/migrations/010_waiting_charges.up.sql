@@ -0,0 +1,22 @@
+-- Drivers mark arrival at pickup; waiting past the free window is charged
+ALTER TABLE rides DROP CONSTRAINT IF EXISTS rides_status_check;
+ALTER TABLE rides ADD CONSTRAINT rides_status_check
+    CHECK (status IN ('requested', 'accepted', 'arrived', 'in_progress', 'completed', 'cancelled'));
+
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS arrived_at TIMESTAMP;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS started_at TIMESTAMP;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS paid_waiting_notified_at TIMESTAMP;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS waiting_charge BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_rides_arrived ON rides(arrived_at) WHERE status = 'arrived';
+
+CREATE TABLE rider_notifications (
+    id BIGSERIAL PRIMARY KEY,
+    rider_id INTEGER NOT NULL,
+    ride_id UUID REFERENCES rides(id),
+    type VARCHAR(50) NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_rider_notifications_rider ON rider_notifications(rider_id, created_at DESC);
@@ -0,0 +1,19 @@
+-- GPS breadcrumbs reported by drivers during a ride
+CREATE TABLE ride_locations (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    lat DOUBLE PRECISION NOT NULL,
+    lng DOUBLE PRECISION NOT NULL,
+    accuracy DOUBLE PRECISION,
+    recorded_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_ride_locations_ride ON ride_locations(ride_id, recorded_at);
+
+-- Final fare metered from the track, kept alongside the upfront quote
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS metered_fare BIGINT;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS metered_breakdown JSONB;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS actual_distance_km DOUBLE PRECISION;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS actual_duration_s INTEGER;
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS fare_basis VARCHAR(10) CHECK (fare_basis IN ('quote', 'metered'));
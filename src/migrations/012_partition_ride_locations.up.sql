@@ -0,0 +1,38 @@
+-- Partition ride breadcrumbs by month so old months can be dropped whole.
+-- Partitions for new months are created by the track maintenance job.
+ALTER TABLE ride_locations RENAME TO ride_locations_old;
+ALTER INDEX idx_ride_locations_ride RENAME TO idx_ride_locations_old_ride;
+
+CREATE TABLE ride_locations (
+    id BIGSERIAL,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    lat DOUBLE PRECISION NOT NULL,
+    lng DOUBLE PRECISION NOT NULL,
+    accuracy DOUBLE PRECISION,
+    recorded_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, recorded_at)
+) PARTITION BY RANGE (recorded_at);
+
+CREATE INDEX idx_ride_locations_ride ON ride_locations(ride_id, recorded_at);
+
+DO $$
+DECLARE
+    month DATE := date_trunc('month', LEAST(
+        COALESCE((SELECT MIN(recorded_at) FROM ride_locations_old), NOW()), NOW() - INTERVAL '1 month'));
+BEGIN
+    WHILE month <= date_trunc('month', NOW() + INTERVAL '1 month') LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF ride_locations FOR VALUES FROM (%L) TO (%L)',
+            'ride_locations_' || to_char(month, '"y"YYYY"m"MM'), month, month + INTERVAL '1 month');
+        month := month + INTERVAL '1 month';
+    END LOOP;
+END $$;
+
+INSERT INTO ride_locations (ride_id, lat, lng, accuracy, recorded_at, created_at)
+SELECT ride_id, lat, lng, accuracy, recorded_at, created_at FROM ride_locations_old;
+
+DROP TABLE ride_locations_old;
+
+-- Set once a finished ride's track has been thinned out
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS track_downsampled_at TIMESTAMP;
@@ -0,0 +1,12 @@
+-- Public trip-tracking links; only a hash of each token is stored
+CREATE TABLE ride_shares (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    rider_id INTEGER NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_ride_shares_ride ON ride_shares(ride_id);
@@ -0,0 +1,38 @@
+-- Safety incidents raised with SOS during a ride
+CREATE TABLE sos_incidents (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    reported_by VARCHAR(10) NOT NULL CHECK (reported_by IN ('rider', 'driver')),
+    reporter_id INTEGER NOT NULL,
+    reporter VARCHAR(255) NOT NULL,
+    lat DOUBLE PRECISION,
+    lng DOUBLE PRECISION,
+    message TEXT,
+    trip JSONB NOT NULL,
+    track JSONB NOT NULL DEFAULT '[]',
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'acknowledged', 'resolved')),
+    alert_status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (alert_status IN ('pending', 'sent', 'failed')),
+    contacts_notified INTEGER NOT NULL DEFAULT 0,
+    notes TEXT,
+    handled_by INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP
+);
+
+CREATE INDEX idx_sos_incidents_ride ON sos_incidents(ride_id);
+CREATE INDEX idx_sos_incidents_open ON sos_incidents(created_at) WHERE status <> 'resolved';
+
+-- People to text when a user raises SOS
+CREATE TABLE trusted_contacts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    phone VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, phone)
+);
+
+-- Rides with an unresolved incident are handled first in admin tools
+ALTER TABLE rides ADD COLUMN priority BOOLEAN NOT NULL DEFAULT false;
+CREATE INDEX idx_rides_priority ON rides(id) WHERE priority;
@@ -0,0 +1,3 @@
+-- Reverse-geocoded pickup and dropoff addresses, filled in after the ride is requested
+ALTER TABLE rides ADD COLUMN pickup_address TEXT;
+ALTER TABLE rides ADD COLUMN dropoff_address TEXT;
@@ -0,0 +1,18 @@
+-- Places riders have labelled (Home, Work or custom)
+CREATE TABLE saved_places (
+    id BIGSERIAL PRIMARY KEY,
+    rider_id INTEGER NOT NULL,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('home', 'work', 'custom')),
+    label VARCHAR(50) NOT NULL,
+    address TEXT,
+    location GEOMETRY(POINT, 4326) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (rider_id, label)
+);
+
+-- One Home and one Work per rider
+CREATE UNIQUE INDEX idx_saved_places_kind ON saved_places(rider_id, kind) WHERE kind <> 'custom';
+
+-- Recent destinations are read from each rider's latest completed rides
+CREATE INDEX idx_rides_rider_completed ON rides(rider_id, completed_at DESC) WHERE status = 'completed';
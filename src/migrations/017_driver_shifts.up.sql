@@ -0,0 +1,31 @@
+-- Driver state replaces the overloaded available flag
+ALTER TABLE drivers ADD COLUMN state VARCHAR(20) NOT NULL DEFAULT 'offline'
+    CHECK (state IN ('offline', 'idle', 'offered', 'en_route', 'on_trip'));
+ALTER TABLE drivers ADD COLUMN state_changed_at TIMESTAMP NOT NULL DEFAULT NOW();
+ALTER TABLE drivers ADD COLUMN last_seen_at TIMESTAMP;
+
+-- Drivers with a ride under way keep it; everyone else starts offline
+UPDATE drivers d SET state = CASE r.status
+        WHEN 'requested' THEN 'offered'
+        WHEN 'in_progress' THEN 'on_trip'
+        ELSE 'en_route'
+    END
+FROM rides r
+WHERE r.driver_id = d.driver_id AND r.status IN ('requested', 'accepted', 'arrived', 'in_progress');
+
+DROP INDEX IF EXISTS idx_drivers_available;
+ALTER TABLE drivers DROP COLUMN available;
+CREATE INDEX idx_drivers_idle ON drivers(last_seen_at) WHERE state = 'idle';
+
+-- Online sessions; duration_s is set when the shift ends
+CREATE TABLE driver_shifts (
+    id BIGSERIAL PRIMARY KEY,
+    driver_id VARCHAR(255) NOT NULL REFERENCES drivers(driver_id),
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMP,
+    end_reason VARCHAR(20) CHECK (end_reason IN ('driver', 'timeout')),
+    duration_s BIGINT
+);
+
+CREATE UNIQUE INDEX idx_driver_shifts_open ON driver_shifts(driver_id) WHERE ended_at IS NULL;
+CREATE INDEX idx_driver_shifts_driver ON driver_shifts(driver_id, started_at DESC);
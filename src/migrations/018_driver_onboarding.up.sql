@@ -0,0 +1,41 @@
+-- Drivers join through an application reviewed by an admin
+CREATE TABLE driver_applications (
+    driver_id VARCHAR(255) PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    phone VARCHAR(20) NOT NULL,
+    vehicle_model VARCHAR(50) NOT NULL,
+    vehicle_plate VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'draft'
+        CHECK (status IN ('draft', 'submitted', 'approved', 'rejected')),
+    rejection_reason TEXT,
+    submitted_at TIMESTAMP,
+    reviewed_at TIMESTAMP,
+    reviewed_by INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_driver_applications_status ON driver_applications(status, submitted_at);
+
+-- One current document per type; the file itself lives in the blob store
+CREATE TABLE driver_documents (
+    id BIGSERIAL PRIMARY KEY,
+    driver_id VARCHAR(255) NOT NULL REFERENCES driver_applications(driver_id),
+    doc_type VARCHAR(30) NOT NULL
+        CHECK (doc_type IN ('licence', 'national_id', 'vehicle_registration', 'insurance')),
+    blob_key TEXT NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    expires_on DATE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'rejected')),
+    uploaded_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (driver_id, doc_type)
+);
+
+CREATE INDEX idx_driver_documents_expiry ON driver_documents(expires_on) WHERE expires_on IS NOT NULL;
+
+-- Drivers that predate onboarding are treated as approved
+ALTER TABLE drivers ADD COLUMN approved_at TIMESTAMP;
+UPDATE drivers SET approved_at = NOW();
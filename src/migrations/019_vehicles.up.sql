@@ -0,0 +1,73 @@
+-- Fleet owners own vehicles and employ the drivers who drive them
+CREATE TABLE fleets (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    owner_user_id INTEGER NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- A vehicle is owned by exactly one of a fleet or an independent driver
+CREATE TABLE vehicles (
+    id BIGSERIAL PRIMARY KEY,
+    plate VARCHAR(20) NOT NULL UNIQUE,
+    make VARCHAR(50),
+    model VARCHAR(50) NOT NULL,
+    colour VARCHAR(30),
+    year INTEGER CHECK (year BETWEEN 1980 AND 2100),
+    class VARCHAR(20) NOT NULL DEFAULT 'economy' CHECK (class IN ('boda', 'economy', 'comfort', 'xl')),
+    capacity INTEGER NOT NULL DEFAULT 4 CHECK (capacity BETWEEN 1 AND 16),
+    fleet_id BIGINT REFERENCES fleets(id),
+    owner_driver_id VARCHAR(255) REFERENCES drivers(driver_id),
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK ((fleet_id IS NULL) <> (owner_driver_id IS NULL))
+);
+
+CREATE INDEX idx_vehicles_fleet ON vehicles(fleet_id) WHERE fleet_id IS NOT NULL;
+
+-- Drivers authorised to drive each vehicle
+CREATE TABLE vehicle_drivers (
+    vehicle_id BIGINT NOT NULL REFERENCES vehicles(id),
+    driver_id VARCHAR(255) NOT NULL REFERENCES drivers(driver_id),
+    authorised_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (vehicle_id, driver_id)
+);
+
+CREATE INDEX idx_vehicle_drivers_driver ON vehicle_drivers(driver_id);
+
+-- vehicle_id is the vehicle a driver is currently driving; rides record it
+ALTER TABLE drivers ADD COLUMN fleet_id BIGINT REFERENCES fleets(id);
+ALTER TABLE drivers ADD COLUMN vehicle_id BIGINT REFERENCES vehicles(id);
+ALTER TABLE rides ADD COLUMN vehicle_id BIGINT REFERENCES vehicles(id);
+
+CREATE INDEX idx_drivers_fleet ON drivers(fleet_id) WHERE fleet_id IS NOT NULL;
+CREATE INDEX idx_rides_vehicle ON rides(vehicle_id, created_at DESC) WHERE vehicle_id IS NOT NULL;
+
+-- Each existing driver owns the vehicle in their vehicle_model. Plates come
+-- from their application where there is one, normalised like
+-- normalizePlate (upper case, single spaces). Drivers without a plate, and
+-- all but the first driver claiming a duplicate plate, get an UNKNOWN-
+-- placeholder that ops need to correct.
+INSERT INTO vehicles (plate, model, owner_driver_id)
+SELECT CASE WHEN plate IS NOT NULL AND claim = 1 THEN plate
+            ELSE 'UNKNOWN-' || LEFT(md5(driver_id), 12) END,
+       model, driver_id
+FROM (
+    SELECT d.driver_id,
+           COALESCE(NULLIF(d.vehicle_model, ''), 'Unknown') AS model,
+           p.plate,
+           ROW_NUMBER() OVER (PARTITION BY p.plate ORDER BY d.driver_id) AS claim
+    FROM drivers d
+    LEFT JOIN driver_applications a ON a.driver_id = d.driver_id
+    CROSS JOIN LATERAL (
+        SELECT NULLIF(regexp_replace(upper(btrim(a.vehicle_plate)), '\s+', ' ', 'g'), '') AS plate
+    ) p
+) owned;
+
+INSERT INTO vehicle_drivers (vehicle_id, driver_id)
+SELECT id, owner_driver_id FROM vehicles;
+
+UPDATE drivers d SET vehicle_id = v.id FROM vehicles v WHERE v.owner_driver_id = d.driver_id;
+UPDATE rides r SET vehicle_id = d.vehicle_id FROM drivers d WHERE d.driver_id = r.driver_id;
+
+ALTER TABLE drivers DROP COLUMN vehicle_model;
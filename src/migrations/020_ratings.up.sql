@@ -0,0 +1,45 @@
+-- Riders and drivers rate each other once per completed ride
+CREATE TABLE ratings (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    rated_by VARCHAR(10) NOT NULL CHECK (rated_by IN ('rider', 'driver')),
+    driver_id VARCHAR(255) NOT NULL,
+    rider_id INTEGER NOT NULL,
+    stars SMALLINT NOT NULL CHECK (stars BETWEEN 1 AND 5),
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    comment TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (ride_id, rated_by)
+);
+
+CREATE INDEX idx_ratings_driver ON ratings(driver_id, created_at DESC) WHERE rated_by = 'rider';
+CREATE INDEX idx_ratings_rider ON ratings(rider_id, created_at DESC) WHERE rated_by = 'driver';
+
+-- rating is the rolling average over the last RATING_WINDOW ratings
+ALTER TABLE drivers ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE rider_profiles (
+    rider_id INTEGER PRIMARY KEY,
+    rating FLOAT NOT NULL,
+    rating_count INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Low ratings and low averages queued for ops
+CREATE TABLE rating_reviews (
+    id BIGSERIAL PRIMARY KEY,
+    subject_type VARCHAR(10) NOT NULL CHECK (subject_type IN ('driver', 'rider')),
+    subject_id VARCHAR(255) NOT NULL,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('low_rating', 'low_average')),
+    rating_id BIGINT NOT NULL REFERENCES ratings(id),
+    average FLOAT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'resolved', 'dismissed')),
+    note TEXT,
+    closed_by INTEGER,
+    closed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_rating_reviews_status ON rating_reviews(status, created_at);
+CREATE UNIQUE INDEX idx_rating_reviews_open_average ON rating_reviews(subject_type, subject_id)
+    WHERE reason = 'low_average' AND status = 'open';
@@ -0,0 +1,8 @@
+-- Ride history pages are read newest first per rider and per driver, with
+-- the ride ID breaking ties for cursor pagination
+CREATE INDEX idx_rides_rider_history ON rides(rider_id, created_at DESC, id DESC);
+CREATE INDEX idx_rides_driver_history ON rides(driver_id, created_at DESC, id DESC);
+
+-- Superseded by the history indexes, which lead with the same column
+DROP INDEX IF EXISTS idx_rides_rider_id;
+DROP INDEX IF EXISTS idx_rides_driver_id;
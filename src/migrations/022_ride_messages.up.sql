@@ -0,0 +1,23 @@
+-- In-ride chat between a ride's rider and driver. client_id lets clients
+-- retry a send without duplicating the message.
+CREATE TABLE ride_messages (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    sender_role VARCHAR(10) NOT NULL CHECK (sender_role IN ('rider', 'driver')),
+    sender_id VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    template VARCHAR(50),
+    client_id VARCHAR(64),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP,
+    read_at TIMESTAMP,
+    UNIQUE (ride_id, sender_role, client_id)
+);
+
+CREATE INDEX idx_ride_messages_ride ON ride_messages(ride_id, id);
+
+-- Chat closes CHAT_CLOSE_AFTER after the ride ends
+ALTER TABLE rides ADD COLUMN chat_closed_at TIMESTAMP;
+UPDATE rides SET chat_closed_at = NOW() WHERE status IN ('completed', 'cancelled');
+
+CREATE INDEX idx_rides_chat_open ON rides(status) WHERE chat_closed_at IS NULL;
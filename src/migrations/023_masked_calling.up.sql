@@ -0,0 +1,26 @@
+-- Riders give the number they are reached on through masked calls; riders
+-- who have not been rated yet have a profile without a rating
+ALTER TABLE rider_profiles ADD COLUMN phone VARCHAR(20);
+ALTER TABLE rider_profiles ALTER COLUMN rating DROP NOT NULL;
+
+-- Pool of provider numbers that calls between riders and drivers go through
+CREATE TABLE proxy_numbers (
+    number VARCHAR(20) PRIMARY KEY,
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- A ride's rider and driver reach each other by dialling proxy_number.
+-- One proxy serves many sessions, but never two live ones sharing a phone.
+CREATE TABLE masked_sessions (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    proxy_number VARCHAR(20) NOT NULL REFERENCES proxy_numbers(number),
+    rider_phone VARCHAR(20) NOT NULL,
+    driver_phone VARCHAR(20) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_masked_sessions_proxy ON masked_sessions(proxy_number, expires_at);
+CREATE INDEX idx_masked_sessions_ride ON masked_sessions(ride_id, expires_at);
@@ -0,0 +1,9 @@
+-- Offers left unanswered for OFFER_TIMEOUT go to the next driver, so the
+-- shift monitor now scans offered drivers as well as idle ones
+DROP INDEX IF EXISTS idx_drivers_idle;
+CREATE INDEX idx_drivers_waiting ON drivers(last_seen_at) WHERE state IN ('idle', 'offered');
+
+-- Rides nobody accepted are cancelled by the system
+ALTER TABLE ride_cancellations DROP CONSTRAINT ride_cancellations_cancelled_by_check;
+ALTER TABLE ride_cancellations ADD CONSTRAINT ride_cancellations_cancelled_by_check
+    CHECK (cancelled_by IN ('rider', 'driver', 'system'));
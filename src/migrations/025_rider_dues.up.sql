@@ -0,0 +1,27 @@
+-- What riders still owe on a ride: the part of a fare that the wallet or
+-- cash did not cover, or the whole fare on rides paid by payment link. Each
+-- due is paid through its own payment link; driver_share is credited to
+-- the driver once it is paid. Riders with pending dues cannot request rides.
+CREATE TABLE rider_dues (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    rider_id INTEGER NOT NULL,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('fare')),
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    driver_share BIGINT NOT NULL DEFAULT 0 CHECK (driver_share >= 0),
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid')),
+    tx_ref VARCHAR(100) UNIQUE,
+    provider VARCHAR(20),
+    payment_link TEXT,
+    link_created_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    paid_at TIMESTAMP
+);
+
+CREATE INDEX idx_rider_dues_pending ON rider_dues(rider_id) WHERE status = 'pending';
+CREATE INDEX idx_rider_dues_ride ON rider_dues(ride_id);
+
+-- Sum of the ride's pending dues
+ALTER TABLE rides ADD COLUMN amount_due BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,14 @@
+-- A driver has at most one settlement in progress, handed out again with
+-- its payment link until it lapses. Older duplicates are closed first.
+ALTER TABLE driver_settlements ADD COLUMN IF NOT EXISTS payment_link TEXT;
+
+UPDATE driver_settlements s
+SET status = 'failed', updated_at = NOW()
+WHERE s.status = 'pending'
+  AND EXISTS (
+      SELECT 1 FROM driver_settlements n
+      WHERE n.driver_id = s.driver_id AND n.status = 'pending'
+        AND (n.created_at, n.tx_ref) > (s.created_at, s.tx_ref)
+  );
+
+CREATE UNIQUE INDEX idx_driver_settlements_pending ON driver_settlements(driver_id) WHERE status = 'pending';
@@ -0,0 +1,16 @@
+-- Fare adjustments record what the rider was left owing and what was
+-- refunded to their wallet
+ALTER TABLE fare_adjustments
+    ADD COLUMN IF NOT EXISTS amount_due BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS refunded BIGINT NOT NULL DEFAULT 0;
+
+-- Increases the rider's wallet could not cover are owed as dues. Reductions
+-- cancel pending dues, and a payment that still arrives on a cancelled due
+-- is refunded to the rider's wallet.
+ALTER TABLE rider_dues DROP CONSTRAINT rider_dues_kind_check;
+ALTER TABLE rider_dues ADD CONSTRAINT rider_dues_kind_check
+    CHECK (kind IN ('fare', 'fare_adjustment'));
+
+ALTER TABLE rider_dues DROP CONSTRAINT rider_dues_status_check;
+ALTER TABLE rider_dues ADD CONSTRAINT rider_dues_status_check
+    CHECK (status IN ('pending', 'paid', 'cancelled', 'refunded'));
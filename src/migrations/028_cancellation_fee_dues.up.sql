@@ -0,0 +1,37 @@
+-- Cancellation fees the rider's wallet did not cover are owed as dues. The
+-- driver's share and the platform's commission on them are booked when the
+-- rider pays.
+ALTER TABLE rider_dues ADD COLUMN IF NOT EXISTS commission BIGINT NOT NULL DEFAULT 0 CHECK (commission >= 0);
+
+ALTER TABLE rider_dues DROP CONSTRAINT rider_dues_kind_check;
+ALTER TABLE rider_dues ADD CONSTRAINT rider_dues_kind_check
+    CHECK (kind IN ('fare', 'fare_adjustment', 'cancellation_fee'));
+
+ALTER TABLE ride_cancellations ADD COLUMN IF NOT EXISTS due_id BIGINT REFERENCES rider_dues(id);
+
+-- Fees still pending, or whose payment link failed, become dues. Their
+-- commission is taken at the default PLATFORM_COMMISSION_RATE of 20%, and
+-- a pending fee keeps its tx_ref so a payment already made is found.
+WITH owed AS (
+    INSERT INTO rider_dues (ride_id, rider_id, kind, amount, driver_share, commission, currency,
+                            tx_ref, provider, link_created_at, created_at)
+    SELECT c.ride_id, r.rider_id, 'cancellation_fee', c.fee,
+           c.fee - ROUND(c.fee * 0.20)::BIGINT, ROUND(c.fee * 0.20)::BIGINT, c.currency,
+           CASE WHEN c.fee_status = 'pending' THEN c.tx_ref END,
+           CASE WHEN c.fee_status = 'pending' THEN NULLIF(c.provider, '') END,
+           CASE WHEN c.fee_status = 'pending' THEN c.created_at END,
+           c.created_at
+    FROM ride_cancellations c
+    JOIN rides r ON r.id = c.ride_id
+    WHERE c.fee_status IN ('pending', 'failed') AND c.fee > 0
+    RETURNING id, ride_id, amount
+), linked AS (
+    UPDATE ride_cancellations c
+    SET due_id = o.id, fee_status = 'pending', updated_at = NOW()
+    FROM owed o
+    WHERE c.ride_id = o.ride_id
+)
+UPDATE rides r
+SET amount_due = r.amount_due + o.amount
+FROM owed o
+WHERE r.id = o.ride_id;
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in a currency's minor units (cents for KES, whole
+// shillings for UGX, which has none). The currency travels alongside it as an
+// ISO 4217 code on the owning record.
+type Money int64
+
+// Currency describes how amounts in one currency are stored and rounded.
+type Currency struct {
+	Code     string
+	Exponent int   // digits after the decimal point in the major unit
+	Step     Money // fares are rounded to a multiple of this many minor units
+}
+
+const defaultCurrency = "UGX"
+
+var currencies = map[string]Currency{
+	"UGX": {Code: "UGX", Exponent: 0, Step: 100},
+	"KES": {Code: "KES", Exponent: 2, Step: 100},
+	"TZS": {Code: "TZS", Exponent: 2, Step: 5000},
+	"RWF": {Code: "RWF", Exponent: 0, Step: 10},
+	"USD": {Code: "USD", Exponent: 2, Step: 1},
+}
+
+var (
+	errUnknownCurrency  = errors.New("unsupported currency")
+	errCurrencyMismatch = errors.New("currency does not match the account currency")
+)
+
+// lookupCurrency resolves a currency code; an empty code means the default.
+func lookupCurrency(code string) (Currency, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		code = defaultCurrency
+	}
+	c, ok := currencies[code]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %s", errUnknownCurrency, code)
+	}
+	return c, nil
+}
+
+// currencyFor is lookupCurrency for codes already stored on a record.
+func currencyFor(code string) Currency {
+	c, err := lookupCurrency(code)
+	if err != nil {
+		return currencies[defaultCurrency]
+	}
+	return c
+}
+
+// scale multiplies m by a factor such as a commission or surge rate, rounding
+// half away from zero to the nearest minor unit.
+func (m Money) scale(factor float64) Money {
+	return Money(math.Round(float64(m) * factor))
+}
+
+// RoundFare rounds a priced amount to the currency's rounding step.
+func (c Currency) RoundFare(m Money) Money {
+	if c.Step <= 1 {
+		return m
+	}
+	return Money(math.Round(float64(m)/float64(c.Step))) * c.Step
+}
+
+// FromMajor converts an amount in major units (e.g. 150.50 KES) to Money.
+func (c Currency) FromMajor(v float64) Money {
+	return Money(math.Round(v * math.Pow10(c.Exponent)))
+}
+
+// ToMajor converts m to major units, the form payment gateways expect.
+func (c Currency) ToMajor(m Money) float64 {
+	return float64(m) / math.Pow10(c.Exponent)
+}
+
+// Format renders m for people, e.g. "UGX 15,000" or "KES 150.50".
+func (c Currency) Format(m Money) string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+
+	unit := Money(math.Pow10(c.Exponent))
+	major := strconv.FormatInt(int64(m/unit), 10)
+	var b strings.Builder
+	for i, d := range major {
+		if i > 0 && (len(major)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	if c.Exponent > 0 {
+		fmt.Fprintf(&b, ".%0*d", c.Exponent, int64(m%unit))
+	}
+	return c.Code + " " + sign + b.String()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCurrencyFormat(t *testing.T) {
+	cases := []struct {
+		code   string
+		amount Money
+		want   string
+	}{
+		{"UGX", 15000, "UGX 15,000"},
+		{"UGX", 950, "UGX 950"},
+		{"UGX", -1234567, "UGX -1,234,567"},
+		{"KES", 15050, "KES 150.50"},
+		{"KES", 5, "KES 0.05"},
+	}
+	for _, tc := range cases {
+		if got := currencies[tc.code].Format(tc.amount); got != tc.want {
+			t.Errorf("Format(%s %d): expected %q, got %q", tc.code, tc.amount, tc.want, got)
+		}
+	}
+}
+
+func TestCurrencyConversion(t *testing.T) {
+	kes := currencies["KES"]
+	if got := kes.FromMajor(150.505); got != 15051 {
+		t.Errorf("Expected 15051, got %d", got)
+	}
+	if got := kes.ToMajor(15050); got != 150.5 {
+		t.Errorf("Expected 150.5, got %v", got)
+	}
+	if got := currencies["UGX"].FromMajor(15000); got != 15000 {
+		t.Errorf("UGX has no minor unit, got %d", got)
+	}
+}
+
+func TestCurrencyRoundFare(t *testing.T) {
+	ugx := currencies["UGX"]
+	if got := ugx.RoundFare(4449); got != 4400 {
+		t.Errorf("Expected 4400, got %d", got)
+	}
+	if got := ugx.RoundFare(4450); got != 4500 {
+		t.Errorf("Expected 4500, got %d", got)
+	}
+	if got := currencies["USD"].RoundFare(1234); got != 1234 {
+		t.Errorf("USD fares should not be rounded, got %d", got)
+	}
+}
+
+func TestLookupCurrency(t *testing.T) {
+	if c, err := lookupCurrency(""); err != nil || c.Code != defaultCurrency {
+		t.Errorf("Empty code should resolve to the default currency, got %v %v", c.Code, err)
+	}
+	if c, err := lookupCurrency("kes"); err != nil || c.Code != "KES" {
+		t.Errorf("Expected KES, got %v %v", c.Code, err)
+	}
+	if _, err := lookupCurrency("XYZ"); err == nil {
+		t.Error("Expected an error for an unsupported currency")
+	}
+}
+
+func TestBuildFareQuoteUsesCityRates(t *testing.T) {
+	offPeak := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+
+	q := buildFareQuote(&cities[0], 3.27, offPeak)
+	if q.Currency != "UGX" || q.BaseFare != 3000 || q.DistanceFare != 3300 || q.TimeFare != 1000 || q.Total != 7300 {
+		t.Errorf("Unexpected Kampala quote: %+v", q)
+	}
+
+	nairobi := cityForLocation(-1.29, 36.82)
+	if nairobi == nil {
+		t.Fatal("Expected Nairobi to be an operating city")
+	}
+	q = buildFareQuote(nairobi, 2, offPeak)
+	if q.Currency != "KES" || q.TimeFare != 3000 || q.Total != 23000 {
+		t.Errorf("Unexpected Nairobi quote: %+v", q)
+	}
+}
@@ -5,7 +5,9 @@ import (
     "fmt"
     "log"
     "net/http"
+    "strings"
     "sync"
+    "time"
     "github.com/gorilla/websocket"
 )
 
@@ -17,10 +19,31 @@ var (
     
     driverConnections = struct {
         sync.RWMutex
-        m map[string]*websocket.Conn
-    }{m: make(map[string]*websocket.Conn)}
+        m map[string]*wsConn
+    }{m: make(map[string]*wsConn)}
 )
 
+// wsConn serialises writes to a WebSocket, which allows one writer at a
+// time, between the goroutines that push events and the one answering
+// the client.
+type wsConn struct {
+    mu   sync.Mutex
+    conn *websocket.Conn
+}
+
+func (c *wsConn) WriteJSON(v interface{}) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.conn.SetWriteDeadline(time.Now().Add(rideStreamWriteWait))
+    return c.conn.WriteJSON(v)
+}
+
+func (c *wsConn) WriteControl(messageType int, data []byte) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.conn.WriteControl(messageType, data, time.Now().Add(rideStreamWriteWait))
+}
+
 func NotifyDriver(driverID string, message interface{}) error {
     driverConnections.RLock()
     conn, ok := driverConnections.m[driverID]
@@ -33,6 +56,8 @@ func NotifyDriver(driverID string, message interface{}) error {
     return conn.WriteJSON(message)
 }
 
+// WSHandler pushes ride offers and chat to a driver. Sending chat over the
+// socket needs the driver's token as ?token= (or a Bearer header).
 func WSHandler(w http.ResponseWriter, r *http.Request) {
     driverID := r.URL.Query().Get("driver_id")
     if driverID == "" {
@@ -40,12 +65,29 @@ func WSHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    conn, err := wsUpgrader.Upgrade(w, r, nil)
+    // Only the driver themselves may take over their connection: it
+    // carries ride offers, rider ratings and chat.
+    token := r.URL.Query().Get("token")
+    if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+        token = strings.TrimPrefix(auth, "Bearer ")
+    }
+    if token == "" {
+        http.Error(w, "token required", http.StatusUnauthorized)
+        return
+    }
+    claims, err := validateToken(token)
+    if err != nil || claims.Role != "driver" || claims.Username != driverID {
+        http.Error(w, "invalid token", http.StatusUnauthorized)
+        return
+    }
+
+    ws, err := wsUpgrader.Upgrade(w, r, nil)
     if err != nil {
         log.Printf("WebSocket upgrade failed: %v", err)
         return
     }
-    defer conn.Close()
+    defer ws.Close()
+    conn := &wsConn{conn: ws}
 
     // Register connection
     driverConnections.Lock()
@@ -54,23 +96,30 @@ func WSHandler(w http.ResponseWriter, r *http.Request) {
     
     defer func() {
         driverConnections.Lock()
-        delete(driverConnections.m, driverID)
+        if driverConnections.m[driverID] == conn {
+            delete(driverConnections.m, driverID)
+        }
         driverConnections.Unlock()
     }()
 
     // Check for pending notifications
     rows, err := dbPool.Query(r.Context(),
-        `SELECT ride_id FROM driver_notifications 
-         WHERE driver_id = $1 AND status = 'pending'`,
+        `SELECT n.ride_id, p.rating
+         FROM driver_notifications n
+         JOIN rides r ON r.id = n.ride_id
+         LEFT JOIN rider_profiles p ON p.rider_id = r.rider_id
+         WHERE n.driver_id = $1 AND n.status = 'pending'`,
         driverID)
     if err == nil {
         defer rows.Close()
         for rows.Next() {
             var rideID string
-            if err := rows.Scan(&rideID); err == nil {
+            var rating *float64
+            if err := rows.Scan(&rideID, &rating); err == nil {
                 conn.WriteJSON(map[string]interface{}{
                     "type": "pending_ride",
                     "ride_id": rideID,
+                    "rider_rating": rating,
                 })
             }
         }
@@ -78,12 +127,14 @@ func WSHandler(w http.ResponseWriter, r *http.Request) {
 
     // Heartbeat and message handling
     for {
-        _, _, err := conn.ReadMessage()
+        _, msg, err := ws.ReadMessage()
         if err != nil {
             log.Printf("Driver %s disconnected: %v", driverID, err)
             break
         }
-        // Keep connection alive
+        if reply := chatSocketReply(claims, msg, ""); reply != nil {
+            conn.WriteJSON(reply)
+        }
     }
 }
 
@@ -95,3 +146,53 @@ func UpdateNotificationStatus(driverID, rideID, status string) error {
         status, driverID, rideID)
     return err
 }
+
+// RiderNotification is a ride update kept for the rider to fetch.
+type RiderNotification struct {
+    ID        int64     `json:"id"`
+    RideID    string    `json:"ride_id"`
+    Type      string    `json:"type"`
+    Message   string    `json:"message"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+func notifyRider(ctx context.Context, riderID int, rideID, kind, message string) error {
+    _, err := dbPool.Exec(ctx,
+        `INSERT INTO rider_notifications (rider_id, ride_id, type, message)
+         VALUES ($1, $2, $3, $4)`,
+        riderID, rideID, kind, message)
+    return err
+}
+
+func riderNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+    claims, ok := r.Context().Value("userClaims").(*Claims)
+    if !ok {
+        respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+        return
+    }
+
+    rows, err := dbPool.Query(r.Context(),
+        `SELECT id, ride_id, type, message, created_at
+         FROM rider_notifications
+         WHERE rider_id = $1
+         ORDER BY created_at DESC
+         LIMIT 50`,
+        claims.UserID)
+    if err != nil {
+        respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+        return
+    }
+    defer rows.Close()
+
+    notifications := []RiderNotification{}
+    for rows.Next() {
+        var n RiderNotification
+        if err := rows.Scan(&n.ID, &n.RideID, &n.Type, &n.Message, &n.CreatedAt); err != nil {
+            respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+            return
+        }
+        notifications = append(notifications, n)
+    }
+
+    respondJSON(w, http.StatusOK, successResponse(notifications))
+}
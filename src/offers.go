@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultOfferTimeout is how long an offered driver has to accept a ride
+// before it is offered to the next nearest driver.
+const defaultOfferTimeout = 30 * time.Second
+
+const cancelledBySystem = "system"
+
+// offerIgnored reports whether a driver left an offer unanswered for
+// longer than timeout.
+func offerIgnored(state string, offeredAt, now time.Time, timeout time.Duration) bool {
+	return state == driverStateOffered && now.Sub(offeredAt) > timeout
+}
+
+// reoffer is the outcome of taking a ride offer back from a driver: the
+// ride went to NewDriverID, or was cancelled when nobody else was near.
+type reoffer struct {
+	Ride        *RideStatus
+	NewDriverID string
+}
+
+// lockOfferedRide locks the ride currently offered to driverID, or returns
+// nil if there is none. The ride is locked before the driver, in the same
+// order as accepting it.
+func lockOfferedRide(ctx context.Context, tx pgx.Tx, driverID string) (*RideStatus, error) {
+	var rideID string
+	err := tx.QueryRow(ctx,
+		`SELECT id FROM rides WHERE driver_id = $1 AND status = 'requested'
+		 ORDER BY created_at DESC LIMIT 1`,
+		driverID).Scan(&rideID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load offered ride: %w", err)
+	}
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	if ride.DriverID != driverID || ride.Status != rideStatusRequested {
+		return nil, nil
+	}
+	return ride, nil
+}
+
+// withdrawOffer takes the locked ride back from its offered driver and
+// offers it to the nearest driver who has not yet had it, in the same
+// vehicle class. The ride is cancelled when there is no one left. The
+// caller decides what state the first driver moves to and calls announce
+// once tx commits.
+func withdrawOffer(ctx context.Context, tx pgx.Tx, ride *RideStatus) (*reoffer, error) {
+	driverID := ride.DriverID
+	if _, err := tx.Exec(ctx,
+		`UPDATE driver_notifications SET status = 'expired', updated_at = NOW()
+		 WHERE driver_id = $1 AND ride_id = $2 AND status = 'pending'`,
+		driverID, ride.ID); err != nil {
+		return nil, fmt.Errorf("failed to expire offer: %w", err)
+	}
+
+	var lat, lng float64
+	var class string
+	if err := tx.QueryRow(ctx,
+		`SELECT ST_Y(r.start_location::geometry), ST_X(r.start_location::geometry), COALESCE(v.class, '')
+		 FROM rides r
+		 LEFT JOIN vehicles v ON v.id = r.vehicle_id
+		 WHERE r.id = $1`,
+		ride.ID).Scan(&lat, &lng, &class); err != nil {
+		return nil, fmt.Errorf("failed to load pickup: %w", err)
+	}
+
+	next, err := nearestIdleDriver(ctx, tx, lat, lng, class, ride.ID)
+	if err != nil {
+		if err := cancelUnmatchedRide(ctx, tx, ride); err != nil {
+			return nil, err
+		}
+		return &reoffer{Ride: ride}, nil
+	}
+
+	ride.DriverID = next.ID
+	ride.ETA = calculateETA(next.Distance / 1000)
+	if err := tx.QueryRow(ctx,
+		`UPDATE rides SET driver_id = $1, vehicle_id = $2, estimated_eta = $3, updated_at = NOW()
+		 WHERE id = $4
+		 RETURNING updated_at`,
+		next.ID, next.VehicleID, ride.ETA, ride.ID).Scan(&ride.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to reassign ride: %w", err)
+	}
+	if err := setDriverState(ctx, tx, next.ID, driverStateOffered); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO driver_notifications (driver_id, ride_id, status) VALUES ($1, $2, 'pending')`,
+		next.ID, ride.ID); err != nil {
+		return nil, fmt.Errorf("failed to store notification: %w", err)
+	}
+	return &reoffer{Ride: ride, NewDriverID: next.ID}, nil
+}
+
+// cancelUnmatchedRide cancels a ride no driver accepted, without a fee,
+// releasing its promo use and wallet hold.
+func cancelUnmatchedRide(ctx context.Context, tx pgx.Tx, ride *RideStatus) error {
+	if err := tx.QueryRow(ctx,
+		`UPDATE rides SET status = 'cancelled', cancelled_at = NOW(), updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING cancelled_at, updated_at`,
+		ride.ID).Scan(&ride.CancelledAt, &ride.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to cancel ride: %w", err)
+	}
+	ride.Status = rideStatusCancelled
+	if err := releasePromoRedemption(ctx, tx, ride.ID); err != nil {
+		return err
+	}
+	if ride.PaymentMethod == paymentMethodWallet {
+		if err := releaseWalletHold(ctx, tx, ride.ID); err != nil && !errors.Is(err, errNoWalletHold) {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO ride_cancellations (ride_id, cancelled_by, policy, currency, fee_status, provider)
+		 VALUES ($1, $2, 'no driver accepted', $3, 'none', '')`,
+		ride.ID, cancelledBySystem, ride.Currency); err != nil {
+		return fmt.Errorf("failed to record cancellation: %w", err)
+	}
+	return nil
+}
+
+// announce tells the new driver about their offer, or the rider that their
+// ride was cancelled.
+func (o *reoffer) announce(ctx context.Context) {
+	if o == nil {
+		return
+	}
+	publishRideStatus(o.Ride)
+	if o.NewDriverID == "" {
+		if err := notifyRider(ctx, o.Ride.RiderID, o.Ride.ID, "ride_cancelled",
+			"No driver accepted your ride. Please request again."); err != nil {
+			log.Printf("Failed to notify rider %d of cancellation on ride %s: %v", o.Ride.RiderID, o.Ride.ID, err)
+		}
+		return
+	}
+	NotifyDriver(o.NewDriverID, map[string]interface{}{
+		"type":         "pending_ride",
+		"ride_id":      o.Ride.ID,
+		"rider_rating": riderRating(ctx, dbPool, o.Ride.RiderID),
+	})
+}
+
+// declineRideHandler lets the offered driver turn a ride down. They go back
+// to idle and the ride is offered to the next nearest driver.
+func declineRideHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	o, err := declineRide(r.Context(), mux.Vars(r)["id"], claims.Username)
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+	o.announce(r.Context())
+
+	respondJSON(w, http.StatusOK, successResponse(map[string]string{"ride_id": o.Ride.ID, "status": "declined"}))
+}
+
+func declineRide(ctx context.Context, rideID, driverID string) (*reoffer, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	if ride.DriverID != driverID {
+		return nil, errRideNotFound
+	}
+	if ride.Status != rideStatusRequested {
+		return nil, errInvalidRideTransition
+	}
+
+	o, err := withdrawOffer(ctx, tx, ride)
+	if err != nil {
+		return nil, err
+	}
+	if err := setDriverState(ctx, tx, driverID, driverStateIdle); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return o, nil
+}
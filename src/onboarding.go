@@ -0,0 +1,686 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// DriverApplication is a driver's onboarding application. The applicant's
+// username becomes their driver ID once an admin approves it.
+type DriverApplication struct {
+	DriverID        string           `json:"driver_id"`
+	UserID          int              `json:"user_id"`
+	Name            string           `json:"name"`
+	Phone           string           `json:"phone"`
+	VehicleModel    string           `json:"vehicle_model"`
+	VehiclePlate    string           `json:"vehicle_plate"`
+	Status          string           `json:"status"` // draft, submitted, approved or rejected
+	RejectionReason string           `json:"rejection_reason,omitempty"`
+	Documents       []DriverDocument `json:"documents"`
+	Problems        []string         `json:"problems,omitempty"`
+	SubmittedAt     *time.Time       `json:"submitted_at,omitempty"`
+	ReviewedAt      *time.Time       `json:"reviewed_at,omitempty"`
+	CreatedAt       time.Time        `json:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+}
+
+// DriverDocument is an uploaded document. ExpiresOn is a date.
+type DriverDocument struct {
+	Type        string     `json:"type"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	ExpiresOn   *time.Time `json:"expires_on,omitempty"`
+	Status      string     `json:"status"` // pending, approved or rejected
+	UploadedAt  time.Time  `json:"uploaded_at"`
+
+	blobKey string
+}
+
+// driverDocumentTypes lists the documents every driver needs, and whether
+// each must carry an expiry date.
+var driverDocumentTypes = []struct {
+	Type           string
+	ExpiryRequired bool
+}{
+	{"licence", true},
+	{"national_id", false},
+	{"vehicle_registration", false},
+	{"insurance", true},
+}
+
+const (
+	maxDocumentSize = 10 << 20
+	dateLayout      = "2006-01-02"
+)
+
+// eligibleDriverSQL restricts a query on drivers d to approved drivers whose
+// documents have neither expired nor been rejected.
+const eligibleDriverSQL = `d.approved_at IS NOT NULL AND NOT EXISTS (
+	SELECT 1 FROM driver_documents dd
+	WHERE dd.driver_id = d.driver_id AND (dd.status = 'rejected' OR dd.expires_on < CURRENT_DATE))`
+
+var (
+	errApplicationNotFound = errors.New("application not found")
+	errApplicationState    = errors.New("application status does not allow this action")
+	errApplicationProblems = errors.New("application is incomplete")
+	driverIDPattern        = regexp.MustCompile(`^[A-Za-z0-9_-]{3,64}$`)
+	allowedDocumentTypes   = map[string]string{
+		"application/pdf": ".pdf",
+		"image/jpeg":      ".jpg",
+		"image/png":       ".png",
+	}
+)
+
+// querier is a queryRower that can also run multi-row queries.
+type querier interface {
+	queryRower
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func validDocumentType(t string) bool {
+	for _, d := range driverDocumentTypes {
+		if d.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// documentProblems lists what stops the documents from being approved:
+// missing or rejected documents, and missing or past expiry dates.
+func documentProblems(docs []DriverDocument, today time.Time) []string {
+	byType := make(map[string]DriverDocument, len(docs))
+	for _, d := range docs {
+		byType[d.Type] = d
+	}
+	today = today.Truncate(24 * time.Hour)
+
+	var problems []string
+	for _, required := range driverDocumentTypes {
+		d, ok := byType[required.Type]
+		switch {
+		case !ok:
+			problems = append(problems, required.Type+" is missing")
+		case d.Status == "rejected":
+			problems = append(problems, required.Type+" was rejected")
+		case d.ExpiresOn == nil && required.ExpiryRequired:
+			problems = append(problems, required.Type+" needs an expiry date")
+		case d.ExpiresOn != nil && d.ExpiresOn.Before(today):
+			problems = append(problems, required.Type+" has expired")
+		}
+	}
+	return problems
+}
+
+func (a *DriverApplication) profileProblems() []string {
+	var problems []string
+	if a.Name == "" {
+		problems = append(problems, "name is required")
+	}
+	if !phonePattern.MatchString(a.Phone) {
+		problems = append(problems, "a valid phone number is required")
+	}
+	if a.VehicleModel == "" || a.VehiclePlate == "" {
+		problems = append(problems, "vehicle_model and vehicle_plate are required")
+	}
+	return problems
+}
+
+func loadApplication(ctx context.Context, q querier, driverID string, forUpdate bool) (*DriverApplication, error) {
+	query := `SELECT driver_id, user_id, name, phone, vehicle_model, vehicle_plate, status,
+	                 COALESCE(rejection_reason, ''), submitted_at, reviewed_at, created_at, updated_at
+	          FROM driver_applications WHERE driver_id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	var a DriverApplication
+	err := q.QueryRow(ctx, query, driverID).Scan(&a.DriverID, &a.UserID, &a.Name, &a.Phone, &a.VehicleModel,
+		&a.VehiclePlate, &a.Status, &a.RejectionReason, &a.SubmittedAt, &a.ReviewedAt, &a.CreatedAt, &a.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errApplicationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load application: %w", err)
+	}
+
+	a.Documents, err = loadDocuments(ctx, q, driverID)
+	if err != nil {
+		return nil, err
+	}
+	a.Problems = append(a.profileProblems(), documentProblems(a.Documents, time.Now())...)
+	return &a, nil
+}
+
+func loadDocuments(ctx context.Context, q querier, driverID string) ([]DriverDocument, error) {
+	rows, err := q.Query(ctx,
+		`SELECT doc_type, content_type, size_bytes, expires_on, status, uploaded_at, blob_key
+		 FROM driver_documents WHERE driver_id = $1 ORDER BY doc_type`,
+		driverID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load documents: %w", err)
+	}
+	docs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (DriverDocument, error) {
+		var d DriverDocument
+		err := row.Scan(&d.Type, &d.ContentType, &d.Size, &d.ExpiresOn, &d.Status, &d.UploadedAt, &d.blobKey)
+		return d, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load documents: %w", err)
+	}
+	if docs == nil {
+		docs = []DriverDocument{}
+	}
+	return docs, nil
+}
+
+func respondApplicationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errApplicationNotFound):
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+	case errors.Is(err, errApplicationState):
+		respondJSON(w, http.StatusConflict, errorResponse(err.Error()))
+	case errors.Is(err, errApplicationProblems):
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+	default:
+		log.Printf("Driver application error: %v", err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+	}
+}
+
+func getApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	a, err := loadApplication(r.Context(), dbPool, claims.Username, false)
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(a))
+}
+
+// saveApplicationHandler creates or edits the applicant's profile. Editing a
+// rejected application puts it back into draft for resubmission.
+func saveApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+	if !driverIDPattern.MatchString(claims.Username) {
+		respondJSON(w, http.StatusBadRequest, errorResponse("username may only contain letters, digits, - and _"))
+		return
+	}
+
+	var req struct {
+		Name         string `json:"name"`
+		Phone        string `json:"phone"`
+		VehicleModel string `json:"vehicle_model"`
+		VehiclePlate string `json:"vehicle_plate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+
+	tag, err := dbPool.Exec(r.Context(),
+		`INSERT INTO driver_applications (driver_id, user_id, name, phone, vehicle_model, vehicle_plate)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (driver_id) DO UPDATE
+		 SET name = EXCLUDED.name, phone = EXCLUDED.phone, vehicle_model = EXCLUDED.vehicle_model,
+		     vehicle_plate = EXCLUDED.vehicle_plate, status = 'draft', updated_at = NOW()
+		 WHERE driver_applications.status IN ('draft', 'rejected')`,
+		claims.Username, claims.UserID, strings.TrimSpace(req.Name),
+		strings.ReplaceAll(strings.TrimSpace(req.Phone), " ", ""),
+		strings.TrimSpace(req.VehicleModel), normalizePlate(req.VehiclePlate))
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		respondApplicationError(w, errApplicationState)
+		return
+	}
+
+	a, err := loadApplication(r.Context(), dbPool, claims.Username, false)
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(a))
+}
+
+// uploadDocumentHandler stores one document from a multipart form with a
+// "file" part and an optional "expires_on" date. Uploading a type again
+// replaces it, which is how approved drivers renew expiring documents.
+func uploadDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	docType := mux.Vars(r)["type"]
+	if !validDocumentType(docType) {
+		respondJSON(w, http.StatusBadRequest, errorResponse("unknown document type"))
+		return
+	}
+	ctx := r.Context()
+
+	if _, err := loadApplication(ctx, dbPool, claims.Username, false); err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxDocumentSize+1<<20)
+	if err := r.ParseMultipartForm(maxDocumentSize); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("file must be at most 10MB"))
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("file is required"))
+		return
+	}
+	defer file.Close()
+
+	var expiresOn *time.Time
+	if v := r.FormValue("expires_on"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, errorResponse("expires_on must be a YYYY-MM-DD date"))
+			return
+		}
+		expiresOn = &t
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	contentType := http.DetectContentType(head[:n])
+	ext, ok := allowedDocumentTypes[contentType]
+	if !ok {
+		respondJSON(w, http.StatusBadRequest, errorResponse("documents must be PDF, JPEG or PNG"))
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid upload"))
+		return
+	}
+
+	store, err := getBlobStore()
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	key := fmt.Sprintf("drivers/%s/%s-%s%s", claims.Username, docType, hex.EncodeToString(suffix), ext)
+	if err := store.Put(ctx, key, file); err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+
+	var oldKey *string
+	err = dbPool.QueryRow(ctx,
+		`WITH old AS (SELECT blob_key FROM driver_documents WHERE driver_id = $1 AND doc_type = $2)
+		 INSERT INTO driver_documents (driver_id, doc_type, blob_key, content_type, size_bytes, expires_on)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (driver_id, doc_type) DO UPDATE
+		 SET blob_key = EXCLUDED.blob_key, content_type = EXCLUDED.content_type, size_bytes = EXCLUDED.size_bytes,
+		     expires_on = EXCLUDED.expires_on, status = 'pending', uploaded_at = NOW()
+		 RETURNING (SELECT blob_key FROM old)`,
+		claims.Username, docType, key, contentType, header.Size, expiresOn).Scan(&oldKey)
+	if err != nil {
+		store.Delete(ctx, key)
+		respondApplicationError(w, err)
+		return
+	}
+	if oldKey != nil {
+		if err := store.Delete(ctx, *oldKey); err != nil {
+			log.Printf("Failed to delete replaced document %s: %v", *oldKey, err)
+		}
+	}
+
+	a, err := loadApplication(ctx, dbPool, claims.Username, false)
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, successResponse(a))
+}
+
+func submitApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	a, err := submitApplication(r.Context(), claims.Username)
+	if errors.Is(err, errApplicationProblems) && a != nil {
+		respondJSON(w, http.StatusBadRequest, RideResponse{
+			Success: false,
+			Data:    a.Problems,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(a))
+}
+
+// submitApplication sends a complete draft for review. An incomplete one is
+// returned with its problems alongside errApplicationProblems.
+func submitApplication(ctx context.Context, driverID string) (*DriverApplication, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	a, err := loadApplication(ctx, tx, driverID, true)
+	if err != nil {
+		return nil, err
+	}
+	if a.Status != "draft" && a.Status != "rejected" {
+		return nil, errApplicationState
+	}
+	if len(a.Problems) > 0 {
+		return a, errApplicationProblems
+	}
+
+	if err := tx.QueryRow(ctx,
+		`UPDATE driver_applications
+		 SET status = 'submitted', submitted_at = NOW(), rejection_reason = NULL, updated_at = NOW()
+		 WHERE driver_id = $1
+		 RETURNING status, submitted_at, updated_at`,
+		driverID).Scan(&a.Status, &a.SubmittedAt, &a.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to submit application: %w", err)
+	}
+	a.RejectionReason = ""
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return a, nil
+}
+
+func listApplicationsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok || claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "submitted"
+	}
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT driver_id FROM driver_applications WHERE status = $1 ORDER BY submitted_at NULLS LAST, created_at LIMIT 100`,
+		status)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+		return
+	}
+
+	applications := []*DriverApplication{}
+	for _, id := range ids {
+		a, err := loadApplication(r.Context(), dbPool, id, false)
+		if err != nil {
+			respondApplicationError(w, err)
+			return
+		}
+		applications = append(applications, a)
+	}
+	respondJSON(w, http.StatusOK, successResponse(applications))
+}
+
+// documentFileHandler streams an uploaded document to an admin reviewing it.
+func documentFileHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok || claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+	vars := mux.Vars(r)
+
+	docs, err := loadDocuments(r.Context(), dbPool, vars["id"])
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	var doc *DriverDocument
+	for i := range docs {
+		if docs[i].Type == vars["type"] {
+			doc = &docs[i]
+		}
+	}
+	if doc == nil {
+		respondJSON(w, http.StatusNotFound, errorResponse("document not found"))
+		return
+	}
+
+	store, err := getBlobStore()
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	blob, err := store.Get(r.Context(), doc.blobKey)
+	if err != nil {
+		log.Printf("Failed to read document %s: %v", doc.blobKey, err)
+		respondJSON(w, http.StatusNotFound, errorResponse("document not found"))
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", doc.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s-%s%s"`,
+		vars["id"], doc.Type, allowedDocumentTypes[doc.ContentType]))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, blob)
+}
+
+func approveApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok || claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	a, err := approveApplication(r.Context(), mux.Vars(r)["id"], claims.UserID)
+	if errors.Is(err, errApplicationProblems) && a != nil {
+		respondJSON(w, http.StatusBadRequest, RideResponse{
+			Success: false,
+			Data:    a.Problems,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(a))
+}
+
+// approveApplication turns a submitted application into a driver. New
+// drivers start offline; their first heartbeat gives them a location.
+func approveApplication(ctx context.Context, driverID string, adminID int) (*DriverApplication, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	a, err := loadApplication(ctx, tx, driverID, true)
+	if err != nil {
+		return nil, err
+	}
+	if a.Status != "submitted" {
+		return nil, errApplicationState
+	}
+	if len(a.Problems) > 0 {
+		return a, errApplicationProblems
+	}
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO drivers (driver_id, name, current_location, approved_at)
+		 VALUES ($1, $2, ST_SetSRID(ST_MakePoint(0, 0), 4326), NOW())
+		 ON CONFLICT (driver_id) DO UPDATE
+		 SET name = EXCLUDED.name, approved_at = NOW()`,
+		driverID, a.Name); err != nil {
+		return nil, fmt.Errorf("failed to create driver: %w", err)
+	}
+	if err := registerApplicantVehicle(ctx, tx, a); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE driver_documents SET status = 'approved' WHERE driver_id = $1`,
+		driverID); err != nil {
+		return nil, fmt.Errorf("failed to approve documents: %w", err)
+	}
+	if err := tx.QueryRow(ctx,
+		`UPDATE driver_applications
+		 SET status = 'approved', reviewed_at = NOW(), reviewed_by = $1, updated_at = NOW()
+		 WHERE driver_id = $2
+		 RETURNING status, reviewed_at, updated_at`,
+		adminID, driverID).Scan(&a.Status, &a.ReviewedAt, &a.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to approve application: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	for i := range a.Documents {
+		a.Documents[i].Status = "approved"
+	}
+	return a, nil
+}
+
+// rejectApplicationHandler sends a submitted application back with a reason.
+// Documents listed in "documents" are marked rejected and must be uploaded
+// again before resubmitting.
+func rejectApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok || claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	var req struct {
+		Reason    string   `json:"reason"`
+		Documents []string `json:"documents"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		respondJSON(w, http.StatusBadRequest, errorResponse("reason is required"))
+		return
+	}
+	for _, t := range req.Documents {
+		if !validDocumentType(t) {
+			respondJSON(w, http.StatusBadRequest, errorResponse("unknown document type: "+t))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	driverID := mux.Vars(r)["id"]
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE driver_applications
+		 SET status = 'rejected', rejection_reason = $1, reviewed_at = NOW(), reviewed_by = $2, updated_at = NOW()
+		 WHERE driver_id = $3 AND status = 'submitted'`,
+		req.Reason, claims.UserID, driverID)
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		respondApplicationError(w, errApplicationState)
+		return
+	}
+	if len(req.Documents) > 0 {
+		if _, err := tx.Exec(ctx,
+			`UPDATE driver_documents SET status = 'rejected' WHERE driver_id = $1 AND doc_type = ANY($2)`,
+			driverID, req.Documents); err != nil {
+			respondApplicationError(w, err)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+
+	a, err := loadApplication(ctx, dbPool, driverID, false)
+	if err != nil {
+		respondApplicationError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(a))
+}
+
+// registerApplicantVehicle gives a newly approved driver the vehicle from
+// their application as their own. A plate already registered, such as a
+// fleet vehicle, is left to its owner to authorise the driver for.
+func registerApplicantVehicle(ctx context.Context, tx pgx.Tx, a *DriverApplication) error {
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO vehicles (plate, model, owner_driver_id) VALUES ($1, $2, $3)
+		 ON CONFLICT (plate) DO NOTHING`,
+		normalizePlate(a.VehiclePlate), a.VehicleModel, a.DriverID); err != nil {
+		return fmt.Errorf("failed to register vehicle: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO vehicle_drivers (vehicle_id, driver_id)
+		 SELECT id, owner_driver_id FROM vehicles WHERE plate = $1 AND owner_driver_id = $2
+		 ON CONFLICT DO NOTHING`,
+		normalizePlate(a.VehiclePlate), a.DriverID); err != nil {
+		return fmt.Errorf("failed to authorise vehicle: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE drivers SET vehicle_id = v.id
+		 FROM vehicles v
+		 WHERE drivers.driver_id = $2 AND drivers.vehicle_id IS NULL AND v.plate = $1 AND v.owner_driver_id = $2`,
+		normalizePlate(a.VehiclePlate), a.DriverID); err != nil {
+		return fmt.Errorf("failed to set vehicle: %w", err)
+	}
+	return nil
+}
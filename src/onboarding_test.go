@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDocumentProblems(t *testing.T) {
+	today := time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC)
+	date := func(y int, m time.Month, d int) *time.Time {
+		t := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	valid := []DriverDocument{
+		{Type: "licence", ExpiresOn: date(2028, 1, 1), Status: "pending"},
+		{Type: "national_id", Status: "approved"},
+		{Type: "vehicle_registration", Status: "pending"},
+		{Type: "insurance", ExpiresOn: date(2026, 3, 10), Status: "pending"},
+	}
+	if problems := documentProblems(valid, today); problems != nil {
+		t.Fatalf("Expected documents expiring today to be valid, got %v", problems)
+	}
+
+	docs := []DriverDocument{
+		{Type: "licence", ExpiresOn: date(2026, 3, 9), Status: "approved"},
+		{Type: "vehicle_registration", Status: "rejected"},
+		{Type: "insurance", Status: "pending"},
+	}
+	want := []string{
+		"licence has expired",
+		"national_id is missing",
+		"vehicle_registration was rejected",
+		"insurance needs an expiry date",
+	}
+	if got := documentProblems(docs, today); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	t.Setenv("BLOB_DIR", t.TempDir())
+	ctx := context.Background()
+	store := localBlobStore{}
+
+	if err := store.Put(ctx, "drivers/sam/licence-1.pdf", strings.NewReader("%PDF-1.4")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	blob, err := store.Get(ctx, "drivers/sam/licence-1.pdf")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	if string(data) != "%PDF-1.4" {
+		t.Errorf("Expected the stored content back, got %q", data)
+	}
+
+	if err := store.Delete(ctx, "drivers/sam/licence-1.pdf"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Get(ctx, "drivers/sam/licence-1.pdf"); !errors.Is(err, errBlobNotFound) {
+		t.Errorf("Expected errBlobNotFound after delete, got %v", err)
+	}
+
+	for _, key := range []string{"", "../escape.pdf", "drivers/../../escape.pdf"} {
+		if err := store.Put(ctx, key, strings.NewReader("x")); err == nil {
+			t.Errorf("Expected key %q to be rejected", key)
+		}
+	}
+}
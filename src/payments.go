@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 	"bytes"
 )
@@ -27,20 +28,141 @@ type FlutterwavePaymentResponse struct {
 	} `json:"data"`
 }
 
-func ProcessPayment(rideID string, amount float64, userEmail string) (string, error) {
+// PaymentRequest describes a single collection from a customer. Amount is in
+// the minor units of Currency; providers convert to whatever their API takes.
+type PaymentRequest struct {
+	TxRef    string
+	Amount   Money
+	Currency string
+	Email    string
+	Phone    string
+	RideID   string
+}
+
+// PaymentProvider collects money from customers through an external gateway.
+// InitiatePayment returns the link (or provider reference) the customer uses to
+// complete the payment and VerifyPayment reports whether txRef has been paid.
+type PaymentProvider interface {
+	Name() string
+	InitiatePayment(req PaymentRequest) (string, error)
+	VerifyPayment(txRef string) (bool, error)
+}
+
+// PayoutRequest describes a disbursement to a mobile money wallet.
+type PayoutRequest struct {
+	Reference string
+	Amount    Money
+	Currency  string
+	Phone     string
+	Network   string // "mtn" or "airtel"
+	Narration string
+}
+
+// PayoutProvider sends money out to a recipient. Disburse returns the
+// provider's reference for the transfer.
+type PayoutProvider interface {
+	Name() string
+	Disburse(req PayoutRequest) (string, error)
+}
+
+const (
+	paymentMethodFlutterwave = "flutterwave"
+	paymentMethodWallet      = "wallet"
+	paymentMethodCash        = "cash"
+)
+
+var paymentProviders = map[string]PaymentProvider{
+	"flutterwave": flutterwaveProvider{},
+	"mtn":         mtnProvider{},
+	"airtel":      airtelProvider{},
+}
+
+// defaultPaymentLinkTTL is how long a pending payment's link is handed out
+// again before it is checked and replaced.
+const defaultPaymentLinkTTL = time.Hour
+
+func paymentLinkTTL() time.Duration {
+	return envDuration("PAYMENT_LINK_TTL", defaultPaymentLinkTTL)
+}
+
+// paymentLinkFresh reports whether a link started at createdAt can still be
+// reused, so a second request does not open a second payment.
+func paymentLinkFresh(createdAt, now time.Time, ttl time.Duration) bool {
+	return now.Sub(createdAt) < ttl
+}
+
+func getPaymentProvider(name string) (PaymentProvider, error) {
+	if name == "" {
+		name = "flutterwave"
+	}
+	provider, ok := paymentProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment provider: %s", name)
+	}
+	return provider, nil
+}
+
+var payoutProviders = map[string]PayoutProvider{
+	"flutterwave": flutterwaveProvider{},
+	"mtn":         mtnProvider{},
+	"airtel":      airtelProvider{},
+}
+
+func getPayoutProvider(name string) (PayoutProvider, error) {
+	if name == "" {
+		name = "flutterwave"
+	}
+	provider, ok := payoutProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown payout provider: %s", name)
+	}
+	return provider, nil
+}
+
+func validPayoutNetwork(network string) bool {
+	return network == "mtn" || network == "airtel"
+}
+
+func validPaymentMethod(method string) bool {
+	switch method {
+	case paymentMethodFlutterwave, paymentMethodWallet, paymentMethodCash:
+		return true
+	}
+	return false
+}
+
+func ProcessPayment(rideID string, amount Money, currency, userEmail string) (string, error) {
+	return flutterwaveProvider{}.InitiatePayment(PaymentRequest{
+		TxRef:    fmt.Sprintf("ride-%s-%d", rideID, time.Now().Unix()),
+		Amount:   amount,
+		Currency: currency,
+		Email:    userEmail,
+		RideID:   rideID,
+	})
+}
+
+type flutterwaveProvider struct{}
+
+func (flutterwaveProvider) Name() string { return "flutterwave" }
+
+func (flutterwaveProvider) VerifyPayment(txRef string) (bool, error) { return VerifyPayment(txRef) }
+
+func (flutterwaveProvider) InitiatePayment(pr PaymentRequest) (string, error) {
 	// Initialize Flutterwave config
 	flutterwaveSecretKey := os.Getenv("FLUTTERWAVE_SECRET_KEY")
 	if flutterwaveSecretKey == "" {
 		return "", errors.New("flutterwave secret key not configured")
 	}
 
-	// Create payment request
+	// Create payment request (Flutterwave takes major units)
+	currency := currencyFor(pr.Currency)
 	paymentReq := FlutterwavePaymentRequest{
-		TxRef:    fmt.Sprintf("ride-%s-%d", rideID, time.Now().Unix()),
-		Amount:   amount,
-		Currency: "UGX", // Ugandan Shillings
-		Email:    userEmail,
-		RideID:   rideID,
+		TxRef:    pr.TxRef,
+		Amount:   currency.ToMajor(pr.Amount),
+		Currency: currency.Code,
+		Email:    pr.Email,
+		Phone:    pr.Phone,
+		RideID:   pr.RideID,
 	}
 
 	// Convert to JSON
@@ -80,6 +202,72 @@ func ProcessPayment(rideID string, amount float64, userEmail string) (string, er
 	return paymentResp.Data.Link, nil
 }
 
+type FlutterwaveTransferRequest struct {
+	AccountBank   string  `json:"account_bank"`
+	AccountNumber string  `json:"account_number"`
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	Reference     string  `json:"reference"`
+	Narration     string  `json:"narration,omitempty"`
+	Meta          struct {
+		Network string `json:"mobile_network,omitempty"`
+	} `json:"meta"`
+}
+
+// Disburse sends a mobile money transfer through Flutterwave, which routes
+// Ugandan MTN and Airtel numbers via its "MPS" bank code.
+func (flutterwaveProvider) Disburse(pr PayoutRequest) (string, error) {
+	flutterwaveSecretKey := os.Getenv("FLUTTERWAVE_SECRET_KEY")
+	if flutterwaveSecretKey == "" {
+		return "", errors.New("flutterwave secret key not configured")
+	}
+
+	currency := currencyFor(pr.Currency)
+	transferReq := FlutterwaveTransferRequest{
+		AccountBank:   "MPS",
+		AccountNumber: pr.Phone,
+		Amount:        currency.ToMajor(pr.Amount),
+		Currency:      currency.Code,
+		Reference:     pr.Reference,
+		Narration:     pr.Narration,
+	}
+	transferReq.Meta.Network = strings.ToUpper(pr.Network)
+
+	reqBody, err := json.Marshal(transferReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to create transfer request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", "https://api.flutterwave.com/v3/transfers", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+flutterwaveSecretKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send transfer request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var transferResp struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Data    struct {
+			ID int64 `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&transferResp); err != nil {
+		return "", fmt.Errorf("failed to decode transfer response: %w", err)
+	}
+	if transferResp.Status != "success" {
+		return "", fmt.Errorf("transfer failed: %s", transferResp.Message)
+	}
+	return fmt.Sprintf("%d", transferResp.Data.ID), nil
+}
+
 func VerifyPayment(txRef string) (bool, error) {
 	flutterwaveSecretKey := os.Getenv("FLUTTERWAVE_SECRET_KEY")
 	url := fmt.Sprintf("https://api.flutterwave.com/v3/transactions/verify_by_reference?tx_ref=%s", txRef)
@@ -112,14 +300,46 @@ func VerifyPayment(txRef string) (bool, error) {
 	return verificationResponse.Data.Status == "successful", nil
 }
 
-func ProcessMTNPayment(phone string, amount float64) (string, error) {
+func ProcessMTNPayment(phone string, amount Money) (string, error) {
     return "", errors.New("MTN payment not implemented")
 }
 
-func ProcessAirtelPayment(phone string, amount float64) (string, error) {
+func ProcessAirtelPayment(phone string, amount Money) (string, error) {
     return "", errors.New("Airtel payment not implemented")
 }
 
-func ProcessChipperPayment(email string, amount float64) (string, error) {
+func ProcessChipperPayment(email string, amount Money) (string, error) {
     return "", errors.New("Chipper payment not implemented")
 }
+
+type mtnProvider struct{}
+
+func (mtnProvider) Name() string { return "mtn" }
+
+func (mtnProvider) InitiatePayment(pr PaymentRequest) (string, error) {
+	return ProcessMTNPayment(pr.Phone, pr.Amount)
+}
+
+func (mtnProvider) VerifyPayment(txRef string) (bool, error) {
+	return false, errors.New("MTN payment verification not implemented")
+}
+
+func (mtnProvider) Disburse(pr PayoutRequest) (string, error) {
+	return "", errors.New("MTN disbursement not implemented")
+}
+
+type airtelProvider struct{}
+
+func (airtelProvider) Name() string { return "airtel" }
+
+func (airtelProvider) InitiatePayment(pr PaymentRequest) (string, error) {
+	return ProcessAirtelPayment(pr.Phone, pr.Amount)
+}
+
+func (airtelProvider) VerifyPayment(txRef string) (bool, error) {
+	return false, errors.New("Airtel payment verification not implemented")
+}
+
+func (airtelProvider) Disburse(pr PayoutRequest) (string, error) {
+	return "", errors.New("Airtel disbursement not implemented")
+}
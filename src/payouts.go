@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+type Payout struct {
+	ID                int64     `json:"id"`
+	DriverID          string    `json:"driver_id"`
+	Amount            Money     `json:"amount"`
+	Currency          string    `json:"currency"`
+	Network           string    `json:"network"`
+	Phone             string    `json:"phone"`
+	Status            string    `json:"status"`
+	Attempts          int       `json:"attempts"`
+	LastError         string    `json:"last_error,omitempty"`
+	ProviderReference string    `json:"provider_reference,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+const (
+	defaultPayoutInterval  = time.Hour
+	defaultPayoutMinAmount = 5000
+	maxPayoutAttempts      = 5
+	payoutBatchSize        = 100
+	payoutRetryBaseDelay   = 5 * time.Minute
+)
+
+// startPayoutScheduler periodically sweeps driver balances into payouts and
+// disburses them. PAYOUT_INTERVAL controls how often it runs.
+func startPayoutScheduler() {
+	interval := envDuration("PAYOUT_INTERVAL", defaultPayoutInterval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runPayoutCycle(context.Background())
+		}
+	}()
+}
+
+func runPayoutCycle(ctx context.Context) {
+	created, err := batchPayouts(ctx)
+	if err != nil {
+		log.Printf("Payout batching failed: %v", err)
+	} else if created > 0 {
+		log.Printf("Created %d driver payouts", created)
+	}
+
+	if err := processPayouts(ctx); err != nil {
+		log.Printf("Payout processing failed: %v", err)
+	}
+}
+
+// batchPayouts moves every driver balance above PAYOUT_MIN_AMOUNT (in minor
+// units of the balance's currency) into a pending payout, debiting the balance
+// in the same transaction.
+func batchPayouts(ctx context.Context) (int, error) {
+	minAmount := envInt("PAYOUT_MIN_AMOUNT", defaultPayoutMinAmount)
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`SELECT b.driver_id, b.balance, b.currency, d.payout_network, d.payout_phone
+		 FROM driver_balances b
+		 JOIN drivers d ON d.driver_id = b.driver_id
+		 WHERE b.balance >= $1 AND d.payout_phone IS NOT NULL
+		 ORDER BY b.driver_id
+		 LIMIT $2
+		 FOR UPDATE OF b SKIP LOCKED`,
+		minAmount, payoutBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var due []Payout
+	for rows.Next() {
+		var p Payout
+		if err := rows.Scan(&p.DriverID, &p.Amount, &p.Currency, &p.Network, &p.Phone); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range due {
+		var payoutID int64
+		if err := tx.QueryRow(ctx,
+			`INSERT INTO payouts (driver_id, amount, currency, network, phone, status)
+			 VALUES ($1, $2, $3, $4, $5, 'pending')
+			 RETURNING id`,
+			p.DriverID, p.Amount, p.Currency, p.Network, p.Phone).Scan(&payoutID); err != nil {
+			return 0, fmt.Errorf("failed to create payout for %s: %w", p.DriverID, err)
+		}
+		if err := adjustDriverBalance(ctx, tx, p.DriverID, -p.Amount, p.Currency, "payout", "", payoutReference(payoutID)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(due), nil
+}
+
+// processPayouts claims due payouts and sends them through PAYOUT_PROVIDER.
+// Failures are retried with exponential backoff; a payout that runs out of
+// attempts is returned to the driver's balance. Claimed payouts are marked
+// processing before the provider is called so a crash mid-transfer never
+// results in a second disbursement.
+func processPayouts(ctx context.Context) error {
+	provider, err := getPayoutProvider(os.Getenv("PAYOUT_PROVIDER"))
+	if err != nil {
+		return err
+	}
+
+	rows, err := dbPool.Query(ctx,
+		`UPDATE payouts SET status = 'processing', attempts = attempts + 1, updated_at = NOW()
+		 WHERE id IN (
+		     SELECT id FROM payouts
+		     WHERE status IN ('pending', 'failed') AND next_attempt_at <= NOW()
+		     ORDER BY id
+		     LIMIT $1
+		     FOR UPDATE SKIP LOCKED)
+		 RETURNING id, driver_id, amount, currency, network, phone, attempts`,
+		payoutBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var claimed []Payout
+	for rows.Next() {
+		var p Payout
+		if err := rows.Scan(&p.ID, &p.DriverID, &p.Amount, &p.Currency, &p.Network, &p.Phone, &p.Attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		claimed = append(claimed, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range claimed {
+		ref, sendErr := provider.Disburse(PayoutRequest{
+			Reference: payoutReference(p.ID),
+			Amount:    p.Amount,
+			Currency:  p.Currency,
+			Phone:     p.Phone,
+			Network:   p.Network,
+			Narration: "Driver earnings payout",
+		})
+		if sendErr == nil {
+			_, err := dbPool.Exec(ctx,
+				`UPDATE payouts SET status = 'paid', provider_reference = $1, last_error = '', updated_at = NOW()
+				 WHERE id = $2`,
+				ref, p.ID)
+			if err != nil {
+				log.Printf("Payout %d sent but status update failed: %v", p.ID, err)
+			}
+			continue
+		}
+
+		log.Printf("Payout %d to %s failed (attempt %d): %v", p.ID, p.DriverID, p.Attempts, sendErr)
+		if p.Attempts >= maxPayoutAttempts {
+			if err := returnPayout(ctx, p, sendErr.Error()); err != nil {
+				log.Printf("Failed to return payout %d: %v", p.ID, err)
+			}
+			continue
+		}
+		if _, err := dbPool.Exec(ctx,
+			`UPDATE payouts SET status = 'failed', last_error = $1, next_attempt_at = $2, updated_at = NOW()
+			 WHERE id = $3`,
+			sendErr.Error(), time.Now().Add(payoutRetryDelay(p.Attempts)), p.ID); err != nil {
+			log.Printf("Failed to schedule retry for payout %d: %v", p.ID, err)
+		}
+	}
+	return nil
+}
+
+// returnPayout gives up on a payout and credits its amount back to the driver.
+func returnPayout(ctx context.Context, p Payout, reason string) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE payouts SET status = 'returned', last_error = $1, updated_at = NOW() WHERE id = $2`,
+		reason, p.ID); err != nil {
+		return err
+	}
+	if err := adjustDriverBalance(ctx, tx, p.DriverID, p.Amount, p.Currency, "payout_reversal", "", payoutReference(p.ID)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func payoutRetryDelay(attempts int) time.Duration {
+	return payoutRetryBaseDelay * time.Duration(1<<(attempts-1))
+}
+
+func payoutReference(id int64) string {
+	return fmt.Sprintf("payout-%d", id)
+}
+
+func updatePayoutAccountHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	var req struct {
+		Network string `json:"network"`
+		Phone   string `json:"phone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	if !validPayoutNetwork(req.Network) || req.Phone == "" {
+		respondJSON(w, http.StatusBadRequest, errorResponse("network must be mtn or airtel and phone is required"))
+		return
+	}
+
+	tag, err := dbPool.Exec(r.Context(),
+		`UPDATE drivers SET payout_network = $1, payout_phone = $2 WHERE driver_id = $3`,
+		req.Network, req.Phone, claims.Username)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		respondJSON(w, http.StatusNotFound, errorResponse("driver not found"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(map[string]string{
+		"network": req.Network,
+		"phone":   req.Phone,
+	}))
+}
+
+func listPayoutsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT id, driver_id, amount, currency, network, phone, status, attempts, last_error,
+		        COALESCE(provider_reference, ''), created_at, updated_at
+		 FROM payouts
+		 WHERE driver_id = $1
+		 ORDER BY created_at DESC
+		 LIMIT 50`,
+		claims.Username)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	defer rows.Close()
+
+	payouts := []Payout{}
+	for rows.Next() {
+		var p Payout
+		if err := rows.Scan(&p.ID, &p.DriverID, &p.Amount, &p.Currency, &p.Network, &p.Phone, &p.Status,
+			&p.Attempts, &p.LastError, &p.ProviderReference, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+			return
+		}
+		payouts = append(payouts, p)
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(payouts))
+}
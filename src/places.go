@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Place is a search result riders can pick as a pickup or dropoff. IDs are
+// prefixed with their source ("osm:" or "gaz:") and can be sent in ride
+// requests in place of coordinates.
+type Place struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Address    string   `json:"address,omitempty"`
+	Lat        float64  `json:"lat"`
+	Lng        float64  `json:"lng"`
+	City       string   `json:"city,omitempty"`
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+}
+
+// PlaceQuery is a search, optionally biased toward the rider's location.
+type PlaceQuery struct {
+	Text         string
+	Lat, Lng     *float64
+	Limit        int
+	Autocomplete bool
+}
+
+// PlaceSearcher finds places by name. Geocoders that can search implement it
+// alongside Geocoder.
+type PlaceSearcher interface {
+	Name() string
+	RateLimit() int
+	SearchPlaces(ctx context.Context, q PlaceQuery) ([]Place, error)
+}
+
+const (
+	defaultPlacesCacheTTL = 24 * time.Hour
+	defaultPlacesLimit    = 10
+	maxPlacesLimit        = 20
+	minAutocompleteLength = 3
+)
+
+var errUnknownPlace = errors.New("unknown place")
+
+// gazetteer is the offline fallback: well-known places in the cities we
+// operate in.
+var gazetteer = []Place{
+	{ID: "gaz:nakasero-market", Name: "Nakasero Market", Address: "Market Street, Nakasero", Lat: 0.3136, Lng: 32.5784, City: "Kampala"},
+	{ID: "gaz:owino-market", Name: "Owino Market", Address: "St. Balikuddembe Market, Kampala", Lat: 0.3115, Lng: 32.5736, City: "Kampala"},
+	{ID: "gaz:old-taxi-park", Name: "Old Taxi Park", Address: "Burton Street, Kampala", Lat: 0.3132, Lng: 32.5770, City: "Kampala"},
+	{ID: "gaz:garden-city", Name: "Garden City Mall", Address: "Yusuf Lule Road, Kampala", Lat: 0.3213, Lng: 32.5913, City: "Kampala"},
+	{ID: "gaz:acacia-mall", Name: "Acacia Mall", Address: "Cooper Road, Kololo", Lat: 0.3361, Lng: 32.5870, City: "Kampala"},
+	{ID: "gaz:makerere-university", Name: "Makerere University", Address: "University Road, Makerere", Lat: 0.3355, Lng: 32.5680, City: "Kampala"},
+	{ID: "gaz:mulago-hospital", Name: "Mulago Hospital", Address: "Upper Mulago Hill Road, Mulago", Lat: 0.3378, Lng: 32.5760, City: "Kampala"},
+	{ID: "gaz:kampala-serena", Name: "Kampala Serena Hotel", Address: "Kintu Road, Nakasero", Lat: 0.3165, Lng: 32.5860, City: "Kampala"},
+	{ID: "gaz:ntinda", Name: "Ntinda Shopping Centre", Address: "Ntinda Road, Ntinda", Lat: 0.3540, Lng: 32.6160, City: "Kampala"},
+	{ID: "gaz:entebbe-airport", Name: "Entebbe International Airport", Address: "Airport Road, Entebbe", Lat: 0.0424, Lng: 32.4435, City: "Entebbe"},
+	{ID: "gaz:entebbe-botanical", Name: "Entebbe Botanical Gardens", Address: "Berkeley Road, Entebbe", Lat: 0.0596, Lng: 32.4774, City: "Entebbe"},
+	{ID: "gaz:victoria-mall", Name: "Victoria Mall", Address: "Kampala Road, Entebbe", Lat: 0.0620, Lng: 32.4640, City: "Entebbe"},
+	{ID: "gaz:jkia", Name: "Jomo Kenyatta International Airport", Address: "Airport North Road, Embakasi", Lat: -1.3192, Lng: 36.9278, City: "Nairobi"},
+	{ID: "gaz:westgate", Name: "Westgate Mall", Address: "Mwanzi Road, Westlands", Lat: -1.2568, Lng: 36.8030, City: "Nairobi"},
+	{ID: "gaz:sarit-centre", Name: "Sarit Centre", Address: "Karuna Close, Westlands", Lat: -1.2610, Lng: 36.8020, City: "Nairobi"},
+	{ID: "gaz:kicc", Name: "Kenyatta International Convention Centre", Address: "Harambee Avenue, Nairobi CBD", Lat: -1.2882, Lng: 36.8233, City: "Nairobi"},
+	{ID: "gaz:city-market-nairobi", Name: "City Market", Address: "Muindi Mbingu Street, Nairobi CBD", Lat: -1.2830, Lng: 36.8190, City: "Nairobi"},
+	{ID: "gaz:nairobi-hospital", Name: "Nairobi Hospital", Address: "Argwings Kodhek Road, Upper Hill", Lat: -1.2960, Lng: 36.8060, City: "Nairobi"},
+}
+
+// getPlaceSearcher returns the configured geocoder when it can search, and
+// otherwise the gazetteer.
+func getPlaceSearcher() PlaceSearcher {
+	g, err := getGeocoder()
+	if err != nil {
+		return gazetteerSearcher{}
+	}
+	if s, ok := g.(PlaceSearcher); ok {
+		return s
+	}
+	return gazetteerSearcher{}
+}
+
+func placesSearchHandler(w http.ResponseWriter, r *http.Request) {
+	servePlaces(w, r, false)
+}
+
+func placesAutocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	servePlaces(w, r, true)
+}
+
+func servePlaces(w http.ResponseWriter, r *http.Request, autocomplete bool) {
+	q, err := parsePlaceQuery(r.URL.Query(), autocomplete)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if autocomplete && len([]rune(q.Text)) < minAutocompleteLength {
+		respondJSON(w, http.StatusOK, successResponse([]Place{}))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(searchPlaces(r.Context(), q)))
+}
+
+func parsePlaceQuery(v url.Values, autocomplete bool) (PlaceQuery, error) {
+	q := PlaceQuery{Text: strings.Join(strings.Fields(v.Get("q")), " "), Limit: defaultPlacesLimit, Autocomplete: autocomplete}
+	if q.Text == "" {
+		return q, errors.New("q is required")
+	}
+	if v.Get("lat") != "" || v.Get("lng") != "" {
+		lat, err1 := strconv.ParseFloat(v.Get("lat"), 64)
+		lng, err2 := strconv.ParseFloat(v.Get("lng"), 64)
+		if err1 != nil || err2 != nil || !validCoordinates(lat, lng) {
+			return q, errors.New("Invalid coordinates")
+		}
+		q.Lat, q.Lng = &lat, &lng
+	}
+	if l := v.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return q, errors.New("limit must be a positive number")
+		}
+		q.Limit = min(n, maxPlacesLimit)
+	}
+	return q, nil
+}
+
+// searchPlaces serves repeated queries from Redis and falls back to the
+// gazetteer when the provider is rate limited, fails or finds nothing.
+// Every place returned is remembered so its ID can be used in a ride request.
+func searchPlaces(ctx context.Context, q PlaceQuery) []Place {
+	s := getPlaceSearcher()
+	key := placesCacheKey(s.Name(), q)
+	if data, err := redisClient.Get(ctx, key).Bytes(); err == nil {
+		var places []Place
+		if json.Unmarshal(data, &places) == nil {
+			return places
+		}
+	}
+
+	var places []Place
+	var err error
+	if !allowGeocoderRequest(ctx, s.Name(), s.RateLimit()) {
+		err = errGeocoderRateLimited
+	} else {
+		places, err = s.SearchPlaces(ctx, q)
+	}
+	if err != nil {
+		log.Printf("%s place search failed, using gazetteer: %v", s.Name(), err)
+	}
+	if len(places) == 0 {
+		places, _ = gazetteerSearcher{}.SearchPlaces(ctx, q)
+	}
+	if places == nil {
+		places = []Place{}
+	}
+	for i := range places {
+		if q.Lat != nil {
+			d := math.Round(haversineKm(*q.Lat, *q.Lng, places[i].Lat, places[i].Lng)*10) / 10
+			places[i].DistanceKm = &d
+		}
+	}
+
+	// Fallback results are not cached so the provider is retried next time.
+	if err == nil {
+		if data, err := json.Marshal(places); err == nil {
+			redisClient.Set(ctx, key, data, envDuration("PLACES_CACHE_TTL", defaultPlacesCacheTTL))
+		}
+	}
+	for _, p := range places {
+		rememberPlace(ctx, p)
+	}
+	return places
+}
+
+// placesCacheKey rounds the bias point to about 1km so nearby riders share
+// cached results.
+func placesCacheKey(provider string, q PlaceQuery) string {
+	mode := "search"
+	if q.Autocomplete {
+		mode = "autocomplete"
+	}
+	near := "-"
+	if q.Lat != nil {
+		near = fmt.Sprintf("%.2f,%.2f", *q.Lat, *q.Lng)
+	}
+	return fmt.Sprintf("places:%s:%s:%s:%d:%s", provider, mode, near, q.Limit, strings.ToLower(q.Text))
+}
+
+func placeKey(id string) string {
+	return "place:" + id
+}
+
+func rememberPlace(ctx context.Context, p Place) {
+	if strings.HasPrefix(p.ID, "gaz:") {
+		return
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	if err := redisClient.Set(ctx, placeKey(p.ID), data, envDuration("GEOCODE_CACHE_TTL", defaultGeocodeCacheTTL)).Err(); err != nil {
+		log.Printf("Failed to remember place %s: %v", p.ID, err)
+	}
+}
+
+// lookupPlace resolves a place ID returned by a search.
+func lookupPlace(ctx context.Context, id string) (*Place, error) {
+	for i := range gazetteer {
+		if gazetteer[i].ID == id {
+			p := gazetteer[i]
+			return &p, nil
+		}
+	}
+	data, err := redisClient.Get(ctx, placeKey(id)).Bytes()
+	if err != nil {
+		return nil, errUnknownPlace
+	}
+	var p Place
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, errUnknownPlace
+	}
+	return &p, nil
+}
+
+// resolveRidePlaces fills in a ride request's coordinates from the place IDs
+// it was sent with: search results or the rider's saved places.
+func resolveRidePlaces(ctx context.Context, riderID int, req *RideRequest) error {
+	resolve := func(id string, lat, lng *float64) error {
+		if id == "" {
+			return nil
+		}
+		if strings.HasPrefix(id, savedPlacePrefix) {
+			p, err := lookupSavedPlace(ctx, riderID, id)
+			if errors.Is(err, errSavedPlaceNotFound) {
+				return fmt.Errorf("%w: %s", errUnknownPlace, id)
+			}
+			if err != nil {
+				return err
+			}
+			*lat, *lng = p.Lat, p.Lng
+			return nil
+		}
+		p, err := lookupPlace(ctx, id)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, id)
+		}
+		*lat, *lng = p.Lat, p.Lng
+		return nil
+	}
+	if err := resolve(req.PickupPlaceID, &req.PickupLat, &req.PickupLng); err != nil {
+		return err
+	}
+	return resolve(req.DropoffPlaceID, &req.DropoffLat, &req.DropoffLng)
+}
+
+// gazetteerSearcher matches the offline gazetteer. Autocomplete matches word
+// prefixes; search needs every word to appear. Places in the rider's city
+// come first, then the closest.
+type gazetteerSearcher struct{}
+
+func (gazetteerSearcher) Name() string { return "gazetteer" }
+
+func (gazetteerSearcher) RateLimit() int { return 0 }
+
+func (gazetteerSearcher) SearchPlaces(ctx context.Context, q PlaceQuery) ([]Place, error) {
+	words := strings.Fields(strings.ToLower(q.Text))
+	var city string
+	if q.Lat != nil {
+		if c := cityForLocation(*q.Lat, *q.Lng); c != nil {
+			city = c.Name
+		}
+	}
+
+	type match struct {
+		place  Place
+		inCity bool
+		dist   float64
+	}
+	var matches []match
+	for _, p := range gazetteer {
+		if !gazetteerMatch(p, words, q.Autocomplete) {
+			continue
+		}
+		m := match{place: p, inCity: p.City == city}
+		if q.Lat != nil {
+			m.dist = haversineKm(*q.Lat, *q.Lng, p.Lat, p.Lng)
+		}
+		matches = append(matches, m)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].inCity != matches[j].inCity {
+			return matches[i].inCity
+		}
+		return matches[i].dist < matches[j].dist
+	})
+
+	places := []Place{}
+	for _, m := range matches {
+		if len(places) == q.Limit {
+			break
+		}
+		places = append(places, m.place)
+	}
+	return places, nil
+}
+
+func gazetteerMatch(p Place, words []string, prefix bool) bool {
+	fields := strings.Fields(strings.ToLower(p.Name + " " + p.Address + " " + p.City))
+	for _, w := range words {
+		found := false
+		for _, f := range fields {
+			f = strings.Trim(f, ",.")
+			if f == w || (prefix && strings.HasPrefix(f, w)) || (!prefix && strings.Contains(f, w)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchPlaces on Nominatim is search only: its usage policy forbids
+// autocomplete, so autocomplete goes to the gazetteer.
+func (g nominatimGeocoder) SearchPlaces(ctx context.Context, q PlaceQuery) ([]Place, error) {
+	if q.Autocomplete && g.urlEnv == "" {
+		return gazetteerSearcher{}.SearchPlaces(ctx, q)
+	}
+	base := "https://nominatim.openstreetmap.org"
+	if g.urlEnv != "" {
+		base = os.Getenv(g.urlEnv)
+		if base == "" {
+			return nil, fmt.Errorf("%s not configured", g.urlEnv)
+		}
+	}
+	params := url.Values{
+		"q":      {q.Text},
+		"format": {"jsonv2"},
+		"limit":  {strconv.Itoa(q.Limit)},
+	}
+	if q.Lat != nil {
+		// Prefer, without restricting to, a box of about 20km around the rider.
+		params.Set("viewbox", fmt.Sprintf("%f,%f,%f,%f", *q.Lng-0.2, *q.Lat+0.2, *q.Lng+0.2, *q.Lat-0.2))
+	}
+
+	var results []struct {
+		OSMType     string `json:"osm_type"`
+		OSMID       int64  `json:"osm_id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+		Lat         string `json:"lat"`
+		Lon         string `json:"lon"`
+	}
+	if err := geocoderGet(ctx, strings.TrimRight(base, "/")+"/search?"+params.Encode(), &results); err != nil {
+		return nil, err
+	}
+
+	places := make([]Place, 0, len(results))
+	for _, res := range results {
+		lat, err1 := strconv.ParseFloat(res.Lat, 64)
+		lng, err2 := strconv.ParseFloat(res.Lon, 64)
+		if err1 != nil || err2 != nil || res.OSMType == "" {
+			continue
+		}
+		name := res.Name
+		if name == "" {
+			name, _, _ = strings.Cut(res.DisplayName, ",")
+		}
+		places = append(places, Place{
+			ID:      fmt.Sprintf("osm:%c%d", strings.ToUpper(res.OSMType)[0], res.OSMID),
+			Name:    name,
+			Address: res.DisplayName,
+			Lat:     lat,
+			Lng:     lng,
+		})
+	}
+	return places, nil
+}
+
+// SearchPlaces on Photon serves both search and autocomplete, with results
+// biased toward the rider's location.
+func (photonGeocoder) SearchPlaces(ctx context.Context, q PlaceQuery) ([]Place, error) {
+	base := os.Getenv("PHOTON_URL")
+	if base == "" {
+		return nil, errors.New("PHOTON_URL not configured")
+	}
+	params := url.Values{
+		"q":     {q.Text},
+		"limit": {strconv.Itoa(q.Limit)},
+	}
+	if q.Lat != nil {
+		params.Set("lat", fmt.Sprintf("%f", *q.Lat))
+		params.Set("lon", fmt.Sprintf("%f", *q.Lng))
+	}
+
+	var result struct {
+		Features []struct {
+			Geometry struct {
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties struct {
+				photonProperties
+				OSMType string `json:"osm_type"`
+				OSMID   int64  `json:"osm_id"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := geocoderGet(ctx, strings.TrimRight(base, "/")+"/api?"+params.Encode(), &result); err != nil {
+		return nil, err
+	}
+
+	places := make([]Place, 0, len(result.Features))
+	for _, f := range result.Features {
+		props := f.Properties
+		if len(f.Geometry.Coordinates) != 2 || props.OSMType == "" {
+			continue
+		}
+		name := props.Name
+		if name == "" {
+			name = strings.TrimSpace(props.HouseNumber + " " + props.Street)
+		}
+		places = append(places, Place{
+			ID:      fmt.Sprintf("osm:%s%d", props.OSMType, props.OSMID),
+			Name:    name,
+			Address: props.photonProperties.format(),
+			Lat:     f.Geometry.Coordinates[1],
+			Lng:     f.Geometry.Coordinates[0],
+			City:    props.City,
+		})
+	}
+	return places, nil
+}
+
+func (mockGeocoder) SearchPlaces(ctx context.Context, q PlaceQuery) ([]Place, error) {
+	return gazetteerSearcher{}.SearchPlaces(ctx, q)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestGazetteerSearch(t *testing.T) {
+	ctx := context.Background()
+	places, _ := gazetteerSearcher{}.SearchPlaces(ctx, PlaceQuery{Text: "Nakasero Market", Limit: 5})
+	if len(places) == 0 || places[0].ID != "gaz:nakasero-market" {
+		t.Fatalf("Expected Nakasero Market first, got %+v", places)
+	}
+
+	// Autocomplete matches word prefixes.
+	places, _ = gazetteerSearcher{}.SearchPlaces(ctx, PlaceQuery{Text: "acac", Limit: 5, Autocomplete: true})
+	if len(places) != 1 || places[0].ID != "gaz:acacia-mall" {
+		t.Errorf("Expected Acacia Mall, got %+v", places)
+	}
+	places, _ = gazetteerSearcher{}.SearchPlaces(ctx, PlaceQuery{Text: "cacia", Limit: 5, Autocomplete: true})
+	if len(places) != 0 {
+		t.Errorf("Expected autocomplete to match only word prefixes, got %+v", places)
+	}
+	places, _ = gazetteerSearcher{}.SearchPlaces(ctx, PlaceQuery{Text: "cacia", Limit: 5})
+	if len(places) != 1 {
+		t.Errorf("Expected search to match inside words, got %+v", places)
+	}
+}
+
+func TestGazetteerSearchBias(t *testing.T) {
+	ctx := context.Background()
+	// "airport" matches both airports; the rider's city comes first.
+	lat, lng := -1.2864, 36.8172
+	places, _ := gazetteerSearcher{}.SearchPlaces(ctx, PlaceQuery{Text: "airport", Lat: &lat, Lng: &lng, Limit: 5})
+	if len(places) != 2 || places[0].ID != "gaz:jkia" {
+		t.Errorf("Expected JKIA first from Nairobi, got %+v", places)
+	}
+
+	lat, lng = 0.3136, 32.5811
+	places, _ = gazetteerSearcher{}.SearchPlaces(ctx, PlaceQuery{Text: "airport", Lat: &lat, Lng: &lng, Limit: 1})
+	if len(places) != 1 || places[0].ID != "gaz:entebbe-airport" {
+		t.Errorf("Expected Entebbe airport first from Kampala, got %+v", places)
+	}
+}
+
+func TestParsePlaceQuery(t *testing.T) {
+	q, err := parsePlaceQuery(url.Values{"q": {"  Acacia   Mall "}, "lat": {"0.31"}, "lng": {"32.58"}, "limit": {"50"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Text != "Acacia Mall" || q.Lat == nil || *q.Lat != 0.31 || q.Limit != maxPlacesLimit || !q.Autocomplete {
+		t.Errorf("Unexpected query %+v", q)
+	}
+	for _, v := range []url.Values{
+		{},
+		{"q": {"mall"}, "lat": {"0.31"}},
+		{"q": {"mall"}, "limit": {"0"}},
+	} {
+		if _, err := parsePlaceQuery(v, false); err == nil {
+			t.Errorf("Expected %v to be rejected", v)
+		}
+	}
+}
+
+func TestPlacesCacheKey(t *testing.T) {
+	a, b := 0.3136, 32.5811
+	c, d := 0.3118, 32.5790
+	k1 := placesCacheKey("photon", PlaceQuery{Text: "Acacia Mall", Lat: &a, Lng: &b, Limit: 10})
+	k2 := placesCacheKey("photon", PlaceQuery{Text: "acacia mall", Lat: &c, Lng: &d, Limit: 10})
+	if k1 != k2 {
+		t.Errorf("Expected nearby riders to share a cache key, got %q and %q", k1, k2)
+	}
+	if k3 := placesCacheKey("photon", PlaceQuery{Text: "acacia mall", Limit: 10, Autocomplete: true}); k3 == k1 {
+		t.Errorf("Expected autocomplete and unbiased searches to use separate keys")
+	}
+}
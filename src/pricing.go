@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// FareQuote is the itemised output of the pricing step. Discounts are applied
+// here, before the fare is held on a wallet or sent to a payment provider.
+// Amounts are in the minor units of Currency.
+type FareQuote struct {
+	City            string  `json:"city,omitempty"`
+	VehicleType     string  `json:"vehicle_type,omitempty"`
+	Currency        string  `json:"currency"`
+	DistanceKm      float64 `json:"distance_km"`
+	DurationMin     float64 `json:"duration_min"`
+	BaseFare        Money   `json:"base_fare"`
+	DistanceFare    Money   `json:"distance_fare"`
+	TimeFare        Money   `json:"time_fare"`
+	SurgeMultiplier float64 `json:"surge_multiplier"`
+	SurgeAmount     Money   `json:"surge_amount"`
+	Subtotal        Money   `json:"subtotal"`
+	PromoCode       string  `json:"promo_code,omitempty"`
+	Discount        Money   `json:"discount"`
+	Total           Money   `json:"total"`
+	PerKm           Money   `json:"per_km"`
+	PerMin          Money   `json:"per_min"`
+	WaitPerMin      Money   `json:"wait_per_min"` // charged after the free waiting window
+}
+
+// averageTripSpeedKmh estimates trip duration for upfront quotes.
+const averageTripSpeedKmh = 20.0
+
+// surgeMultiplier applies rush-hour pricing.
+func surgeMultiplier(now time.Time) float64 {
+	hour := now.Hour()
+	if (hour >= 7 && hour <= 9) || (hour >= 17 && hour <= 19) {
+		return 1.2 // 20% surge
+	}
+	return 1.0
+}
+
+// buildFareQuote prices a trip on the city's rate card, estimating its
+// duration from the distance.
+func buildFareQuote(city *City, distanceKm float64, now time.Time) *FareQuote {
+	cur := currencyFor(city.Currency)
+	q := &FareQuote{
+		City:            city.Code,
+		Currency:        cur.Code,
+		BaseFare:        cur.RoundFare(city.BaseFare),
+		SurgeMultiplier: surgeMultiplier(now),
+		PerKm:           city.PerKm,
+		PerMin:          city.PerMin,
+		WaitPerMin:      city.WaitPerMin,
+	}
+	q.price(distanceKm, distanceKm/averageTripSpeedKmh*60)
+	return q
+}
+
+// price fills in the distance, time and surge lines for a trip on q's rates.
+// Each line is rounded to the currency's step so the itemised lines add up to
+// the total; any discount already on q is kept, up to the new subtotal.
+func (q *FareQuote) price(distanceKm, durationMin float64) {
+	cur := currencyFor(q.Currency)
+	q.DistanceKm = math.Round(distanceKm*100) / 100
+	q.DurationMin = math.Round(durationMin*10) / 10
+	q.DistanceFare = cur.RoundFare(q.PerKm.scale(distanceKm))
+	q.TimeFare = cur.RoundFare(q.PerMin.scale(durationMin))
+	fare := q.BaseFare + q.DistanceFare + q.TimeFare
+	q.SurgeAmount = cur.RoundFare(fare.scale(q.SurgeMultiplier - 1))
+	q.Subtotal = fare + q.SurgeAmount
+	q.Discount = min(q.Discount, q.Subtotal)
+	q.Total = q.Subtotal - q.Discount
+}
+
+// tripDistanceKm is the straight-line pickup to dropoff distance, falling
+// back to fallbackKm when the request has no dropoff.
+func tripDistanceKm(req RideRequest, fallbackKm float64) float64 {
+	if req.DropoffLat == 0 && req.DropoffLng == 0 {
+		return fallbackKm
+	}
+	return haversineKm(req.PickupLat, req.PickupLng, req.DropoffLat, req.DropoffLng)
+}
+
+// priceRide runs the pricing step for a ride request and applies its promo
+// code, if any. Passing a transaction locks the promo row so the returned
+// discount stays valid until the redemption is recorded in the same tx;
+// without one the result is an informational quote.
+func priceRide(ctx context.Context, tx pgx.Tx, riderID int, req RideRequest, distanceKm float64) (*FareQuote, *PromoCode, error) {
+	quote := buildFareQuote(ratesForLocation(req.PickupLat, req.PickupLng), distanceKm, time.Now())
+	quote.VehicleType = req.VehicleType
+
+	if req.PromoCode == "" {
+		return quote, nil, nil
+	}
+
+	var q queryRower = dbPool
+	if tx != nil {
+		q = tx
+	}
+	promo, err := loadPromo(ctx, q, req.PromoCode, tx != nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkPromoEligibility(ctx, q, promo, riderID, quote, time.Now()); err != nil {
+		return nil, nil, err
+	}
+
+	quote.PromoCode = promo.Code
+	quote.Discount = promo.discountFor(quote.Subtotal, currencyFor(quote.Currency))
+	quote.Total = quote.Subtotal - quote.Discount
+	return quote, promo, nil
+}
+
+func quoteRideHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req RideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request format"))
+		return
+	}
+	if err := resolveRidePlaces(r.Context(), claims.UserID, &req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if !validCoordinates(req.PickupLat, req.PickupLng) || !validCoordinates(req.DropoffLat, req.DropoffLng) {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid coordinates"))
+		return
+	}
+	if req.DropoffLat == 0 && req.DropoffLng == 0 {
+		respondJSON(w, http.StatusBadRequest, errorResponse("dropoff_lat and dropoff_lng are required for a quote"))
+		return
+	}
+	req.PromoCode = strings.TrimSpace(req.PromoCode)
+
+	quote, _, err := priceRide(r.Context(), nil, claims.UserID, req, tripDistanceKm(req, 0))
+	if errors.Is(err, errInvalidPromo) {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(quote))
+}
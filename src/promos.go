@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type PromoCode struct {
+	Code          string     `json:"code"`
+	DiscountType  string     `json:"discount_type"`  // "percent" or "fixed"
+	DiscountValue float64    `json:"discount_value"` // percent, or minor units when fixed
+	MaxDiscount   Money      `json:"max_discount,omitempty"`
+	Currency      string     `json:"currency,omitempty"` // required for fixed amounts and caps
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	MaxUses       *int       `json:"max_uses,omitempty"`
+	PerUserLimit  int        `json:"per_user_limit"`
+	Uses          int        `json:"uses"`
+	City          string     `json:"city,omitempty"`
+	VehicleClass  string     `json:"vehicle_class,omitempty"`
+	Active        bool       `json:"active"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+const (
+	promoDiscountPercent = "percent"
+	promoDiscountFixed   = "fixed"
+)
+
+var errInvalidPromo = errors.New("invalid promo code")
+
+// queryRower is satisfied by both the connection pool and a transaction.
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// discountFor returns the discount this code gives on subtotal, capped by
+// MaxDiscount and never more than the subtotal itself. Percentage discounts
+// are rounded to the currency's fare step.
+func (p *PromoCode) discountFor(subtotal Money, cur Currency) Money {
+	var discount Money
+	switch p.DiscountType {
+	case promoDiscountPercent:
+		discount = cur.RoundFare(subtotal.scale(p.DiscountValue / 100))
+	case promoDiscountFixed:
+		discount = Money(p.DiscountValue)
+	}
+	if p.MaxDiscount > 0 {
+		discount = min(discount, p.MaxDiscount)
+	}
+	return min(discount, subtotal)
+}
+
+// validFor checks the code's own restrictions against a quote.
+func (p *PromoCode) validFor(quote *FareQuote, now time.Time) error {
+	switch {
+	case !p.Active:
+		return fmt.Errorf("%w: code is not active", errInvalidPromo)
+	case p.ExpiresAt != nil && now.After(*p.ExpiresAt):
+		return fmt.Errorf("%w: code has expired", errInvalidPromo)
+	case p.MaxUses != nil && p.Uses >= *p.MaxUses:
+		return fmt.Errorf("%w: code has been fully redeemed", errInvalidPromo)
+	case p.Currency != "" && p.Currency != quote.Currency:
+		return fmt.Errorf("%w: code is not valid in %s", errInvalidPromo, quote.Currency)
+	case p.City != "" && p.City != quote.City:
+		return fmt.Errorf("%w: code is not valid in this city", errInvalidPromo)
+	case p.VehicleClass != "" && !strings.EqualFold(p.VehicleClass, quote.VehicleType):
+		return fmt.Errorf("%w: code is only valid for %s rides", errInvalidPromo, p.VehicleClass)
+	}
+	return nil
+}
+
+func loadPromo(ctx context.Context, q queryRower, code string, forUpdate bool) (*PromoCode, error) {
+	query := `SELECT code, discount_type, discount_value, COALESCE(max_discount, 0), COALESCE(currency, ''), expires_at,
+	                 max_uses, per_user_limit, uses, COALESCE(city, ''), COALESCE(vehicle_class, ''),
+	                 active, created_at
+	          FROM promo_codes WHERE code = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	var p PromoCode
+	err := q.QueryRow(ctx, query, normalizePromoCode(code)).Scan(
+		&p.Code, &p.DiscountType, &p.DiscountValue, &p.MaxDiscount, &p.Currency, &p.ExpiresAt,
+		&p.MaxUses, &p.PerUserLimit, &p.Uses, &p.City, &p.VehicleClass,
+		&p.Active, &p.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: code not found", errInvalidPromo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load promo code: %w", err)
+	}
+	return &p, nil
+}
+
+// checkPromoEligibility applies the code's restrictions and the rider's
+// personal usage limit.
+func checkPromoEligibility(ctx context.Context, q queryRower, p *PromoCode, riderID int, quote *FareQuote, now time.Time) error {
+	if err := p.validFor(quote, now); err != nil {
+		return err
+	}
+
+	var used int
+	if err := q.QueryRow(ctx,
+		`SELECT COUNT(*) FROM promo_redemptions WHERE code = $1 AND rider_id = $2`,
+		p.Code, riderID).Scan(&used); err != nil {
+		return fmt.Errorf("failed to count promo redemptions: %w", err)
+	}
+	if used >= p.PerUserLimit {
+		return fmt.Errorf("%w: you have already used this code", errInvalidPromo)
+	}
+	return nil
+}
+
+// recordPromoRedemption consumes one use of a promo locked by priceRide and
+// books the discount against the platform in the ledger.
+func recordPromoRedemption(ctx context.Context, tx pgx.Tx, p *PromoCode, riderID int, rideID string, discount Money, currency string) error {
+	if _, err := tx.Exec(ctx,
+		`UPDATE promo_codes SET uses = uses + 1 WHERE code = $1`,
+		p.Code); err != nil {
+		return fmt.Errorf("failed to update promo usage: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO promo_redemptions (code, rider_id, ride_id, discount, currency)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		p.Code, riderID, rideID, discount, currency); err != nil {
+		return fmt.Errorf("failed to record promo redemption: %w", err)
+	}
+
+	return recordLedgerEntry(ctx, tx, platformAccount, "promo_discount", rideID, -discount, currency, p.Code)
+}
+
+// releasePromoRedemption gives back the promo use consumed by a ride that
+// was cancelled, so the rider can use the code again.
+func releasePromoRedemption(ctx context.Context, tx pgx.Tx, rideID string) error {
+	var code, currency string
+	var discount Money
+	err := tx.QueryRow(ctx,
+		`DELETE FROM promo_redemptions WHERE ride_id = $1
+		 RETURNING code, discount, currency`,
+		rideID).Scan(&code, &discount, &currency)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release promo redemption: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE promo_codes SET uses = uses - 1 WHERE code = $1 AND uses > 0`,
+		code); err != nil {
+		return fmt.Errorf("failed to update promo usage: %w", err)
+	}
+	return recordLedgerEntry(ctx, tx, platformAccount, "promo_discount_reversal", rideID, discount, currency, code)
+}
+
+func createPromoHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	var p PromoCode
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	p.Code = normalizePromoCode(p.Code)
+	if p.PerUserLimit == 0 {
+		p.PerUserLimit = 1
+	}
+
+	switch {
+	case p.Code == "":
+		respondJSON(w, http.StatusBadRequest, errorResponse("code is required"))
+		return
+	case p.DiscountType != promoDiscountPercent && p.DiscountType != promoDiscountFixed:
+		respondJSON(w, http.StatusBadRequest, errorResponse("discount_type must be percent or fixed"))
+		return
+	case p.DiscountValue <= 0 || (p.DiscountType == promoDiscountPercent && p.DiscountValue > 100):
+		respondJSON(w, http.StatusBadRequest, errorResponse("invalid discount_value"))
+		return
+	case p.DiscountType == promoDiscountFixed && p.DiscountValue != math.Trunc(p.DiscountValue):
+		respondJSON(w, http.StatusBadRequest, errorResponse("fixed discount_value must be in whole minor units"))
+		return
+	case p.MaxUses != nil && *p.MaxUses <= 0, p.PerUserLimit < 0:
+		respondJSON(w, http.StatusBadRequest, errorResponse("usage limits must be positive"))
+		return
+	}
+
+	// Amounts only make sense in one currency, so fixed codes and caps are
+	// tied to one (the default unless given); pure percentages work anywhere.
+	if p.Currency != "" || p.DiscountType == promoDiscountFixed || p.MaxDiscount > 0 {
+		cur, err := lookupCurrency(p.Currency)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+			return
+		}
+		p.Currency = cur.Code
+	}
+
+	err := dbPool.QueryRow(r.Context(),
+		`INSERT INTO promo_codes (code, discount_type, discount_value, max_discount, currency, expires_at,
+		                          max_uses, per_user_limit, city, vehicle_class, created_by)
+		 VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''), $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11)
+		 RETURNING active, created_at`,
+		p.Code, p.DiscountType, p.DiscountValue, p.MaxDiscount, p.Currency, p.ExpiresAt,
+		p.MaxUses, p.PerUserLimit, p.City, p.VehicleClass, claims.UserID).Scan(&p.Active, &p.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to create promo %s: %v", p.Code, err)
+		respondJSON(w, http.StatusConflict, errorResponse("could not create promo code"))
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, successResponse(p))
+}
+
+func listPromosHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT code, discount_type, discount_value, COALESCE(max_discount, 0), COALESCE(currency, ''), expires_at,
+		        max_uses, per_user_limit, uses, COALESCE(city, ''), COALESCE(vehicle_class, ''),
+		        active, created_at
+		 FROM promo_codes
+		 ORDER BY created_at DESC`)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	defer rows.Close()
+
+	promos := []PromoCode{}
+	for rows.Next() {
+		var p PromoCode
+		if err := rows.Scan(&p.Code, &p.DiscountType, &p.DiscountValue, &p.MaxDiscount, &p.Currency, &p.ExpiresAt,
+			&p.MaxUses, &p.PerUserLimit, &p.Uses, &p.City, &p.VehicleClass,
+			&p.Active, &p.CreatedAt); err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+			return
+		}
+		promos = append(promos, p)
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(promos))
+}
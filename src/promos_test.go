@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPromoDiscountFor(t *testing.T) {
+	ugx := currencies["UGX"]
+	percent := &PromoCode{DiscountType: promoDiscountPercent, DiscountValue: 20, MaxDiscount: 3000}
+	if got := percent.discountFor(10000, ugx); got != 2000 {
+		t.Errorf("Expected 2000, got %v", got)
+	}
+	if got := percent.discountFor(50000, ugx); got != 3000 {
+		t.Errorf("Expected discount capped at 3000, got %v", got)
+	}
+
+	fixed := &PromoCode{DiscountType: promoDiscountFixed, DiscountValue: 5000}
+	if got := fixed.discountFor(4000, ugx); got != 4000 {
+		t.Errorf("Fixed discount should not exceed subtotal, got %v", got)
+	}
+}
+
+func TestPromoValidFor(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Hour)
+	one := 1
+	quote := &FareQuote{City: "kampala", VehicleType: "boda", Currency: "UGX"}
+
+	cases := []struct {
+		name  string
+		promo PromoCode
+		valid bool
+	}{
+		{"active", PromoCode{Active: true}, true},
+		{"inactive", PromoCode{Active: false}, false},
+		{"expired", PromoCode{Active: true, ExpiresAt: &expired}, false},
+		{"exhausted", PromoCode{Active: true, MaxUses: &one, Uses: 1}, false},
+		{"other city", PromoCode{Active: true, City: "entebbe"}, false},
+		{"other currency", PromoCode{Active: true, Currency: "KES"}, false},
+		{"vehicle class", PromoCode{Active: true, VehicleClass: "BODA"}, true},
+		{"other vehicle class", PromoCode{Active: true, VehicleClass: "car"}, false},
+	}
+	for _, tc := range cases {
+		err := tc.promo.validFor(quote, now)
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if !tc.valid && !errors.Is(err, errInvalidPromo) {
+			t.Errorf("%s: expected errInvalidPromo, got %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Rating is one side's rating of the other after a completed ride.
+// RatedBy is "rider" when the rider rated the driver, "driver" otherwise.
+type Rating struct {
+	ID        int64     `json:"id"`
+	RideID    string    `json:"ride_id"`
+	RatedBy   string    `json:"rated_by"`
+	DriverID  string    `json:"driver_id"`
+	RiderID   int       `json:"rider_id"`
+	Stars     int       `json:"stars"`
+	Tags      []string  `json:"tags"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RatingSummary is what a driver or rider sees of their own ratings. Recent
+// ratings are shown without who gave them.
+type RatingSummary struct {
+	Rating  *float64       `json:"rating"` // rolling average; nil until first rated
+	Ratings int            `json:"ratings"`
+	Window  int            `json:"window"`
+	Tags    map[string]int `json:"tags"`
+	Recent  []RecentRating `json:"recent"`
+}
+
+type RecentRating struct {
+	Stars     int       `json:"stars"`
+	Tags      []string  `json:"tags"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RatingReview is a low rating, or a low rolling average, queued for ops to
+// look at.
+type RatingReview struct {
+	ID          int64      `json:"id"`
+	SubjectType string     `json:"subject_type"` // driver or rider
+	SubjectID   string     `json:"subject_id"`
+	Reason      string     `json:"reason"` // low_rating or low_average
+	Rating      *Rating    `json:"rating,omitempty"`
+	Average     float64    `json:"average"`
+	Status      string     `json:"status"` // open, resolved or dismissed
+	Note        string     `json:"note,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ClosedAt    *time.Time `json:"closed_at,omitempty"`
+}
+
+const (
+	ratedByRider  = "rider"
+	ratedByDriver = "driver"
+
+	reviewLowRating  = "low_rating"
+	reviewLowAverage = "low_average"
+
+	lowRatingStars          = 2
+	maxRatingComment        = 500
+	maxRatingTags           = 5
+	recentRatingsShown      = 10
+	defaultRatingWindow     = 100
+	defaultReviewThreshold  = 4.3
+	defaultReviewMinRatings = 10
+	defaultRatingDeadline   = 7 * 24 * time.Hour
+)
+
+// ratingTags lists the tags each side may give. Riders tag their driver and
+// drivers tag their rider.
+var ratingTags = map[string]map[string]bool{
+	ratedByRider: {
+		"safe_driving": true, "clean_car": true, "friendly": true, "good_navigation": true, "on_time": true,
+		"unsafe_driving": true, "dirty_car": true, "rude": true, "wrong_route": true, "late": true,
+	},
+	ratedByDriver: {
+		"polite": true, "on_time": true, "respectful": true, "clear_pickup": true,
+		"rude": true, "late": true, "messy": true, "unsafe_behaviour": true, "wrong_pickup": true,
+	},
+}
+
+var (
+	errAlreadyRated     = errors.New("you have already rated this ride")
+	errRatingNotAllowed = errors.New("only completed rides can be rated, within the rating window")
+	errNotRideMember    = errors.New("only the ride's rider and driver can rate it")
+)
+
+type ratingRequest struct {
+	Stars   int      `json:"stars"`
+	Tags    []string `json:"tags"`
+	Comment string   `json:"comment"`
+}
+
+// normalize validates a rating given by ratedBy and returns its tags sorted
+// and deduplicated.
+func (req *ratingRequest) normalize(ratedBy string) error {
+	if req.Stars < 1 || req.Stars > 5 {
+		return errors.New("stars must be between 1 and 5")
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if len(req.Comment) > maxRatingComment {
+		return fmt.Errorf("comment must be at most %d characters", maxRatingComment)
+	}
+
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, tag := range req.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !ratingTags[ratedBy][tag] {
+			return fmt.Errorf("unknown tag: %s", tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxRatingTags {
+		return fmt.Errorf("at most %d tags", maxRatingTags)
+	}
+	sort.Strings(tags)
+	req.Tags = tags
+	return nil
+}
+
+// rollingAverage is the mean of the given stars to two decimal places.
+func rollingAverage(stars []int) float64 {
+	if len(stars) == 0 {
+		return 0
+	}
+	total := 0
+	for _, s := range stars {
+		total += s
+	}
+	return math.Round(float64(total)/float64(len(stars))*100) / 100
+}
+
+// reviewReasons says why a new rating should be reviewed: the rating itself
+// is low, or it takes an established average below the threshold.
+func reviewReasons(stars int, average float64, ratings int, threshold float64, minRatings int) []string {
+	var reasons []string
+	if stars <= lowRatingStars {
+		reasons = append(reasons, reviewLowRating)
+	}
+	if ratings >= minRatings && average < threshold {
+		reasons = append(reasons, reviewLowAverage)
+	}
+	return reasons
+}
+
+func rateRideHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req ratingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+
+	rating, err := rateRide(r.Context(), mux.Vars(r)["id"], claims, req)
+	var validationErr ratingValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+	case errors.Is(err, errNotRideMember):
+		respondJSON(w, http.StatusForbidden, errorResponse(err.Error()))
+	case errors.Is(err, errAlreadyRated), errors.Is(err, errRatingNotAllowed):
+		respondJSON(w, http.StatusConflict, errorResponse(err.Error()))
+	case err != nil:
+		respondRideError(w, err)
+	default:
+		respondJSON(w, http.StatusCreated, successResponse(rating))
+	}
+}
+
+type ratingValidationError struct{ error }
+
+// rateRide records a rating and writes the new rolling average back to the
+// rated driver or rider, queueing a review if it is low.
+func rateRide(ctx context.Context, rideID string, claims *Claims, req ratingRequest) (*Rating, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	rating := &Rating{RideID: ride.ID, DriverID: ride.DriverID, RiderID: ride.RiderID}
+	switch {
+	case claims.Role == "driver" && claims.Username == ride.DriverID:
+		rating.RatedBy = ratedByDriver
+	case claims.Role != "driver" && claims.UserID == ride.RiderID:
+		rating.RatedBy = ratedByRider
+	default:
+		return nil, errNotRideMember
+	}
+	deadline := envDuration("RATING_DEADLINE", defaultRatingDeadline)
+	if ride.Status != rideStatusCompleted || ride.CompletedAt == nil || time.Since(*ride.CompletedAt) > deadline {
+		return nil, errRatingNotAllowed
+	}
+	if err := req.normalize(rating.RatedBy); err != nil {
+		return nil, ratingValidationError{err}
+	}
+	rating.Stars, rating.Tags, rating.Comment = req.Stars, req.Tags, req.Comment
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO ratings (ride_id, rated_by, driver_id, rider_id, stars, tags, comment)
+		 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+		 RETURNING id, created_at`,
+		rating.RideID, rating.RatedBy, rating.DriverID, rating.RiderID, rating.Stars, rating.Tags, rating.Comment).
+		Scan(&rating.ID, &rating.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, errAlreadyRated
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save rating: %w", err)
+	}
+
+	if err := updateRatingProfile(ctx, tx, rating); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return rating, nil
+}
+
+// updateRatingProfile recomputes the rated party's rolling average over their
+// last RATING_WINDOW ratings and queues any review it calls for.
+func updateRatingProfile(ctx context.Context, tx pgx.Tx, rating *Rating) error {
+	window := envInt("RATING_WINDOW", defaultRatingWindow)
+
+	subjectType, subjectID := "driver", rating.DriverID
+	subjectFilter := `driver_id = $1 AND rated_by = 'rider'`
+	var subject any = rating.DriverID
+	if rating.RatedBy == ratedByDriver {
+		subjectType, subjectID = "rider", strconv.Itoa(rating.RiderID)
+		subjectFilter = `rider_id = $1 AND rated_by = 'driver'`
+		subject = rating.RiderID
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT stars FROM ratings WHERE `+subjectFilter+` ORDER BY created_at DESC LIMIT $2`,
+		subject, window)
+	if err != nil {
+		return fmt.Errorf("failed to load ratings: %w", err)
+	}
+	stars, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return fmt.Errorf("failed to load ratings: %w", err)
+	}
+	average := rollingAverage(stars)
+
+	var total int
+	if rating.RatedBy == ratedByRider {
+		err = tx.QueryRow(ctx,
+			`UPDATE drivers SET rating = $1, rating_count = rating_count + 1 WHERE driver_id = $2
+			 RETURNING rating_count`,
+			average, rating.DriverID).Scan(&total)
+	} else {
+		err = tx.QueryRow(ctx,
+			`INSERT INTO rider_profiles (rider_id, rating, rating_count) VALUES ($1, $2, 1)
+			 ON CONFLICT (rider_id) DO UPDATE
+			 SET rating = EXCLUDED.rating, rating_count = rider_profiles.rating_count + 1, updated_at = NOW()
+			 RETURNING rating_count`,
+			rating.RiderID, average).Scan(&total)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s rating: %w", subjectType, err)
+	}
+
+	reasons := reviewReasons(rating.Stars, average, total,
+		envFloat("RATING_REVIEW_THRESHOLD", defaultReviewThreshold),
+		envInt("RATING_REVIEW_MIN_RATINGS", defaultReviewMinRatings))
+	for _, reason := range reasons {
+		// One open low_average review per subject; the unique index turns
+		// repeats into no-ops.
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO rating_reviews (subject_type, subject_id, reason, rating_id, average)
+			 VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT DO NOTHING`,
+			subjectType, subjectID, reason, rating.ID, average)
+		if err != nil {
+			return fmt.Errorf("failed to queue rating review: %w", err)
+		}
+		if tag.RowsAffected() > 0 {
+			log.Printf("Queued %s review of %s %s (average %.2f)", reason, subjectType, subjectID, average)
+		}
+	}
+	return nil
+}
+
+// riderRating is the rider's rolling average, or nil for riders not yet
+// rated. It is shown to drivers with ride offers.
+func riderRating(ctx context.Context, q queryRower, riderID int) *float64 {
+	var rating *float64
+	if err := q.QueryRow(ctx,
+		`SELECT rating FROM rider_profiles WHERE rider_id = $1`,
+		riderID).Scan(&rating); err != nil {
+		return nil
+	}
+	return rating
+}
+
+// loadRatingSummary summarises the ratings given to a driver or a rider,
+// selected by filter on $1, over the last window ratings.
+func loadRatingSummary(ctx context.Context, filter string, subject any) (*RatingSummary, error) {
+	window := envInt("RATING_WINDOW", defaultRatingWindow)
+	summary := &RatingSummary{Window: window, Tags: map[string]int{}, Recent: []RecentRating{}}
+
+	rows, err := dbPool.Query(ctx,
+		`SELECT stars, tags, COALESCE(comment, ''), created_at
+		 FROM ratings WHERE `+filter+`
+		 ORDER BY created_at DESC LIMIT $2`,
+		subject, window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ratings: %w", err)
+	}
+	ratings, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (RecentRating, error) {
+		var r RecentRating
+		err := row.Scan(&r.Stars, &r.Tags, &r.Comment, &r.CreatedAt)
+		return r, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ratings: %w", err)
+	}
+
+	stars := make([]int, len(ratings))
+	for i, r := range ratings {
+		stars[i] = r.Stars
+		for _, tag := range r.Tags {
+			summary.Tags[tag]++
+		}
+	}
+	if len(ratings) > 0 {
+		avg := rollingAverage(stars)
+		summary.Rating = &avg
+	}
+	summary.Recent = append(summary.Recent, ratings[:min(len(ratings), recentRatingsShown)]...)
+
+	if err := dbPool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM ratings WHERE `+filter,
+		subject).Scan(&summary.Ratings); err != nil {
+		return nil, fmt.Errorf("failed to count ratings: %w", err)
+	}
+	return summary, nil
+}
+
+func driverRatingHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	summary, err := loadRatingSummary(r.Context(), `driver_id = $1 AND rated_by = 'rider'`, claims.Username)
+	if err != nil {
+		log.Printf("Failed to load ratings for driver %s: %v", claims.Username, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(summary))
+}
+
+func riderRatingHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	summary, err := loadRatingSummary(r.Context(), `rider_id = $1 AND rated_by = 'driver'`, claims.UserID)
+	if err != nil {
+		log.Printf("Failed to load ratings for rider %d: %v", claims.UserID, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(summary))
+}
+
+func listRatingReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok || claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT rv.id, rv.subject_type, rv.subject_id, rv.reason, rv.average, rv.status, COALESCE(rv.note, ''),
+		        rv.created_at, rv.closed_at,
+		        rt.id, rt.ride_id, rt.rated_by, rt.driver_id, rt.rider_id, rt.stars, rt.tags,
+		        COALESCE(rt.comment, ''), rt.created_at
+		 FROM rating_reviews rv
+		 JOIN ratings rt ON rt.id = rv.rating_id
+		 WHERE rv.status = $1
+		 ORDER BY rv.created_at
+		 LIMIT 100`,
+		status)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	reviews, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (RatingReview, error) {
+		rv := RatingReview{Rating: &Rating{}}
+		rt := rv.Rating
+		err := row.Scan(&rv.ID, &rv.SubjectType, &rv.SubjectID, &rv.Reason, &rv.Average, &rv.Status, &rv.Note,
+			&rv.CreatedAt, &rv.ClosedAt,
+			&rt.ID, &rt.RideID, &rt.RatedBy, &rt.DriverID, &rt.RiderID, &rt.Stars, &rt.Tags,
+			&rt.Comment, &rt.CreatedAt)
+		return rv, err
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+		return
+	}
+	if reviews == nil {
+		reviews = []RatingReview{}
+	}
+	respondJSON(w, http.StatusOK, successResponse(reviews))
+}
+
+// updateRatingReviewHandler closes a review as resolved or dismissed.
+func updateRatingReviewHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok || claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, errorResponse("review not found"))
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	if req.Status != "resolved" && req.Status != "dismissed" {
+		respondJSON(w, http.StatusBadRequest, errorResponse("status must be resolved or dismissed"))
+		return
+	}
+
+	tag, err := dbPool.Exec(r.Context(),
+		`UPDATE rating_reviews
+		 SET status = $1, note = NULLIF($2, ''), closed_by = $3, closed_at = NOW()
+		 WHERE id = $4 AND status = 'open'`,
+		req.Status, strings.TrimSpace(req.Note), claims.UserID, id)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		respondJSON(w, http.StatusNotFound, errorResponse("open review not found"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{"id": id, "status": req.Status}))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRatingRequestNormalize(t *testing.T) {
+	req := ratingRequest{Stars: 4, Tags: []string{"Clean_Car ", "safe_driving", "clean_car"}, Comment: "  Smooth ride  "}
+	if err := req.normalize(ratedByRider); err != nil {
+		t.Fatalf("Expected a valid rating, got %v", err)
+	}
+	if !reflect.DeepEqual(req.Tags, []string{"clean_car", "safe_driving"}) || req.Comment != "Smooth ride" {
+		t.Errorf("Expected sorted, deduplicated tags and a trimmed comment, got %+v", req)
+	}
+
+	tests := []struct {
+		name    string
+		ratedBy string
+		req     ratingRequest
+	}{
+		{"no stars", ratedByRider, ratingRequest{}},
+		{"too many stars", ratedByRider, ratingRequest{Stars: 6}},
+		{"driver tag from rider", ratedByRider, ratingRequest{Stars: 3, Tags: []string{"messy"}}},
+		{"rider tag from driver", ratedByDriver, ratingRequest{Stars: 3, Tags: []string{"clean_car"}}},
+		{"long comment", ratedByDriver, ratingRequest{Stars: 3, Comment: strings.Repeat("x", maxRatingComment+1)}},
+		{"too many tags", ratedByRider, ratingRequest{Stars: 1, Tags: []string{"unsafe_driving", "dirty_car", "rude", "wrong_route", "late", "on_time"}}},
+	}
+	for _, tt := range tests {
+		if err := tt.req.normalize(tt.ratedBy); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestRollingAverage(t *testing.T) {
+	if got := rollingAverage(nil); got != 0 {
+		t.Errorf("Expected 0 for no ratings, got %v", got)
+	}
+	if got := rollingAverage([]int{5, 4, 4}); got != 4.33 {
+		t.Errorf("Expected 4.33, got %v", got)
+	}
+}
+
+func TestReviewReasons(t *testing.T) {
+	tests := []struct {
+		name    string
+		stars   int
+		average float64
+		ratings int
+		want    []string
+	}{
+		{"good rating", 5, 4.8, 40, nil},
+		{"low rating", 2, 4.7, 40, []string{reviewLowRating}},
+		{"low average", 4, 4.1, 40, []string{reviewLowAverage}},
+		{"low average too early", 3, 3.5, 4, nil},
+		{"both", 1, 3.9, 12, []string{reviewLowRating, reviewLowAverage}},
+	}
+	for _, tt := range tests {
+		got := reviewReasons(tt.stars, tt.average, tt.ratings, defaultReviewThreshold, defaultReviewMinRatings)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// Receipt is the itemised record of what a rider paid for a completed ride.
+// Every issue is stored as a new version so earlier copies can be re-issued.
+type Receipt struct {
+	ReceiptNumber string     `json:"receipt_number"`
+	RideID        string     `json:"ride_id"`
+	Version       int        `json:"version"`
+	IssuedAt      time.Time  `json:"issued_at"`
+	RiderID       int        `json:"rider_id"`
+	DriverID      string     `json:"driver_id"`
+	DriverName    string     `json:"driver_name,omitempty"`
+	Vehicle       string     `json:"vehicle,omitempty"`
+	RequestedAt   time.Time  `json:"requested_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	DistanceKm    float64    `json:"distance_km"`
+	BaseFare      Money      `json:"base_fare"`
+	DistanceFare  Money      `json:"distance_fare"`
+	TimeFare      Money      `json:"time_fare"`
+	Surge         Money      `json:"surge"`
+	Waiting       Money      `json:"waiting"`
+	PromoCode     string     `json:"promo_code,omitempty"`
+	Discount      Money      `json:"discount"`
+	Adjustments   Money      `json:"adjustments"`
+	Fare          Money      `json:"fare"`
+	Tip           Money      `json:"tip"`
+	TaxRate       float64    `json:"tax_rate"`
+	Tax           Money      `json:"tax"` // included in Total
+	Total         Money      `json:"total"`
+	Currency      string     `json:"currency"`
+	PaymentMethod string     `json:"payment_method"`
+}
+
+var errReceiptNotFound = errors.New("receipt not found")
+
+// buildReceipt assembles a receipt from the ride's stored fare breakdown
+// (metered or upfront, whichever the rider paid), its final fare and any
+// completed tip. Differences between that breakdown's total and the final
+// fare (e.g. admin adjustments) are shown as adjustments.
+func buildReceipt(ctx context.Context, tx pgx.Tx, rideID string) (*Receipt, error) {
+	rc := &Receipt{RideID: rideID}
+	var breakdown []byte
+	err := tx.QueryRow(ctx,
+		`SELECT r.rider_id, r.driver_id, COALESCE(d.name, ''), `+vehicleLabelSQL+`,
+		        r.created_at, r.completed_at, r.currency, COALESCE(r.final_fare, r.price_estimate, 0),
+		        r.discount_amount, COALESCE(r.promo_code, ''), r.payment_method,
+		        CASE WHEN r.fare_basis = 'metered' THEN r.metered_breakdown ELSE r.fare_breakdown END,
+		        r.waiting_charge
+		 FROM rides r
+		 LEFT JOIN drivers d ON d.driver_id = r.driver_id
+		 LEFT JOIN vehicles v ON v.id = r.vehicle_id
+		 WHERE r.id = $1`,
+		rideID).Scan(&rc.RiderID, &rc.DriverID, &rc.DriverName, &rc.Vehicle,
+		&rc.RequestedAt, &rc.CompletedAt, &rc.Currency, &rc.Fare,
+		&rc.Discount, &rc.PromoCode, &rc.PaymentMethod, &breakdown,
+		&rc.Waiting)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errRideNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ride for receipt: %w", err)
+	}
+
+	quote := FareQuote{BaseFare: rc.Fare + rc.Discount, Total: rc.Fare}
+	if len(breakdown) > 0 {
+		if err := json.Unmarshal(breakdown, &quote); err != nil {
+			return nil, fmt.Errorf("invalid fare breakdown: %w", err)
+		}
+	}
+	rc.DistanceKm = quote.DistanceKm
+	rc.BaseFare = quote.BaseFare
+	rc.DistanceFare = quote.DistanceFare
+	rc.TimeFare = quote.TimeFare
+	rc.Surge = quote.SurgeAmount
+	rc.Adjustments = rc.Fare - quote.Total - rc.Waiting
+
+	if err := tx.QueryRow(ctx,
+		`SELECT COALESCE(SUM(amount), 0)::bigint FROM tips WHERE ride_id = $1 AND status = 'completed'`,
+		rideID).Scan(&rc.Tip); err != nil {
+		return nil, fmt.Errorf("failed to load tip: %w", err)
+	}
+
+	rc.Total = rc.Fare + rc.Tip
+	rc.TaxRate = envFloat("TAX_RATE", 0)
+	if rc.TaxRate > 0 {
+		rc.Tax = rc.Total.scale(rc.TaxRate / (1 + rc.TaxRate))
+	}
+	return rc, nil
+}
+
+// issueReceipt builds and stores the next version of a ride's receipt. It is
+// called whenever a completed ride's charges change.
+func issueReceipt(ctx context.Context, tx pgx.Tx, rideID string) (*Receipt, error) {
+	rc, err := buildReceipt(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.QueryRow(ctx,
+		`SELECT COALESCE(MAX(version), 0) + 1 FROM receipts WHERE ride_id = $1`,
+		rideID).Scan(&rc.Version); err != nil {
+		return nil, err
+	}
+	rc.IssuedAt = time.Now().UTC()
+	rc.ReceiptNumber = fmt.Sprintf("RCT-%s-%d", strings.ToUpper(strings.ReplaceAll(rideID, "-", "")[:10]), rc.Version)
+
+	data, err := json.Marshal(rc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO receipts (ride_id, version, receipt_number, data) VALUES ($1, $2, $3, $4)`,
+		rideID, rc.Version, rc.ReceiptNumber, string(data)); err != nil {
+		return nil, fmt.Errorf("failed to store receipt: %w", err)
+	}
+	return rc, nil
+}
+
+// loadReceipt returns a stored receipt; version 0 means the latest.
+func loadReceipt(ctx context.Context, rideID string, version int) (*Receipt, error) {
+	var data []byte
+	err := dbPool.QueryRow(ctx,
+		`SELECT data FROM receipts
+		 WHERE ride_id = $1 AND ($2 = 0 OR version = $2)
+		 ORDER BY version DESC
+		 LIMIT 1`,
+		rideID, version).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errReceiptNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rc Receipt
+	if err := json.Unmarshal(data, &rc); err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+// issueMissingReceipt creates the first receipt for a completed ride that
+// predates receipt generation.
+func issueMissingReceipt(ctx context.Context, rideID string) (*Receipt, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	if ride.Status != rideStatusCompleted {
+		return nil, errReceiptNotFound
+	}
+
+	rc, err := issueReceipt(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	return rc, tx.Commit(ctx)
+}
+
+func rideReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	rideID := mux.Vars(r)["id"]
+
+	if claims.Role != "admin" {
+		var allowed bool
+		err := dbPool.QueryRow(r.Context(),
+			`SELECT EXISTS (SELECT 1 FROM rides WHERE id = $1 AND (rider_id = $2 OR driver_id = $3))`,
+			rideID, claims.UserID, claims.Username).Scan(&allowed)
+		if err != nil || !allowed {
+			respondJSON(w, http.StatusNotFound, errorResponse("ride not found"))
+			return
+		}
+	}
+
+	version, _ := strconv.Atoi(r.URL.Query().Get("version"))
+	rc, err := loadReceipt(r.Context(), rideID, version)
+	if errors.Is(err, errReceiptNotFound) && version == 0 {
+		rc, err = issueMissingReceipt(r.Context(), rideID)
+	}
+	if errors.Is(err, errReceiptNotFound) || errors.Is(err, errRideNotFound) {
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load receipt for ride %s: %v", rideID, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+
+	if r.URL.Query().Get("format") == "pdf" {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, rc.ReceiptNumber))
+		w.Write(renderReceiptPDF(rc))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(rc))
+}
+
+// renderReceiptPDF lays a receipt out as a single A4 page.
+func renderReceiptPDF(rc *Receipt) []byte {
+	const (
+		left   = 50.0
+		right  = 380.0
+		lineHt = 18.0
+	)
+	y := 780.0
+	var texts []pdfText
+	add := func(x float64, size float64, bold bool, text string) {
+		texts = append(texts, pdfText{X: x, Y: y, Size: size, Bold: bold, Text: text})
+	}
+	currency := currencyFor(rc.Currency)
+	row := func(label string, amount Money) {
+		add(left, 11, false, label)
+		add(right, 11, false, currency.Format(amount))
+		y -= lineHt
+	}
+
+	add(left, 20, true, "Trip Receipt")
+	y -= 30
+	add(left, 10, false, "Receipt "+rc.ReceiptNumber)
+	y -= 14
+	add(left, 10, false, "Issued "+rc.IssuedAt.Format("2006-01-02 15:04 MST"))
+	y -= 14
+	add(left, 10, false, "Ride "+rc.RideID)
+	y -= 14
+	if rc.CompletedAt != nil {
+		add(left, 10, false, "Completed "+rc.CompletedAt.Format("2006-01-02 15:04"))
+		y -= 14
+	}
+	driver := rc.DriverID
+	if rc.DriverName != "" {
+		driver = rc.DriverName
+	}
+	if rc.Vehicle != "" {
+		driver += " - " + rc.Vehicle
+	}
+	add(left, 10, false, "Driver "+driver)
+	y -= 30
+
+	row("Base fare", rc.BaseFare)
+	row(fmt.Sprintf("Distance (%.2f km)", rc.DistanceKm), rc.DistanceFare)
+	row("Time", rc.TimeFare)
+	row("Surge", rc.Surge)
+	row("Waiting", rc.Waiting)
+	if rc.Discount > 0 {
+		label := "Promo"
+		if rc.PromoCode != "" {
+			label += " " + rc.PromoCode
+		}
+		row(label, -rc.Discount)
+	}
+	if rc.Adjustments != 0 {
+		row("Adjustments", rc.Adjustments)
+	}
+	row("Fare", rc.Fare)
+	row("Tip", rc.Tip)
+	y -= 6
+	add(left, 13, true, "Total")
+	add(right, 13, true, currency.Format(rc.Total))
+	y -= lineHt
+	if rc.TaxRate > 0 {
+		row(fmt.Sprintf("Includes tax (%.0f%%)", rc.TaxRate*100), rc.Tax)
+	}
+	y -= 10
+	add(left, 10, false, "Paid by "+rc.PaymentMethod)
+
+	return renderPDF(texts)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPDFEscape(t *testing.T) {
+	if got := pdfEscape(`Fare (UGX) \ total`); got != `Fare \(UGX\) \\ total` {
+		t.Errorf("Unexpected escape result: %s", got)
+	}
+}
+
+func TestRenderReceiptPDF(t *testing.T) {
+	rc := &Receipt{
+		ReceiptNumber: "RCT-ABCDEF1234-1",
+		RideID:        "abcdef12-3456-7890-abcd-ef1234567890",
+		IssuedAt:      time.Now(),
+		DriverID:      "driver1",
+		BaseFare:      3000,
+		DistanceFare:  14000,
+		Discount:      2000,
+		PromoCode:     "WELCOME",
+		Fare:          15000,
+		Tip:           3000,
+		Total:         18000,
+		Currency:      "UGX",
+		PaymentMethod: paymentMethodWallet,
+	}
+	pdf := renderReceiptPDF(rc)
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) {
+		t.Fatal("Missing PDF header")
+	}
+	if !bytes.HasSuffix(bytes.TrimSpace(pdf), []byte("%%EOF")) {
+		t.Error("Missing PDF trailer")
+	}
+	for _, want := range []string{"RCT-ABCDEF1234-1", "Promo WELCOME", "UGX 18,000", "Paid by wallet"} {
+		if !strings.Contains(string(pdf), want) {
+			t.Errorf("Expected PDF to contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	rideStatusRequested  = "requested"
+	rideStatusAccepted   = "accepted"
+	rideStatusArrived    = "arrived"
+	rideStatusInProgress = "in_progress"
+	rideStatusCompleted  = "completed"
+	rideStatusCancelled  = "cancelled"
+)
+
+var (
+	errRideNotFound          = errors.New("ride not found")
+	errInvalidRideTransition = errors.New("ride status does not allow this action")
+	errCashNotConfirmed      = errors.New("cash_collected is required for cash rides")
+)
+
+// lockRide loads a ride and locks its row for the rest of tx.
+func lockRide(ctx context.Context, tx pgx.Tx, rideID string) (*RideStatus, error) {
+	var ride RideStatus
+	err := tx.QueryRow(ctx,
+		`SELECT id, driver_id, rider_id, status, currency, COALESCE(price_estimate, 0),
+		        discount_amount, COALESCE(promo_code, ''), COALESCE(final_fare, 0),
+		        COALESCE(cash_collected, 0), COALESCE(estimated_eta, 0), payment_method,
+		        waiting_charge, amount_due, created_at, updated_at, accepted_at, arrived_at, started_at,
+		        completed_at, cancelled_at
+		 FROM rides WHERE id = $1
+		 FOR UPDATE`,
+		rideID).Scan(
+		&ride.ID, &ride.DriverID, &ride.RiderID, &ride.Status, &ride.Currency, &ride.Price,
+		&ride.Discount, &ride.PromoCode, &ride.FinalFare,
+		&ride.CashCollected, &ride.ETA, &ride.PaymentMethod,
+		&ride.WaitingCharge, &ride.AmountDue, &ride.CreatedAt, &ride.UpdatedAt, &ride.AcceptedAt, &ride.ArrivedAt, &ride.StartedAt,
+		&ride.CompletedAt, &ride.CancelledAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errRideNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ride: %w", err)
+	}
+	return &ride, nil
+}
+
+func acceptRideHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	ride, err := acceptRide(r.Context(), mux.Vars(r)["id"], claims.Username)
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+	if err := UpdateNotificationStatus(ride.DriverID, ride.ID, rideStatusAccepted); err != nil {
+		log.Printf("Failed to update notification for ride %s: %v", ride.ID, err)
+	}
+	publishRideStatus(ride)
+
+	respondJSON(w, http.StatusOK, successResponse(ride))
+}
+
+// acceptRide records the assigned driver taking the ride. accepted_at is what
+// cancellation policies measure against.
+func acceptRide(ctx context.Context, rideID, driverID string) (*RideStatus, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	if ride.DriverID != driverID {
+		return nil, errRideNotFound
+	}
+	if ride.Status != rideStatusRequested {
+		return nil, errInvalidRideTransition
+	}
+
+	if err := tx.QueryRow(ctx,
+		`UPDATE rides SET status = 'accepted', accepted_at = NOW(), updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING accepted_at, updated_at`,
+		ride.ID).Scan(&ride.AcceptedAt, &ride.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to accept ride: %w", err)
+	}
+	ride.Status = rideStatusAccepted
+	if err := setDriverState(ctx, tx, driverID, driverStateEnRoute); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return ride, nil
+}
+
+func completeRideHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		CashCollected *Money `json:"cash_collected"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	if req.CashCollected != nil && *req.CashCollected < 0 {
+		respondJSON(w, http.StatusBadRequest, errorResponse("cash_collected cannot be negative"))
+		return
+	}
+
+	ride, err := completeRide(r.Context(), mux.Vars(r)["id"], claims.Username, req.CashCollected)
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+	publishRideStatus(ride)
+
+	respondJSON(w, http.StatusOK, successResponse(ride))
+}
+
+// completeRide finishes a trip for its assigned driver, collects the fare
+// (upfront or metered from the GPS track, see meterRide) plus any waiting
+// charge and returns the driver to idle. Cash rides require the driver to
+// confirm how much cash they collected.
+func completeRide(ctx context.Context, rideID, driverID string, cashCollected *Money) (*RideStatus, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	if ride.DriverID != driverID {
+		return nil, errRideNotFound
+	}
+	if err := checkCompletable(ride); err != nil {
+		return nil, err
+	}
+	if ride.PaymentMethod == paymentMethodCash {
+		if cashCollected == nil {
+			return nil, errCashNotConfirmed
+		}
+		ride.CashCollected = *cashCollected
+	}
+
+	fare, err := meterRide(ctx, tx, ride, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	fare += ride.WaitingCharge
+	if err := tx.QueryRow(ctx,
+		`UPDATE rides
+		 SET status = 'completed', final_fare = $1, cash_collected = $2, waiting_charge = $3,
+		     completed_at = NOW(), updated_at = NOW()
+		 WHERE id = $4
+		 RETURNING updated_at, completed_at`,
+		fare, cashCollected, ride.WaitingCharge, ride.ID).Scan(&ride.UpdatedAt, &ride.CompletedAt); err != nil {
+		return nil, fmt.Errorf("failed to complete ride: %w", err)
+	}
+	ride.Status = rideStatusCompleted
+	ride.FinalFare = fare
+
+	if err := setDriverState(ctx, tx, ride.DriverID, driverStateIdle); err != nil {
+		return nil, err
+	}
+
+	if err := settleRidePayment(ctx, tx, ride, fare); err != nil {
+		return nil, err
+	}
+	if err := settleDriverShare(ctx, tx, ride, fare); err != nil {
+		return nil, err
+	}
+	if _, err := issueReceipt(ctx, tx, ride.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return ride, nil
+}
+
+// checkCompletable only lets a started trip complete. A rider who never
+// boarded is not billed a fare: the driver cancels the ride instead, and
+// the no-show cancellation fee applies.
+func checkCompletable(ride *RideStatus) error {
+	if ride.Status != rideStatusInProgress || ride.StartedAt == nil {
+		return errInvalidRideTransition
+	}
+	return nil
+}
+
+// settleRidePayment collects fare through the ride's payment method. Wallet
+// rides are captured in place and cash rides are settled by what the driver
+// confirmed collecting. Whatever is left, the whole fare on other rides, is
+// recorded as a due the rider pays by payment link (see dues.go) and saved
+// as the ride's amount_due.
+func settleRidePayment(ctx context.Context, tx pgx.Tx, ride *RideStatus, fare Money) error {
+	var collected Money
+	switch ride.PaymentMethod {
+	case paymentMethodCash:
+		collected = ride.CashCollected
+	case paymentMethodWallet:
+		charged, err := captureWalletHold(ctx, tx, ride.ID, fare)
+		if err != nil {
+			return fmt.Errorf("failed to charge wallet: %w", err)
+		}
+		if charged < fare {
+			log.Printf("Wallet for rider %d short by %s on ride %s", ride.RiderID, currencyFor(ride.Currency).Format(fare-charged), ride.ID)
+		}
+		collected = charged
+	}
+
+	due := fareShortfall(fare, collected)
+	gross := fare + ride.Discount
+	driverShare := dueDriverShare(ride.PaymentMethod, due, gross-calculateCommission(gross))
+	_, err := addRiderDue(ctx, tx, ride, dueKindFare, due, driverShare, 0)
+	return err
+}
+
+// fareShortfall is what the rider still owes once collected was taken.
+func fareShortfall(fare, collected Money) Money {
+	return max(0, fare-collected)
+}
+
+func respondRideError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errRideNotFound):
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+	case errors.Is(err, errInvalidRideTransition):
+		respondJSON(w, http.StatusConflict, errorResponse(err.Error()))
+	case errors.Is(err, errCashNotConfirmed):
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+	default:
+		log.Printf("Ride update failed: %v", err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// RideEvent is one entry in a ride's event stream. ID is the Redis stream ID,
+// which clients send back as last_event_id to resume after reconnecting.
+type RideEvent struct {
+	ID     string          `json:"id"`
+	RideID string          `json:"ride_id"`
+	Type   string          `json:"type"` // snapshot, status, location, chat, chat_receipt or chat_closed
+	Data   json.RawMessage `json:"data"`
+	At     time.Time       `json:"at"`
+}
+
+const (
+	rideStreamMaxLen    = 1000
+	rideStreamTTL       = 24 * time.Hour
+	rideStreamBlock     = 25 * time.Second
+	rideStreamWriteWait = 10 * time.Second
+)
+
+func rideStreamKey(rideID string) string {
+	return "ride:events:" + rideID
+}
+
+// publishRideEvent appends an event to the ride's stream. Streams are capped
+// and expire a day after their last event.
+func publishRideEvent(ctx context.Context, rideID, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	key := rideStreamKey(rideID)
+	pipe := redisClient.TxPipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: rideStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"type": eventType,
+			"data": payload,
+			"at":   time.Now().UTC().Format(time.RFC3339Nano),
+		},
+	})
+	pipe.Expire(ctx, key, rideStreamTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// publishRideStatus announces a ride status change to the rider's stream.
+// Failures are logged; the database remains the source of truth.
+func publishRideStatus(ride *RideStatus) {
+	data := map[string]interface{}{"status": ride.Status}
+	if ride.Status == rideStatusCompleted {
+		data["final_fare"] = ride.FinalFare
+		data["currency"] = ride.Currency
+	}
+	if err := publishRideEvent(context.Background(), ride.ID, "status", data); err != nil {
+		log.Printf("Failed to publish status for ride %s: %v", ride.ID, err)
+	}
+}
+
+// etaMinutes estimates minutes to cover distanceKm at the average trip speed.
+func etaMinutes(distanceKm float64) int {
+	return int(math.Ceil(distanceKm / averageTripSpeedKmh * 60))
+}
+
+func toRideEvent(rideID string, msg redis.XMessage) RideEvent {
+	ev := RideEvent{ID: msg.ID, RideID: rideID}
+	ev.Type, _ = msg.Values["type"].(string)
+	if data, ok := msg.Values["data"].(string); ok {
+		ev.Data = json.RawMessage(data)
+	}
+	if at, ok := msg.Values["at"].(string); ok {
+		ev.At, _ = time.Parse(time.RFC3339Nano, at)
+	}
+	return ev
+}
+
+func terminalRideEvent(ev RideEvent) bool {
+	if ev.Type != "status" && ev.Type != "snapshot" {
+		return false
+	}
+	var data struct {
+		Status string `json:"status"`
+	}
+	json.Unmarshal(ev.Data, &data)
+	return data.Status == rideStatusCompleted || data.Status == rideStatusCancelled
+}
+
+// riderWSHandler streams a ride's events to its rider. Browsers cannot set
+// headers on WebSocket requests, so the token may also be passed as ?token=.
+// A client that reconnects with last_event_id (or a Last-Event-ID header)
+// receives every event it missed; a fresh connection starts with a snapshot
+// of the ride. The rider sends chat frames over the same socket. The socket
+// closes after the ride completes or is cancelled, or once its chat closes
+// if the rider and driver can still message each other.
+func riderWSHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	claims, err := validateToken(token)
+	if err != nil {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid token"))
+		return
+	}
+
+	rideID := r.URL.Query().Get("ride_id")
+	snapshot, err := loadRideSnapshot(r.Context(), rideID, claims.UserID)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, errorResponse("ride not found"))
+		return
+	}
+
+	lastID := r.URL.Query().Get("last_event_id")
+	if lastID == "" {
+		lastID = r.Header.Get("Last-Event-ID")
+	}
+
+	ws, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer ws.Close()
+	conn := &wsConn{conn: ws}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The client sends chat frames; reading also notices it going away.
+	go func() {
+		defer cancel()
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if reply := chatSocketReply(claims, msg, rideID); reply != nil {
+				conn.WriteJSON(reply)
+			}
+		}
+	}()
+
+	key := rideStreamKey(rideID)
+	if lastID == "" {
+		// Start after the newest event so nothing published after the
+		// snapshot is missed.
+		lastID = "0"
+		if latest, err := redisClient.XRevRangeN(ctx, key, "+", "-", 1).Result(); err == nil && len(latest) > 0 {
+			lastID = latest[0].ID
+		}
+		snapshot.ID = lastID
+		if err := writeRideEvent(conn, *snapshot); err != nil {
+			return
+		}
+		if rideStreamDone(ctx, *snapshot) {
+			return
+		}
+	}
+
+	for {
+		streams, err := redisClient.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{key, lastID},
+			Count:   100,
+			Block:   rideStreamBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			if err := conn.WriteControl(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Ride stream %s read failed: %v", rideID, err)
+			}
+			return
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				ev := toRideEvent(rideID, msg)
+				if err := writeRideEvent(conn, ev); err != nil {
+					return
+				}
+				lastID = msg.ID
+				if rideStreamDone(ctx, ev) {
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseNormalClosure, "ride finished"))
+					return
+				}
+			}
+		}
+	}
+}
+
+// rideStreamDone says whether the rider's socket can close after ev: once
+// the ride has ended and its chat has closed.
+func rideStreamDone(ctx context.Context, ev RideEvent) bool {
+	if ev.Type == "chat_closed" {
+		return true
+	}
+	if !terminalRideEvent(ev) {
+		return false
+	}
+	ride, err := loadChatRide(ctx, dbPool, ev.RideID)
+	if err != nil {
+		return true
+	}
+	return !chatOpen(ride, time.Now(), envDuration("CHAT_CLOSE_AFTER", defaultChatCloseAfter))
+}
+
+func writeRideEvent(conn *wsConn, ev RideEvent) error {
+	return conn.WriteJSON(ev)
+}
+
+// loadRideSnapshot returns the rider's ride as a snapshot event with the
+// driver's last known position.
+func loadRideSnapshot(ctx context.Context, rideID string, riderID int) (*RideEvent, error) {
+	var status, driverID string
+	var eta int
+	err := dbPool.QueryRow(ctx,
+		`SELECT status, driver_id, COALESCE(estimated_eta, 0) FROM rides WHERE id = $1 AND rider_id = $2`,
+		rideID, riderID).Scan(&status, &driverID, &eta)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"status":    status,
+		"driver_id": driverID,
+		"eta":       eta,
+	}
+	if pos, err := redisClient.GeoPos(ctx, "drivers", driverID).Result(); err == nil && len(pos) > 0 && pos[0] != nil {
+		data["lat"] = pos[0].Latitude
+		data["lng"] = pos[0].Longitude
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &RideEvent{RideID: rideID, Type: "snapshot", Data: payload, At: time.Now().UTC()}, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestToRideEvent(t *testing.T) {
+	at := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	ev := toRideEvent("ride-1", redis.XMessage{
+		ID: "1714550400000-0",
+		Values: map[string]interface{}{
+			"type": "status",
+			"data": `{"status":"completed"}`,
+			"at":   at.Format(time.RFC3339Nano),
+		},
+	})
+	if ev.ID != "1714550400000-0" || ev.Type != "status" || !ev.At.Equal(at) {
+		t.Errorf("Unexpected event: %+v", ev)
+	}
+	if !terminalRideEvent(ev) {
+		t.Error("Completed status should end the stream")
+	}
+
+	location := RideEvent{Type: "location", Data: json.RawMessage(`{"status":"cancelled"}`)}
+	if terminalRideEvent(location) {
+		t.Error("Only status and snapshot events end the stream")
+	}
+}
+
+func TestETAMinutes(t *testing.T) {
+	if got := etaMinutes(5); got != 15 {
+		t.Errorf("Expected 15 minutes for 5 km, got %d", got)
+	}
+	if got := etaMinutes(0); got != 0 {
+		t.Errorf("Expected 0 minutes at the destination, got %d", got)
+	}
+}
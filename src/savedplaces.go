@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SavedPlace is a place a rider has labelled. Home and Work are unique per
+// rider; any number of custom places can be saved up to maxSavedPlaces.
+// PlaceID ("saved:<id>") can be used as a pickup or dropoff place ID.
+type SavedPlace struct {
+	ID        int64     `json:"id"`
+	PlaceID   string    `json:"place_id"`
+	Kind      string    `json:"kind"` // home, work or custom
+	Label     string    `json:"label"`
+	Address   string    `json:"address,omitempty"`
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RecentDestination is a dropoff from the rider's completed rides. Dropoffs
+// within a short distance of each other count as one destination.
+type RecentDestination struct {
+	Lat           float64   `json:"lat"`
+	Lng           float64   `json:"lng"`
+	Address       string    `json:"address,omitempty"`
+	Trips         int       `json:"trips"`
+	LastVisitedAt time.Time `json:"last_visited_at"`
+}
+
+const (
+	maxSavedPlaces          = 20
+	savedPlacePrefix        = "saved:"
+	recentDestinationRadius = 0.15 // km
+	recentRidesScanned      = 100
+	defaultRecentLimit      = 5
+)
+
+var errSavedPlaceNotFound = errors.New("saved place not found")
+
+func savedPlaceID(id int64) string {
+	return savedPlacePrefix + strconv.FormatInt(id, 10)
+}
+
+// savedPlaceRequest is the body of POST and PUT /saved-places. The location
+// comes from place_id (a search result) or from lat and lng.
+type savedPlaceRequest struct {
+	Kind    string   `json:"kind"`
+	Label   string   `json:"label"`
+	Address string   `json:"address"`
+	PlaceID string   `json:"place_id"`
+	Lat     *float64 `json:"lat"`
+	Lng     *float64 `json:"lng"`
+}
+
+// toSavedPlace validates the request, resolving place_id if one was sent.
+func (req savedPlaceRequest) toSavedPlace(ctx context.Context) (*SavedPlace, error) {
+	p := &SavedPlace{
+		Kind:    strings.ToLower(strings.TrimSpace(req.Kind)),
+		Label:   strings.TrimSpace(req.Label),
+		Address: strings.TrimSpace(req.Address),
+	}
+	switch p.Kind {
+	case "":
+		p.Kind = "custom"
+	case "home", "work", "custom":
+	default:
+		return nil, errors.New("kind must be home, work or custom")
+	}
+	if p.Label == "" {
+		if p.Kind == "custom" {
+			return nil, errors.New("label is required for custom places")
+		}
+		p.Label = strings.ToUpper(p.Kind[:1]) + p.Kind[1:]
+	}
+	if len(p.Label) > 50 {
+		return nil, errors.New("label must be at most 50 characters")
+	}
+
+	switch {
+	case req.PlaceID != "":
+		place, err := lookupPlace(ctx, req.PlaceID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, req.PlaceID)
+		}
+		p.Lat, p.Lng = place.Lat, place.Lng
+		if p.Address == "" {
+			p.Address = place.Address
+		}
+	case req.Lat != nil && req.Lng != nil && validCoordinates(*req.Lat, *req.Lng):
+		p.Lat, p.Lng = *req.Lat, *req.Lng
+	default:
+		return nil, errors.New("place_id or valid lat and lng are required")
+	}
+	return p, nil
+}
+
+const savedPlaceColumns = `id, kind, label, COALESCE(address, ''),
+	ST_Y(location::geometry), ST_X(location::geometry), created_at, updated_at`
+
+func scanSavedPlace(row pgx.Row) (*SavedPlace, error) {
+	var p SavedPlace
+	err := row.Scan(&p.ID, &p.Kind, &p.Label, &p.Address, &p.Lat, &p.Lng, &p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errSavedPlaceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.PlaceID = savedPlaceID(p.ID)
+	return &p, nil
+}
+
+// lookupSavedPlace resolves a "saved:<id>" place ID for its rider.
+func lookupSavedPlace(ctx context.Context, riderID int, placeID string) (*SavedPlace, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(placeID, savedPlacePrefix), 10, 64)
+	if err != nil {
+		return nil, errSavedPlaceNotFound
+	}
+	return scanSavedPlace(dbPool.QueryRow(ctx,
+		`SELECT `+savedPlaceColumns+` FROM saved_places WHERE id = $1 AND rider_id = $2`,
+		id, riderID))
+}
+
+// savedPlaceConflict reports the error for a second Home or Work, or a
+// repeated label.
+func savedPlaceConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func listSavedPlacesHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT `+savedPlaceColumns+`
+		 FROM saved_places
+		 WHERE rider_id = $1
+		 ORDER BY CASE kind WHEN 'home' THEN 0 WHEN 'work' THEN 1 ELSE 2 END, label`,
+		claims.UserID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	defer rows.Close()
+
+	places := []*SavedPlace{}
+	for rows.Next() {
+		p, err := scanSavedPlace(rows)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+			return
+		}
+		places = append(places, p)
+	}
+	respondJSON(w, http.StatusOK, successResponse(places))
+}
+
+func createSavedPlaceHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req savedPlaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	p, err := req.toSavedPlace(r.Context())
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	saved, err := scanSavedPlace(dbPool.QueryRow(r.Context(),
+		`INSERT INTO saved_places (rider_id, kind, label, address, location)
+		 SELECT $1, $2, $3, NULLIF($4, ''), ST_SetSRID(ST_MakePoint($5, $6), 4326)
+		 WHERE (SELECT COUNT(*) FROM saved_places WHERE rider_id = $1) < $7
+		 RETURNING `+savedPlaceColumns,
+		claims.UserID, p.Kind, p.Label, p.Address, p.Lng, p.Lat, maxSavedPlaces))
+	if errors.Is(err, errSavedPlaceNotFound) {
+		respondJSON(w, http.StatusConflict, errorResponse(fmt.Sprintf("at most %d saved places", maxSavedPlaces)))
+		return
+	}
+	if savedPlaceConflict(err) {
+		respondJSON(w, http.StatusConflict, errorResponse("a place with this kind or label is already saved"))
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusCreated, successResponse(saved))
+}
+
+func updateSavedPlaceHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req savedPlaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	p, err := req.toSavedPlace(r.Context())
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	saved, err := scanSavedPlace(dbPool.QueryRow(r.Context(),
+		`UPDATE saved_places
+		 SET kind = $1, label = $2, address = NULLIF($3, ''),
+		     location = ST_SetSRID(ST_MakePoint($4, $5), 4326), updated_at = NOW()
+		 WHERE id = $6 AND rider_id = $7
+		 RETURNING `+savedPlaceColumns,
+		p.Kind, p.Label, p.Address, p.Lng, p.Lat, mux.Vars(r)["id"], claims.UserID))
+	if errors.Is(err, errSavedPlaceNotFound) {
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+		return
+	}
+	if savedPlaceConflict(err) {
+		respondJSON(w, http.StatusConflict, errorResponse("a place with this kind or label is already saved"))
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(saved))
+}
+
+func deleteSavedPlaceHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	tag, err := dbPool.Exec(r.Context(),
+		`DELETE FROM saved_places WHERE id = $1 AND rider_id = $2`,
+		mux.Vars(r)["id"], claims.UserID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		respondJSON(w, http.StatusNotFound, errorResponse(errSavedPlaceNotFound.Error()))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(map[string]bool{"deleted": true}))
+}
+
+func recentDestinationsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	limit := defaultRecentLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			respondJSON(w, http.StatusBadRequest, errorResponse("limit must be a positive number"))
+			return
+		}
+		limit = min(n, maxPlacesLimit)
+	}
+
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT ST_Y(end_location::geometry), ST_X(end_location::geometry),
+		        COALESCE(dropoff_address, ''), COALESCE(completed_at, updated_at)
+		 FROM rides
+		 WHERE rider_id = $1 AND status = 'completed' AND end_location IS NOT NULL
+		 ORDER BY completed_at DESC NULLS LAST
+		 LIMIT $2`,
+		claims.UserID, recentRidesScanned)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	dropoffs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (RecentDestination, error) {
+		var d RecentDestination
+		err := row.Scan(&d.Lat, &d.Lng, &d.Address, &d.LastVisitedAt)
+		d.Trips = 1
+		return d, err
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(dedupeDestinations(dropoffs, recentDestinationRadius, limit)))
+}
+
+// dedupeDestinations merges dropoffs, newest first, into the first kept
+// destination within radiusKm. Each destination keeps the location and
+// address of its most recent visit.
+func dedupeDestinations(dropoffs []RecentDestination, radiusKm float64, limit int) []RecentDestination {
+	destinations := []RecentDestination{}
+	for _, d := range dropoffs {
+		merged := false
+		for i := range destinations {
+			if haversineKm(destinations[i].Lat, destinations[i].Lng, d.Lat, d.Lng) <= radiusKm {
+				destinations[i].Trips++
+				if destinations[i].Address == "" {
+					destinations[i].Address = d.Address
+				}
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			destinations = append(destinations, d)
+		}
+	}
+	if len(destinations) > limit {
+		destinations = destinations[:limit]
+	}
+	return destinations
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDedupeDestinations(t *testing.T) {
+	now := time.Now()
+	dropoffs := []RecentDestination{
+		{Lat: 0.3136, Lng: 32.5784, Address: "Nakasero Market", Trips: 1, LastVisitedAt: now},
+		{Lat: 0.3361, Lng: 32.5870, Trips: 1, LastVisitedAt: now.Add(-time.Hour)},
+		// About 50m from the first dropoff.
+		{Lat: 0.3140, Lng: 32.5787, Address: "Market Street", Trips: 1, LastVisitedAt: now.Add(-2 * time.Hour)},
+		{Lat: 0.3364, Lng: 32.5872, Address: "Acacia Mall", Trips: 1, LastVisitedAt: now.Add(-3 * time.Hour)},
+		{Lat: 0.0424, Lng: 32.4435, Address: "Entebbe Airport", Trips: 1, LastVisitedAt: now.Add(-4 * time.Hour)},
+	}
+
+	got := dedupeDestinations(dropoffs, recentDestinationRadius, 5)
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 destinations, got %+v", got)
+	}
+	if got[0].Address != "Nakasero Market" || got[0].Trips != 2 || !got[0].LastVisitedAt.Equal(now) {
+		t.Errorf("Expected the newest visit to be kept, got %+v", got[0])
+	}
+	if got[1].Address != "Acacia Mall" || got[1].Trips != 2 {
+		t.Errorf("Expected a missing address to be filled from an older visit, got %+v", got[1])
+	}
+
+	if got := dedupeDestinations(dropoffs, recentDestinationRadius, 2); len(got) != 2 {
+		t.Errorf("Expected the limit to apply, got %d destinations", len(got))
+	}
+}
+
+func TestSavedPlaceRequest(t *testing.T) {
+	ctx := context.Background()
+	lat, lng := 0.3136, 32.5811
+
+	p, err := savedPlaceRequest{Kind: "Home", Lat: &lat, Lng: &lng}.toSavedPlace(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Kind != "home" || p.Label != "Home" {
+		t.Errorf("Expected Home label by default, got %+v", p)
+	}
+
+	p, err = savedPlaceRequest{Label: "Gym", PlaceID: "gaz:acacia-mall"}.toSavedPlace(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Kind != "custom" || p.Lat != 0.3361 || p.Address != "Cooper Road, Kololo" {
+		t.Errorf("Expected the place to be resolved, got %+v", p)
+	}
+
+	for _, req := range []savedPlaceRequest{
+		{Kind: "custom", Lat: &lat, Lng: &lng},
+		{Kind: "school", Label: "School", Lat: &lat, Lng: &lng},
+		{Kind: "work"},
+	} {
+		if _, err := req.toSavedPlace(ctx); err == nil {
+			t.Errorf("Expected %+v to be rejected", req)
+		}
+	}
+}
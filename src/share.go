@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// TripShare is a public, read-only link to follow a ride live. Only a hash of
+// the token is stored, so the link cannot be recovered from the database.
+type TripShare struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SharedTrip is what people holding a share link can see. It deliberately
+// leaves out the rider, the fare and the pickup and dropoff addresses.
+type SharedTrip struct {
+	Status     string    `json:"status"`
+	DriverName string    `json:"driver_name,omitempty"`
+	Vehicle    string    `json:"vehicle,omitempty"`
+	Lat        *float64  `json:"lat,omitempty"`
+	Lng        *float64  `json:"lng,omitempty"`
+	ETA        *int      `json:"eta,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+
+	rideID    string
+	driverID  string
+	waypoints rideWaypoints
+}
+
+const (
+	defaultShareLinkTTL = 4 * time.Hour
+	maxShareLinkTTL     = 24 * time.Hour
+	shareRecheckPeriod  = 15 * time.Second
+)
+
+var errShareNotFound = errors.New("share link not found or expired")
+
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func shareURL(token string) string {
+	base := os.Getenv("PUBLIC_BASE_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return strings.TrimRight(base, "/") + "/share/" + token
+}
+
+func createShareHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		TTLMinutes int `json:"ttl_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	ttl := envDuration("SHARE_LINK_TTL", defaultShareLinkTTL)
+	if req.TTLMinutes < 0 {
+		respondJSON(w, http.StatusBadRequest, errorResponse("ttl_minutes cannot be negative"))
+		return
+	}
+	if req.TTLMinutes > 0 {
+		ttl = time.Duration(req.TTLMinutes) * time.Minute
+	}
+	ttl = min(ttl, maxShareLinkTTL)
+
+	share, err := createShare(r.Context(), mux.Vars(r)["id"], claims.UserID, ttl)
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, successResponse(share))
+}
+
+// createShare issues a share link for one of the rider's rides that is still
+// under way. The link also stops working as soon as the ride ends.
+func createShare(ctx context.Context, rideID string, riderID int, ttl time.Duration) (*TripShare, error) {
+	var status string
+	err := dbPool.QueryRow(ctx,
+		`SELECT status FROM rides WHERE id = $1 AND rider_id = $2`,
+		rideID, riderID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errRideNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ride: %w", err)
+	}
+	if status == rideStatusCompleted || status == rideStatusCancelled {
+		return nil, errInvalidRideTransition
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate share token: %w", err)
+	}
+	share := &TripShare{Token: token, URL: shareURL(token)}
+	if err := dbPool.QueryRow(ctx,
+		`INSERT INTO ride_shares (ride_id, rider_id, token_hash, expires_at)
+		 VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))
+		 RETURNING expires_at`,
+		rideID, riderID, hashShareToken(token), ttl.Seconds()).Scan(&share.ExpiresAt); err != nil {
+		return nil, fmt.Errorf("failed to create share link: %w", err)
+	}
+	return share, nil
+}
+
+func revokeShareHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	tag, err := dbPool.Exec(r.Context(),
+		`UPDATE ride_shares SET revoked_at = NOW()
+		 WHERE ride_id = $1 AND rider_id = $2 AND revoked_at IS NULL`,
+		mux.Vars(r)["id"], claims.UserID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"revoked": tag.RowsAffected(),
+	}))
+}
+
+// loadSharedTrip resolves a share token to the live view of its ride. Revoked
+// and expired links, and links to rides that have ended, are not found.
+func loadSharedTrip(ctx context.Context, token string) (*SharedTrip, error) {
+	t := &SharedTrip{}
+	err := dbPool.QueryRow(ctx,
+		`SELECT r.id, r.driver_id, r.status, COALESCE(d.name, ''), `+vehicleLabelSQL+`, s.expires_at,
+		        ST_Y(r.start_location::geometry), ST_X(r.start_location::geometry),
+		        COALESCE(ST_Y(r.end_location::geometry), 0), COALESCE(ST_X(r.end_location::geometry), 0)
+		 FROM ride_shares s
+		 JOIN rides r ON r.id = s.ride_id
+		 LEFT JOIN drivers d ON d.driver_id = r.driver_id
+		 LEFT JOIN vehicles v ON v.id = r.vehicle_id
+		 WHERE s.token_hash = $1 AND s.revoked_at IS NULL AND s.expires_at > NOW()
+		   AND r.status NOT IN ('completed', 'cancelled')`,
+		hashShareToken(token)).Scan(&t.rideID, &t.driverID, &t.Status, &t.DriverName, &t.Vehicle, &t.ExpiresAt,
+		&t.waypoints.PickupLat, &t.waypoints.PickupLng, &t.waypoints.DropoffLat, &t.waypoints.DropoffLng)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.waypoints.Status = t.Status
+
+	if pos, err := redisClient.GeoPos(ctx, "drivers", t.driverID).Result(); err == nil && len(pos) > 0 && pos[0] != nil {
+		t.Lat, t.Lng = &pos[0].Latitude, &pos[0].Longitude
+		if eta, ok := t.waypoints.etaFrom(*t.Lat, *t.Lng); ok {
+			t.ETA = &eta
+		}
+	}
+	return t, nil
+}
+
+func sharedTripHandler(w http.ResponseWriter, r *http.Request) {
+	trip, err := loadSharedTrip(r.Context(), mux.Vars(r)["token"])
+	if errors.Is(err, errShareNotFound) {
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load shared trip: %v", err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(trip))
+}
+
+// publicEventData strips a ride event down to what share links may show.
+func publicEventData(ev RideEvent) ([]byte, error) {
+	var data struct {
+		Status     string     `json:"status,omitempty"`
+		Lat        *float64   `json:"lat,omitempty"`
+		Lng        *float64   `json:"lng,omitempty"`
+		ETA        *int       `json:"eta,omitempty"`
+		RecordedAt *time.Time `json:"recorded_at,omitempty"`
+	}
+	if err := json.Unmarshal(ev.Data, &data); err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+func writeSSE(w http.ResponseWriter, rc *http.ResponseController, id, event string, data []byte) error {
+	rc.SetWriteDeadline(time.Now().Add(rideStreamWriteWait))
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// sharedTripEventsHandler streams a shared trip as server-sent events: a
+// snapshot, then status and location events from the ride's stream. It ends
+// with an "end" event when the ride finishes or the link is revoked or
+// expires. Browsers resume with Last-Event-ID automatically.
+func sharedTripEventsHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	trip, err := loadSharedTrip(r.Context(), token)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, errorResponse(errShareNotFound.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+	ctx := r.Context()
+	key := rideStreamKey(trip.rideID)
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = "0"
+		if latest, err := redisClient.XRevRangeN(ctx, key, "+", "-", 1).Result(); err == nil && len(latest) > 0 {
+			lastID = latest[0].ID
+		}
+		snapshot, _ := json.Marshal(trip)
+		if err := writeSSE(w, rc, lastID, "snapshot", snapshot); err != nil {
+			return
+		}
+	}
+
+	end := func(reason string) {
+		data, _ := json.Marshal(map[string]string{"reason": reason})
+		writeSSE(w, rc, "", "end", data)
+	}
+	checked := time.Now()
+	for {
+		if time.Since(checked) >= shareRecheckPeriod {
+			if _, err := loadSharedTrip(ctx, token); err != nil {
+				end("link expired or revoked")
+				return
+			}
+			checked = time.Now()
+		}
+
+		streams, err := redisClient.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{key, lastID},
+			Count:   100,
+			Block:   shareRecheckPeriod,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			rc.SetWriteDeadline(time.Now().Add(rideStreamWriteWait))
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Shared trip stream %s read failed: %v", trip.rideID, err)
+			}
+			return
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				ev := toRideEvent(trip.rideID, msg)
+				lastID = msg.ID
+				data, err := publicEventData(ev)
+				if err != nil {
+					continue
+				}
+				if err := writeSSE(w, rc, ev.ID, ev.Type, data); err != nil {
+					return
+				}
+				if terminalRideEvent(ev) {
+					end("ride ended")
+					return
+				}
+			}
+		}
+	}
+}
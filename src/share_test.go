@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestShareToken(t *testing.T) {
+	a, err := newShareToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newShareToken()
+	if a == b || len(a) != 43 {
+		t.Errorf("Expected distinct 43-character tokens, got %q and %q", a, b)
+	}
+	if hashShareToken(a) == a || len(hashShareToken(a)) != 64 {
+		t.Errorf("Unexpected token hash %q", hashShareToken(a))
+	}
+
+	t.Setenv("PUBLIC_BASE_URL", "https://rides.example.com/")
+	if got := shareURL("abc"); got != "https://rides.example.com/share/abc" {
+		t.Errorf("Unexpected share URL %q", got)
+	}
+}
+
+func TestPublicEventData(t *testing.T) {
+	ev := RideEvent{Type: "status", Data: json.RawMessage(`{"status":"completed","final_fare":18000,"currency":"UGX"}`)}
+	data, err := publicEventData(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"status":"completed"}` {
+		t.Errorf("Expected fare to be stripped, got %s", data)
+	}
+
+	ev = RideEvent{Type: "location", Data: json.RawMessage(`{"lat":0.31,"lng":32.58,"eta":4,"status":"accepted"}`)}
+	data, _ = publicEventData(ev)
+	if !strings.Contains(string(data), `"eta":4`) || !strings.Contains(string(data), `"lat":0.31`) {
+		t.Errorf("Expected position and ETA to be kept, got %s", data)
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Driver states. Drivers are offline until they start a shift, idle while
+// waiting for work, offered once matched to a ride, en route to the pickup
+// after accepting and on trip after starting the ride.
+const (
+	driverStateOffline = "offline"
+	driverStateIdle    = "idle"
+	driverStateOffered = "offered"
+	driverStateEnRoute = "en_route"
+	driverStateOnTrip  = "on_trip"
+)
+
+// DriverShift is one online session. DurationS is set when the shift ends.
+type DriverShift struct {
+	ID        int64      `json:"id"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	EndReason string     `json:"end_reason,omitempty"` // driver or timeout
+	DurationS *int64     `json:"duration_s,omitempty"`
+}
+
+type DriverShiftStatus struct {
+	DriverID   string       `json:"driver_id"`
+	State      string       `json:"state"`
+	LastSeenAt *time.Time   `json:"last_seen_at,omitempty"`
+	Shift      *DriverShift `json:"shift,omitempty"`
+}
+
+const (
+	defaultHeartbeatTimeout = 2 * time.Minute
+	shiftMonitorPeriod      = 30 * time.Second
+)
+
+var (
+	errDriverNotFound = errors.New("driver not found")
+	errDriverBusy     = errors.New("driver is on a ride")
+
+	errDriverNotEligible = errors.New("documents expired or not approved")
+)
+
+// setDriverState moves a driver to state within tx.
+func setDriverState(ctx context.Context, tx pgx.Tx, driverID, state string) error {
+	if _, err := tx.Exec(ctx,
+		`UPDATE drivers SET state = $1, state_changed_at = NOW() WHERE driver_id = $2`,
+		state, driverID); err != nil {
+		return fmt.Errorf("failed to update driver state: %w", err)
+	}
+	return nil
+}
+
+func goOnlineHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	status, err := goOnline(r.Context(), claims.Username)
+	if errors.Is(err, errDriverNotFound) {
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+		return
+	}
+	if errors.Is(err, errDriverNotEligible) {
+		respondJSON(w, http.StatusForbidden, errorResponse(err.Error()))
+		return
+	}
+	if errors.Is(err, errNoVehicle) || errors.Is(err, errVehicleNotAuthorised) ||
+		errors.Is(err, errVehicleInactive) || errors.Is(err, errVehicleInUse) {
+		respondJSON(w, http.StatusConflict, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start shift for driver %s: %v", claims.Username, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(status))
+}
+
+// goOnline starts a shift. Drivers already online keep their current shift.
+func goOnline(ctx context.Context, driverID string) (*DriverShiftStatus, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	var state string
+	var eligible bool
+	var vehicleID *int64
+	err = tx.QueryRow(ctx,
+		`SELECT state, `+eligibleDriverSQL+`, vehicle_id FROM drivers d WHERE driver_id = $1 FOR UPDATE`,
+		driverID).Scan(&state, &eligible, &vehicleID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errDriverNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load driver: %w", err)
+	}
+	if !eligible {
+		return nil, errDriverNotEligible
+	}
+
+	if state == driverStateOffline {
+		if vehicleID == nil {
+			return nil, errNoVehicle
+		}
+		if err := claimVehicle(ctx, tx, driverID, *vehicleID); err != nil {
+			return nil, err
+		}
+		if err := setDriverState(ctx, tx, driverID, driverStateIdle); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO driver_shifts (driver_id) VALUES ($1)`,
+			driverID); err != nil {
+			return nil, fmt.Errorf("failed to start shift: %w", err)
+		}
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE drivers SET last_seen_at = NOW() WHERE driver_id = $1`,
+		driverID); err != nil {
+		return nil, fmt.Errorf("failed to record heartbeat: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return loadShiftStatus(ctx, driverID)
+}
+
+func goOfflineHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	status, err := goOffline(r.Context(), claims.Username)
+	if errors.Is(err, errDriverBusy) {
+		respondJSON(w, http.StatusConflict, errorResponse("finish or cancel your ride before going offline"))
+		return
+	}
+	if errors.Is(err, errDriverNotFound) {
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to end shift for driver %s: %v", claims.Username, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(status))
+}
+
+// goOffline ends the driver's shift. A ride offered to the driver goes to
+// the next driver; drivers who accepted a ride have to finish or cancel it
+// first.
+func goOffline(ctx context.Context, driverID string) (*DriverShiftStatus, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	offered, err := lockOfferedRide(ctx, tx, driverID)
+	if err != nil {
+		return nil, err
+	}
+	var state string
+	err = tx.QueryRow(ctx,
+		`SELECT state FROM drivers WHERE driver_id = $1 FOR UPDATE`,
+		driverID).Scan(&state)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errDriverNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load driver: %w", err)
+	}
+	var o *reoffer
+	switch state {
+	case driverStateOffline:
+	case driverStateIdle, driverStateOffered:
+		if state == driverStateOffered && offered != nil {
+			if o, err = withdrawOffer(ctx, tx, offered); err != nil {
+				return nil, err
+			}
+		}
+		if err := endShift(ctx, tx, driverID, "driver"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errDriverBusy
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	o.announce(ctx)
+	return loadShiftStatus(ctx, driverID)
+}
+
+// endShift takes the driver offline and closes their open shift.
+func endShift(ctx context.Context, tx pgx.Tx, driverID, reason string) error {
+	if err := setDriverState(ctx, tx, driverID, driverStateOffline); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE driver_shifts
+		 SET ended_at = NOW(), end_reason = $1,
+		     duration_s = EXTRACT(EPOCH FROM NOW() - started_at)::bigint
+		 WHERE driver_id = $2 AND ended_at IS NULL`,
+		reason, driverID); err != nil {
+		return fmt.Errorf("failed to end shift: %w", err)
+	}
+	return nil
+}
+
+// driverHeartbeatHandler keeps an online driver from timing out. Drivers
+// between rides send their position with it so matching finds them where
+// they are.
+func driverHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	var req struct {
+		Lat *float64 `json:"lat"`
+		Lng *float64 `json:"lng"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	if (req.Lat == nil) != (req.Lng == nil) || (req.Lat != nil && !validCoordinates(*req.Lat, *req.Lng)) {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid coordinates"))
+		return
+	}
+
+	ctx := r.Context()
+	var err error
+	if req.Lat != nil {
+		_, err = dbPool.Exec(ctx,
+			`UPDATE drivers
+			 SET last_seen_at = NOW(), current_location = ST_SetSRID(ST_MakePoint($1, $2), 4326), last_updated = NOW()
+			 WHERE driver_id = $3`,
+			*req.Lng, *req.Lat, claims.Username)
+		if err == nil {
+			go cacheDriverLocation(claims.Username, *req.Lat, *req.Lng)
+		}
+	} else {
+		err = touchDriver(ctx, claims.Username)
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+
+	status, err := loadShiftStatus(ctx, claims.Username)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(status))
+}
+
+// touchDriver records that the driver's app is still reporting.
+func touchDriver(ctx context.Context, driverID string) error {
+	_, err := dbPool.Exec(ctx,
+		`UPDATE drivers SET last_seen_at = NOW() WHERE driver_id = $1`,
+		driverID)
+	return err
+}
+
+func loadShiftStatus(ctx context.Context, driverID string) (*DriverShiftStatus, error) {
+	status := &DriverShiftStatus{DriverID: driverID}
+	if err := dbPool.QueryRow(ctx,
+		`SELECT state, last_seen_at FROM drivers WHERE driver_id = $1`,
+		driverID).Scan(&status.State, &status.LastSeenAt); err != nil {
+		return nil, err
+	}
+
+	var shift DriverShift
+	err := dbPool.QueryRow(ctx,
+		`SELECT id, started_at FROM driver_shifts WHERE driver_id = $1 AND ended_at IS NULL`,
+		driverID).Scan(&shift.ID, &shift.StartedAt)
+	if err == nil {
+		status.Shift = &shift
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	return status, nil
+}
+
+func driverShiftsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	status, err := loadShiftStatus(r.Context(), claims.Username)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT id, started_at, ended_at, COALESCE(end_reason, ''), duration_s
+		 FROM driver_shifts
+		 WHERE driver_id = $1
+		 ORDER BY started_at DESC
+		 LIMIT 50`,
+		claims.Username)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	shifts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (DriverShift, error) {
+		var s DriverShift
+		err := row.Scan(&s.ID, &s.StartedAt, &s.EndedAt, &s.EndReason, &s.DurationS)
+		return s, err
+	})
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+		return
+	}
+	if shifts == nil {
+		shifts = []DriverShift{}
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"status": status,
+		"shifts": shifts,
+	}))
+}
+
+// startShiftMonitor takes idle and offered drivers offline once their
+// heartbeats stop, and takes back offers left unanswered for OFFER_TIMEOUT.
+// Drivers on a ride are left alone; their ride is still in progress.
+func startShiftMonitor() {
+	go func() {
+		ticker := time.NewTicker(shiftMonitorPeriod)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := expireSilentDrivers(context.Background()); err != nil {
+				log.Printf("Shift monitor failed: %v", err)
+			}
+		}
+	}()
+}
+
+// driverSilent reports whether a driver waiting for work, idle or with an
+// offer, has gone longer than timeout without a heartbeat.
+func driverSilent(state string, lastSeen *time.Time, now time.Time, timeout time.Duration) bool {
+	if state != driverStateIdle && state != driverStateOffered {
+		return false
+	}
+	return lastSeen == nil || now.Sub(*lastSeen) > timeout
+}
+
+func expireSilentDrivers(ctx context.Context) error {
+	timeout := envDuration("DRIVER_HEARTBEAT_TIMEOUT", defaultHeartbeatTimeout)
+	offerTimeout := envDuration("OFFER_TIMEOUT", defaultOfferTimeout)
+	rows, err := dbPool.Query(ctx,
+		`SELECT driver_id FROM drivers
+		 WHERE (state IN ('idle', 'offered') AND (last_seen_at IS NULL OR last_seen_at < NOW() - make_interval(secs => $1)))
+		    OR (state = 'offered' AND state_changed_at < NOW() - make_interval(secs => $2))`,
+		timeout.Seconds(), offerTimeout.Seconds())
+	if err != nil {
+		return err
+	}
+	candidates, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	for _, driverID := range candidates {
+		if err := expireDriver(ctx, driverID, timeout, offerTimeout); err != nil {
+			log.Printf("Failed to expire driver %s: %v", driverID, err)
+		}
+	}
+	return nil
+}
+
+// expireDriver rechecks the driver under lock, since a heartbeat, a match or
+// an acceptance may have arrived since the scan. Silent drivers go offline
+// and drivers who let an offer lapse go back to idle; either way their
+// offered ride goes to the next driver.
+func expireDriver(ctx context.Context, driverID string, timeout, offerTimeout time.Duration) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	offered, err := lockOfferedRide(ctx, tx, driverID)
+	if err != nil {
+		return err
+	}
+	var state string
+	var lastSeen *time.Time
+	var changedAt, now time.Time
+	if err := tx.QueryRow(ctx,
+		`SELECT state, last_seen_at, state_changed_at, LOCALTIMESTAMP
+		 FROM drivers WHERE driver_id = $1 FOR UPDATE`,
+		driverID).Scan(&state, &lastSeen, &changedAt, &now); err != nil {
+		return err
+	}
+	silent := driverSilent(state, lastSeen, now, timeout)
+	if !silent && !offerIgnored(state, changedAt, now, offerTimeout) {
+		return nil
+	}
+
+	var o *reoffer
+	if state == driverStateOffered && offered != nil {
+		if o, err = withdrawOffer(ctx, tx, offered); err != nil {
+			return err
+		}
+	}
+	if silent {
+		err = endShift(ctx, tx, driverID, "timeout")
+	} else {
+		err = setDriverState(ctx, tx, driverID, driverStateIdle)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	o.announce(ctx)
+
+	if silent {
+		log.Printf("Driver %s went offline after %s without a heartbeat", driverID, timeout)
+	} else {
+		log.Printf("Driver %s let an offer lapse after %s", driverID, offerTimeout)
+	}
+	return nil
+}
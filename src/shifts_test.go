@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDriverSilent(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	recent := now.Add(-30 * time.Second)
+	stale := now.Add(-5 * time.Minute)
+	timeout := 2 * time.Minute
+
+	tests := []struct {
+		state    string
+		lastSeen *time.Time
+		want     bool
+	}{
+		{driverStateIdle, &recent, false},
+		{driverStateIdle, &stale, true},
+		{driverStateIdle, nil, true},
+		{driverStateOffered, &recent, false},
+		{driverStateOffered, &stale, true},
+		{driverStateOffline, &stale, false},
+		{driverStateEnRoute, &stale, false},
+		{driverStateOnTrip, nil, false},
+	}
+	for _, tt := range tests {
+		if got := driverSilent(tt.state, tt.lastSeen, now, timeout); got != tt.want {
+			t.Errorf("%s driver last seen %v: expected silent=%v, got %v", tt.state, tt.lastSeen, tt.want, got)
+		}
+	}
+}
+
+func TestOfferIgnored(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	timeout := 30 * time.Second
+
+	if offerIgnored(driverStateOffered, now.Add(-10*time.Second), now, timeout) {
+		t.Error("Expected a fresh offer to stand")
+	}
+	if !offerIgnored(driverStateOffered, now.Add(-time.Minute), now, timeout) {
+		t.Error("Expected an offer left for a minute to lapse")
+	}
+	for _, state := range []string{driverStateIdle, driverStateEnRoute, driverStateOnTrip, driverStateOffline} {
+		if offerIgnored(state, now.Add(-time.Hour), now, timeout) {
+			t.Errorf("Expected a %s driver to have no offer to lapse", state)
+		}
+	}
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// Incident is a safety incident raised with SOS during a ride. Trip and Track
+// are captured when it is raised so later changes do not alter the record.
+type Incident struct {
+	ID               int64        `json:"id"`
+	RideID           string       `json:"ride_id"`
+	ReportedBy       string       `json:"reported_by"` // rider or driver
+	ReporterID       int          `json:"reporter_id"`
+	Reporter         string       `json:"reporter"`
+	Lat              *float64     `json:"lat,omitempty"`
+	Lng              *float64     `json:"lng,omitempty"`
+	Message          string       `json:"message,omitempty"`
+	Trip             IncidentTrip `json:"trip"`
+	Track            []TrackPoint `json:"track,omitempty"`
+	Status           string       `json:"status"`       // open, acknowledged or resolved
+	AlertStatus      string       `json:"alert_status"` // pending, sent or failed
+	ContactsNotified int          `json:"contacts_notified"`
+	Notes            string       `json:"notes,omitempty"`
+	CreatedAt        time.Time    `json:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at"`
+	ResolvedAt       *time.Time   `json:"resolved_at,omitempty"`
+	RidePriority     bool         `json:"ride_priority"` // the ride still has unresolved incidents
+}
+
+// PriorityRide is a ride flagged for priority handling while it has
+// unresolved incidents.
+type PriorityRide struct {
+	RideID         string    `json:"ride_id"`
+	Status         string    `json:"status"`
+	RiderID        int       `json:"rider_id"`
+	DriverID       string    `json:"driver_id,omitempty"`
+	OpenIncidents  int       `json:"open_incidents"`
+	LastIncidentAt time.Time `json:"last_incident_at"`
+}
+
+// IncidentTrip is the ride as it was when SOS was raised.
+type IncidentTrip struct {
+	Status      string    `json:"status"`
+	RiderID     int       `json:"rider_id"`
+	DriverID    string    `json:"driver_id"`
+	DriverName  string    `json:"driver_name,omitempty"`
+	Vehicle     string    `json:"vehicle,omitempty"`
+	PickupLat   float64   `json:"pickup_lat"`
+	PickupLng   float64   `json:"pickup_lng"`
+	DropoffLat  float64   `json:"dropoff_lat,omitempty"`
+	DropoffLng  float64   `json:"dropoff_lng,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+type TrustedContact struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Phone     string    `json:"phone"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	defaultSOSTrackWindow = 10 * time.Minute
+	maxTrustedContacts    = 5
+)
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{9,15}$`)
+
+var errIncidentNotFound = errors.New("incident not found")
+
+func sosHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		Lat     *float64 `json:"lat"`
+		Lng     *float64 `json:"lng"`
+		Message string   `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	if (req.Lat == nil) != (req.Lng == nil) || (req.Lat != nil && !validCoordinates(*req.Lat, *req.Lng)) {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid coordinates"))
+		return
+	}
+
+	incident, created, err := raiseIncident(r.Context(), mux.Vars(r)["id"], claims, req.Lat, req.Lng, strings.TrimSpace(req.Message))
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+	if !created {
+		respondJSON(w, http.StatusOK, successResponse(incident))
+		return
+	}
+
+	go dispatchIncident(incident)
+	respondJSON(w, http.StatusCreated, successResponse(incident))
+}
+
+// raiseIncident records an SOS from the ride's rider or driver together with
+// the trip details and the last SOS_TRACK_WINDOW of GPS track, and flags the
+// ride for priority handling. Repeated presses while the reporter already has
+// an open incident on the ride return that incident.
+func raiseIncident(ctx context.Context, rideID string, claims *Claims, lat, lng *float64, message string) (*Incident, bool, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, false, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	inc := &Incident{RideID: rideID, ReporterID: claims.UserID, Reporter: claims.Username, Message: message}
+	trip := &inc.Trip
+	err = tx.QueryRow(ctx,
+		`SELECT r.status, r.rider_id, r.driver_id, COALESCE(d.name, ''), `+vehicleLabelSQL+`,
+		        ST_Y(r.start_location::geometry), ST_X(r.start_location::geometry),
+		        COALESCE(ST_Y(r.end_location::geometry), 0), COALESCE(ST_X(r.end_location::geometry), 0),
+		        r.created_at
+		 FROM rides r
+		 LEFT JOIN drivers d ON d.driver_id = r.driver_id
+		 LEFT JOIN vehicles v ON v.id = r.vehicle_id
+		 WHERE r.id = $1
+		 FOR UPDATE OF r`,
+		rideID).Scan(&trip.Status, &trip.RiderID, &trip.DriverID, &trip.DriverName, &trip.Vehicle,
+		&trip.PickupLat, &trip.PickupLng, &trip.DropoffLat, &trip.DropoffLng, &trip.RequestedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, errRideNotFound
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load ride: %w", err)
+	}
+	switch {
+	case claims.Role == "driver" && trip.DriverID == claims.Username:
+		inc.ReportedBy = "driver"
+	case claims.Role != "driver" && trip.RiderID == claims.UserID:
+		inc.ReportedBy = "rider"
+	default:
+		return nil, false, errRideNotFound
+	}
+
+	existing, err := loadIncidentWhere(ctx, tx,
+		`ride_id = $1 AND reporter_id = $2 AND reported_by = $3 AND status <> 'resolved'`,
+		rideID, inc.ReporterID, inc.ReportedBy)
+	if err == nil {
+		return existing, false, nil
+	}
+	if !errors.Is(err, errIncidentNotFound) {
+		return nil, false, err
+	}
+
+	window := envDuration("SOS_TRACK_WINDOW", defaultSOSTrackWindow)
+	rows, err := tx.Query(ctx,
+		`SELECT lat, lng, COALESCE(accuracy, 0), recorded_at
+		 FROM ride_locations
+		 WHERE ride_id = $1 AND recorded_at >= NOW() - make_interval(secs => $2)
+		 ORDER BY recorded_at`,
+		rideID, window.Seconds())
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load track: %w", err)
+	}
+	inc.Track, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (TrackPoint, error) {
+		var p TrackPoint
+		err := row.Scan(&p.Lat, &p.Lng, &p.Accuracy, &p.RecordedAt)
+		return p, err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load track: %w", err)
+	}
+
+	inc.Lat, inc.Lng = lat, lng
+	if inc.Lat == nil && len(inc.Track) > 0 {
+		last := inc.Track[len(inc.Track)-1]
+		inc.Lat, inc.Lng = &last.Lat, &last.Lng
+	}
+
+	tripJSON, err := json.Marshal(inc.Trip)
+	if err != nil {
+		return nil, false, err
+	}
+	trackJSON, err := json.Marshal(inc.Track)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO sos_incidents (ride_id, reported_by, reporter_id, reporter, lat, lng, message, trip, track)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 RETURNING id, status, alert_status, created_at, updated_at`,
+		inc.RideID, inc.ReportedBy, inc.ReporterID, inc.Reporter, inc.Lat, inc.Lng, inc.Message,
+		string(tripJSON), string(trackJSON)).Scan(&inc.ID, &inc.Status, &inc.AlertStatus, &inc.CreatedAt, &inc.UpdatedAt); err != nil {
+		return nil, false, fmt.Errorf("failed to record incident: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE rides SET priority = true, updated_at = NOW() WHERE id = $1`,
+		rideID); err != nil {
+		return nil, false, fmt.Errorf("failed to flag ride: %w", err)
+	}
+	inc.RidePriority = true
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, errors.New("failed to commit transaction")
+	}
+	return inc, true, nil
+}
+
+// dispatchIncident alerts on-call ops and texts the reporter's trusted
+// contacts. A rider's contacts also get a live trip-share link.
+func dispatchIncident(inc *Incident) {
+	ctx := context.Background()
+
+	alertStatus := "sent"
+	sink, err := getAlertSink()
+	if err == nil {
+		err = sink.SendAlert(inc)
+	}
+	if err != nil {
+		log.Printf("Failed to alert ops about incident %d: %v", inc.ID, err)
+		alertStatus = "failed"
+	}
+
+	notified := 0
+	contacts, err := loadTrustedContacts(ctx, inc.ReporterID)
+	if err != nil {
+		log.Printf("Failed to load trusted contacts for incident %d: %v", inc.ID, err)
+	}
+	if len(contacts) > 0 {
+		msg := incidentContactMessage(inc, incidentShareURL(ctx, inc))
+		sender, err := getSMSSender()
+		if err != nil {
+			log.Printf("Failed to notify trusted contacts for incident %d: %v", inc.ID, err)
+			contacts = nil
+		}
+		for _, c := range contacts {
+			if err := sender.SendSMS(c.Phone, msg); err != nil {
+				log.Printf("Failed to text trusted contact %d for incident %d: %v", c.ID, inc.ID, err)
+				continue
+			}
+			notified++
+		}
+	}
+
+	if _, err := dbPool.Exec(ctx,
+		`UPDATE sos_incidents SET alert_status = $1, contacts_notified = $2, updated_at = NOW() WHERE id = $3`,
+		alertStatus, notified, inc.ID); err != nil {
+		log.Printf("Failed to update incident %d: %v", inc.ID, err)
+	}
+}
+
+func incidentShareURL(ctx context.Context, inc *Incident) string {
+	if inc.ReportedBy != "rider" {
+		return ""
+	}
+	share, err := createShare(ctx, inc.RideID, inc.ReporterID, envDuration("SHARE_LINK_TTL", defaultShareLinkTTL))
+	if err != nil {
+		return ""
+	}
+	return share.URL
+}
+
+func incidentContactMessage(inc *Incident, shareURL string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "SOS: %s raised an emergency alert during a ride", inc.Reporter)
+	if inc.Trip.DriverName != "" && inc.ReportedBy == "rider" {
+		fmt.Fprintf(&b, " with driver %s", inc.Trip.DriverName)
+		if inc.Trip.Vehicle != "" {
+			fmt.Fprintf(&b, " (%s)", inc.Trip.Vehicle)
+		}
+	}
+	b.WriteString(".")
+	if inc.Lat != nil {
+		fmt.Fprintf(&b, " Last location: https://maps.google.com/?q=%.6f,%.6f.", *inc.Lat, *inc.Lng)
+	}
+	if shareURL != "" {
+		fmt.Fprintf(&b, " Follow the trip: %s", shareURL)
+	}
+	return b.String()
+}
+
+// incidentRidePriority reads the priority flag of an incident's ride.
+const incidentRidePriority = `COALESCE((SELECT priority FROM rides WHERE rides.id = sos_incidents.ride_id), false)`
+
+const incidentColumns = `id, ride_id, reported_by, reporter_id, reporter, lat, lng, COALESCE(message, ''),
+	trip, track, status, alert_status, contacts_notified, COALESCE(notes, ''),
+	created_at, updated_at, resolved_at, ` + incidentRidePriority
+
+func scanIncident(row pgx.Row) (*Incident, error) {
+	var inc Incident
+	var trip, track []byte
+	err := row.Scan(&inc.ID, &inc.RideID, &inc.ReportedBy, &inc.ReporterID, &inc.Reporter, &inc.Lat, &inc.Lng,
+		&inc.Message, &trip, &track, &inc.Status, &inc.AlertStatus, &inc.ContactsNotified, &inc.Notes,
+		&inc.CreatedAt, &inc.UpdatedAt, &inc.ResolvedAt, &inc.RidePriority)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errIncidentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(trip, &inc.Trip); err != nil {
+		return nil, fmt.Errorf("invalid incident trip: %w", err)
+	}
+	if err := json.Unmarshal(track, &inc.Track); err != nil {
+		return nil, fmt.Errorf("invalid incident track: %w", err)
+	}
+	return &inc, nil
+}
+
+func loadIncidentWhere(ctx context.Context, q queryRower, where string, args ...interface{}) (*Incident, error) {
+	return scanIncident(q.QueryRow(ctx,
+		`SELECT `+incidentColumns+` FROM sos_incidents WHERE `+where+` ORDER BY created_at DESC LIMIT 1`,
+		args...))
+}
+
+// listIncidentsHandler is the ops queue: incidents on priority rides first,
+// then open incidents, newest first. Tracks are left out of the list; fetch
+// an incident for its full record.
+func listIncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok || claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT `+incidentColumns+`
+		 FROM sos_incidents
+		 WHERE $1 = '' OR status = $1
+		 ORDER BY `+incidentRidePriority+` DESC, status = 'open' DESC, created_at DESC
+		 LIMIT 100`,
+		status)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	defer rows.Close()
+
+	incidents := []*Incident{}
+	for rows.Next() {
+		inc, err := scanIncident(rows)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+			return
+		}
+		inc.Track = nil
+		incidents = append(incidents, inc)
+	}
+	respondJSON(w, http.StatusOK, successResponse(incidents))
+}
+
+// listPriorityRidesHandler lists the rides flagged for priority handling,
+// most recently raised first.
+func listPriorityRidesHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok || claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT r.id, r.status, r.rider_id, COALESCE(r.driver_id, ''),
+		        COUNT(i.id) FILTER (WHERE i.status <> 'resolved'), MAX(i.created_at)
+		 FROM rides r
+		 JOIN sos_incidents i ON i.ride_id = r.id
+		 WHERE r.priority
+		 GROUP BY r.id
+		 ORDER BY MAX(i.created_at) DESC
+		 LIMIT 100`)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	defer rows.Close()
+
+	rides := []PriorityRide{}
+	for rows.Next() {
+		var p PriorityRide
+		if err := rows.Scan(&p.RideID, &p.Status, &p.RiderID, &p.DriverID, &p.OpenIncidents, &p.LastIncidentAt); err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse("Data parsing error"))
+			return
+		}
+		rides = append(rides, p)
+	}
+	respondJSON(w, http.StatusOK, successResponse(rides))
+}
+
+func getIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok || claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	inc, err := loadIncidentWhere(r.Context(), dbPool, `id = $1`, id)
+	if errors.Is(err, errIncidentNotFound) {
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(inc))
+}
+
+// updateIncidentHandler lets ops acknowledge or resolve an incident. The
+// ride's priority flag is cleared once none of its incidents are open.
+func updateIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok || claims.Role != "admin" {
+		respondJSON(w, http.StatusForbidden, errorResponse("admins only"))
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+		Notes  string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	if req.Status != "acknowledged" && req.Status != "resolved" {
+		respondJSON(w, http.StatusBadRequest, errorResponse("status must be acknowledged or resolved"))
+		return
+	}
+
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	inc, err := updateIncidentStatus(r.Context(), id, req.Status, strings.TrimSpace(req.Notes), claims.UserID)
+	if errors.Is(err, errIncidentNotFound) {
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update incident %d: %v", id, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(inc))
+}
+
+func updateIncidentStatus(ctx context.Context, id int64, status, notes string, adminID int) (*Incident, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	inc, err := scanIncident(tx.QueryRow(ctx,
+		`UPDATE sos_incidents
+		 SET status = $1, notes = COALESCE(NULLIF($2, ''), notes), handled_by = $3, updated_at = NOW(),
+		     resolved_at = CASE WHEN $1 = 'resolved' THEN NOW() ELSE resolved_at END
+		 WHERE id = $4 AND status <> 'resolved'
+		 RETURNING `+incidentColumns,
+		status, notes, adminID, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.QueryRow(ctx,
+		`UPDATE rides SET priority = EXISTS (
+		     SELECT 1 FROM sos_incidents WHERE ride_id = $1 AND status <> 'resolved')
+		 WHERE id = $1
+		 RETURNING priority`,
+		inc.RideID).Scan(&inc.RidePriority); err != nil {
+		return nil, fmt.Errorf("failed to update ride priority: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return inc, nil
+}
+
+func loadTrustedContacts(ctx context.Context, userID int) ([]TrustedContact, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT id, name, phone, created_at FROM trusted_contacts WHERE user_id = $1 ORDER BY id`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (TrustedContact, error) {
+		var c TrustedContact
+		err := row.Scan(&c.ID, &c.Name, &c.Phone, &c.CreatedAt)
+		return c, err
+	})
+}
+
+func listTrustedContactsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	contacts, err := loadTrustedContacts(r.Context(), claims.UserID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	if contacts == nil {
+		contacts = []TrustedContact{}
+	}
+	respondJSON(w, http.StatusOK, successResponse(contacts))
+}
+
+func addTrustedContactHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var c TrustedContact
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	c.Name = strings.TrimSpace(c.Name)
+	c.Phone = strings.ReplaceAll(strings.TrimSpace(c.Phone), " ", "")
+	if c.Name == "" || !phonePattern.MatchString(c.Phone) {
+		respondJSON(w, http.StatusBadRequest, errorResponse("name and a valid phone number are required"))
+		return
+	}
+
+	err := dbPool.QueryRow(r.Context(),
+		`INSERT INTO trusted_contacts (user_id, name, phone)
+		 SELECT $1, $2, $3
+		 WHERE (SELECT COUNT(*) FROM trusted_contacts WHERE user_id = $1) < $4
+		 ON CONFLICT (user_id, phone) DO UPDATE SET name = EXCLUDED.name
+		 RETURNING id, created_at`,
+		claims.UserID, c.Name, c.Phone, maxTrustedContacts).Scan(&c.ID, &c.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondJSON(w, http.StatusConflict, errorResponse(fmt.Sprintf("at most %d trusted contacts", maxTrustedContacts)))
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	respondJSON(w, http.StatusCreated, successResponse(c))
+}
+
+func deleteTrustedContactHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	tag, err := dbPool.Exec(r.Context(),
+		`DELETE FROM trusted_contacts WHERE id = $1 AND user_id = $2`,
+		mux.Vars(r)["id"], claims.UserID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		respondJSON(w, http.StatusNotFound, errorResponse("contact not found"))
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(map[string]bool{"deleted": true}))
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestIncidentContactMessage(t *testing.T) {
+	lat, lng := 0.3136, 32.5811
+	inc := &Incident{
+		ReportedBy: "rider",
+		Reporter:   "alice",
+		Lat:        &lat,
+		Lng:        &lng,
+		Trip:       IncidentTrip{DriverName: "John", Vehicle: "Toyota Premio"},
+	}
+	got := incidentContactMessage(inc, "https://rides.example.com/share/abc")
+	want := "SOS: alice raised an emergency alert during a ride with driver John (Toyota Premio)." +
+		" Last location: https://maps.google.com/?q=0.313600,32.581100." +
+		" Follow the trip: https://rides.example.com/share/abc"
+	if got != want {
+		t.Errorf("Unexpected message:\n got %q\nwant %q", got, want)
+	}
+
+	inc = &Incident{ReportedBy: "driver", Reporter: "driver1", Trip: IncidentTrip{DriverName: "John"}}
+	if got := incidentContactMessage(inc, ""); got != "SOS: driver1 raised an emergency alert during a ride." {
+		t.Errorf("Unexpected driver message %q", got)
+	}
+}
+
+func TestPhonePattern(t *testing.T) {
+	for phone, valid := range map[string]bool{
+		"+256772123456": true,
+		"0772123456":    true,
+		"254712345678":  true,
+		"12345":         false,
+		"+256-772-123":  false,
+		"":              false,
+	} {
+		if phonePattern.MatchString(phone) != valid {
+			t.Errorf("phonePattern(%q) = %v, want %v", phone, !valid, valid)
+		}
+	}
+}
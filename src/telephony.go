@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// InboundCall is a call a provider received on one of our proxy numbers.
+type InboundCall struct {
+	From  string `json:"from"`   // the caller's real number
+	To    string `json:"to"`     // the proxy number dialled
+	RefID string `json:"ref_id"` // the provider's call ID, for logs
+}
+
+// TelephonyProvider carries masked calls. Calls to a proxy number reach the
+// inbound call webhook, which answers with where to connect them.
+type TelephonyProvider interface {
+	Name() string
+	// ParseInboundCall authenticates the provider's webhook request and
+	// reads the call from it.
+	ParseInboundCall(r *http.Request) (*InboundCall, error)
+	// Bridge answers the webhook by connecting the call to number, showing
+	// the proxy number as the caller ID.
+	Bridge(w http.ResponseWriter, call *InboundCall, number string)
+	// Reject answers the webhook by playing message and hanging up.
+	Reject(w http.ResponseWriter, call *InboundCall, message string)
+}
+
+var errInboundCallUnauthorised = errors.New("inbound call not authorised")
+
+var telephonyProviders = map[string]TelephonyProvider{
+	"local": localTelephony{},
+}
+
+// getTelephonyProvider returns the provider named by TELEPHONY_PROVIDER,
+// defaulting to the local fake.
+func getTelephonyProvider() (TelephonyProvider, error) {
+	name := os.Getenv("TELEPHONY_PROVIDER")
+	if name == "" {
+		name = "local"
+	}
+	p, ok := telephonyProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown telephony provider: %s", name)
+	}
+	return p, nil
+}
+
+// localTelephony stands in for a provider in development and tests. It
+// takes the call as JSON, authenticated by TELEPHONY_WEBHOOK_SECRET in an
+// X-Telephony-Secret header, and answers with the action as JSON.
+type localTelephony struct{}
+
+func (localTelephony) Name() string { return "local" }
+
+func (localTelephony) ParseInboundCall(r *http.Request) (*InboundCall, error) {
+	secret := os.Getenv("TELEPHONY_WEBHOOK_SECRET")
+	got := r.Header.Get("X-Telephony-Secret")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+		return nil, errInboundCallUnauthorised
+	}
+	var call InboundCall
+	if err := json.NewDecoder(r.Body).Decode(&call); err != nil {
+		return nil, fmt.Errorf("invalid inbound call: %w", err)
+	}
+	if call.From == "" || call.To == "" {
+		return nil, errors.New("invalid inbound call: from and to are required")
+	}
+	return &call, nil
+}
+
+func (localTelephony) Bridge(w http.ResponseWriter, call *InboundCall, number string) {
+	respondJSON(w, http.StatusOK, map[string]string{
+		"action":    "bridge",
+		"to":        number,
+		"caller_id": call.To,
+	})
+}
+
+func (localTelephony) Reject(w http.ResponseWriter, call *InboundCall, message string) {
+	respondJSON(w, http.StatusOK, map[string]string{
+		"action":  "reject",
+		"message": message,
+	})
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+type Tip struct {
+	ID          int64     `json:"id"`
+	RideID      string    `json:"ride_id"`
+	RiderID     int       `json:"rider_id"`
+	DriverID    string    `json:"driver_id"`
+	Amount      Money     `json:"amount"`
+	Currency    string    `json:"currency"`
+	Status      string    `json:"status"`
+	TxRef       string    `json:"tx_ref,omitempty"`
+	PaymentLink string    `json:"payment_link,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const defaultTipWindow = 24 * time.Hour
+
+var (
+	errTipWindowClosed = errors.New("tips can only be added within the tip window after a completed ride")
+	errAlreadyTipped   = errors.New("ride has already been tipped")
+)
+
+// tipRideHandler lets the rider tip after a completed ride. Wallet rides are
+// debited immediately; other rides get a payment link and the tip is credited
+// once /rides/{id}/tip/verify confirms payment.
+func tipRideHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		Amount   Money  `json:"amount"` // minor units of the ride's currency
+		Provider string `json:"provider"`
+		Phone    string `json:"phone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	if req.Amount <= 0 {
+		respondJSON(w, http.StatusBadRequest, errorResponse("amount must be positive"))
+		return
+	}
+
+	provider, err := getPaymentProvider(req.Provider)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	tip, err := createTip(r.Context(), mux.Vars(r)["id"], claims.UserID, req.Amount, provider.Name())
+	switch {
+	case errors.Is(err, errInsufficientWalletFunds):
+		respondJSON(w, http.StatusPaymentRequired, errorResponse(err.Error()))
+		return
+	case errors.Is(err, errTipWindowClosed), errors.Is(err, errAlreadyTipped), errors.Is(err, errCurrencyMismatch):
+		respondJSON(w, http.StatusConflict, errorResponse(err.Error()))
+		return
+	case err != nil:
+		respondRideError(w, err)
+		return
+	}
+
+	if tip.Status == "pending" {
+		tip.PaymentLink, err = provider.InitiatePayment(PaymentRequest{
+			TxRef:    tip.TxRef,
+			Amount:   tip.Amount,
+			Currency: tip.Currency,
+			Email:    claims.Email,
+			Phone:    req.Phone,
+			RideID:   tip.RideID,
+		})
+		if err != nil {
+			dbPool.Exec(r.Context(),
+				`UPDATE tips SET status = 'failed', updated_at = NOW() WHERE id = $1`, tip.ID)
+			respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(tip))
+}
+
+// createTip records a tip for a completed ride inside the tip window. Wallet
+// tips are settled in the same transaction; other tips stay pending until the
+// payment collected through provider is verified.
+func createTip(ctx context.Context, rideID string, riderID int, amount Money, provider string) (*Tip, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	if ride.RiderID != riderID {
+		return nil, errRideNotFound
+	}
+
+	rows, err := tx.Query(ctx, `SELECT status FROM tips WHERE ride_id = $1`, ride.ID)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	if err := tipAllowed(ride, statuses, time.Now(), envDuration("TIP_WINDOW", defaultTipWindow)); err != nil {
+		return nil, err
+	}
+
+	tip := &Tip{
+		RideID:   ride.ID,
+		RiderID:  ride.RiderID,
+		DriverID: ride.DriverID,
+		Amount:   amount,
+		Currency: ride.Currency,
+		Status:   "pending",
+		TxRef:    fmt.Sprintf("tip-%s-%d", ride.ID, time.Now().Unix()),
+	}
+	if ride.PaymentMethod == paymentMethodWallet {
+		tip.Status = "completed"
+		tip.TxRef = ""
+		provider = paymentMethodWallet
+	}
+
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO tips (ride_id, rider_id, driver_id, amount, currency, status, tx_ref, provider)
+		 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+		 RETURNING id, created_at`,
+		tip.RideID, tip.RiderID, tip.DriverID, tip.Amount, tip.Currency, tip.Status, tip.TxRef, provider).Scan(&tip.ID, &tip.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to record tip: %w", err)
+	}
+
+	if tip.Status == "completed" {
+		if err := debitWallet(ctx, tx, tip.RiderID, tip.Amount, tip.Currency, "tip", tip.RideID, ""); err != nil {
+			return nil, err
+		}
+		if err := creditTip(ctx, tx, tip); err != nil {
+			return nil, err
+		}
+		if _, err := issueReceipt(ctx, tx, tip.RideID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return tip, nil
+}
+
+// tipAllowed checks a tip on ride at now, given the statuses of its earlier
+// tips. The ride must have completed within window, and a pending or
+// completed tip rules out another; failed ones do not.
+func tipAllowed(ride *RideStatus, tipStatuses []string, now time.Time, window time.Duration) error {
+	if ride.Status != rideStatusCompleted || ride.CompletedAt == nil || now.Sub(*ride.CompletedAt) > window {
+		return errTipWindowClosed
+	}
+	for _, status := range tipStatuses {
+		if status == "pending" || status == "completed" {
+			return errAlreadyTipped
+		}
+	}
+	return nil
+}
+
+// creditTip passes the whole tip to the driver; no commission is taken.
+func creditTip(ctx context.Context, tx pgx.Tx, tip *Tip) error {
+	if err := adjustDriverBalance(ctx, tx, tip.DriverID, tip.Amount, tip.Currency, "tip", tip.RideID, tip.TxRef); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx,
+		`UPDATE driver_earnings SET tip = tip + $1 WHERE ride_id = $2`,
+		tip.Amount, tip.RideID)
+	return err
+}
+
+func verifyTipHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		TxRef string `json:"tx_ref"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+
+	var providerName, status string
+	err := dbPool.QueryRow(r.Context(),
+		`SELECT provider, status FROM tips
+		 WHERE tx_ref = $1 AND ride_id = $2 AND rider_id = $3`,
+		req.TxRef, mux.Vars(r)["id"], claims.UserID).Scan(&providerName, &status)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, errorResponse("tip not found"))
+		return
+	}
+
+	if status == "pending" {
+		provider, err := getPaymentProvider(providerName)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+			return
+		}
+		verified, err := provider.VerifyPayment(req.TxRef)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+			return
+		}
+		if verified {
+			if err := completeTip(r.Context(), req.TxRef); err != nil {
+				log.Printf("Failed to complete tip %s: %v", req.TxRef, err)
+				respondJSON(w, http.StatusInternalServerError, errorResponse("failed to credit tip"))
+				return
+			}
+			status = "completed"
+		}
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"tx_ref":   req.TxRef,
+		"status":   status,
+		"verified": status == "completed",
+	}))
+}
+
+func completeTip(ctx context.Context, txRef string) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tip := &Tip{TxRef: txRef}
+	err = tx.QueryRow(ctx,
+		`UPDATE tips SET status = 'completed', updated_at = NOW()
+		 WHERE tx_ref = $1 AND status = 'pending'
+		 RETURNING id, ride_id, rider_id, driver_id, amount, currency`,
+		txRef).Scan(&tip.ID, &tip.RideID, &tip.RiderID, &tip.DriverID, &tip.Amount, &tip.Currency)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := creditTip(ctx, tx, tip); err != nil {
+		return err
+	}
+	// Lock the ride so the new receipt version can't race an adjustment
+	if _, err := lockRide(ctx, tx, tip.RideID); err != nil {
+		return err
+	}
+	if _, err := issueReceipt(ctx, tx, tip.RideID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
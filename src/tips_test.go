@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTipAllowed(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	recent := now.Add(-time.Hour)
+	old := now.Add(-48 * time.Hour)
+	window := 24 * time.Hour
+
+	cases := []struct {
+		name     string
+		ride     *RideStatus
+		statuses []string
+		want     error
+	}{
+		{"inside the window", &RideStatus{Status: rideStatusCompleted, CompletedAt: &recent}, nil, nil},
+		{"after the window", &RideStatus{Status: rideStatusCompleted, CompletedAt: &old}, nil, errTipWindowClosed},
+		{"ride not completed", &RideStatus{Status: rideStatusInProgress}, nil, errTipWindowClosed},
+		{"already tipped", &RideStatus{Status: rideStatusCompleted, CompletedAt: &recent}, []string{"completed"}, errAlreadyTipped},
+		{"tip awaiting payment", &RideStatus{Status: rideStatusCompleted, CompletedAt: &recent}, []string{"pending"}, errAlreadyTipped},
+		{"earlier tip failed", &RideStatus{Status: rideStatusCompleted, CompletedAt: &recent}, []string{"failed"}, nil},
+	}
+	for _, c := range cases {
+		if got := tipAllowed(c.ride, c.statuses, now, window); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
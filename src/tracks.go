@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// RideTrack is a ride's recorded path for replay, in the two forms map
+// clients expect.
+type RideTrack struct {
+	RideID    string         `json:"ride_id"`
+	Points    int            `json:"points"`
+	StartedAt *time.Time     `json:"started_at,omitempty"`
+	EndedAt   *time.Time     `json:"ended_at,omitempty"`
+	GeoJSON   GeoJSONFeature `json:"geojson"`
+	Polyline  string         `json:"polyline"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONLineString      `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"` // [lng, lat]
+}
+
+const (
+	defaultTrackRetention       = 180 * 24 * time.Hour
+	defaultTrackDownsampleAfter = 7 * 24 * time.Hour
+	defaultTrackDownsampleStep  = 15 * time.Second
+	trackMaintenancePeriod      = time.Hour
+	trackDownsampleBatch        = 100
+)
+
+func rideTrackHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	rideID := mux.Vars(r)["id"]
+
+	var allowed bool
+	err := dbPool.QueryRow(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM rides WHERE id = $1 AND ($2 OR rider_id = $3 OR driver_id = $4))`,
+		rideID, claims.Role == "admin", claims.UserID, claims.Username).Scan(&allowed)
+	if err != nil || !allowed {
+		respondJSON(w, http.StatusNotFound, errorResponse("ride not found"))
+		return
+	}
+
+	points, err := loadRideTrack(r.Context(), rideID)
+	if err != nil {
+		log.Printf("Failed to load track for ride %s: %v", rideID, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(buildRideTrack(rideID, points)))
+}
+
+func loadRideTrack(ctx context.Context, rideID string) ([]TrackPoint, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT lat, lng, COALESCE(accuracy, 0), recorded_at
+		 FROM ride_locations
+		 WHERE ride_id = $1
+		 ORDER BY recorded_at`,
+		rideID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (TrackPoint, error) {
+		var p TrackPoint
+		err := row.Scan(&p.Lat, &p.Lng, &p.Accuracy, &p.RecordedAt)
+		return p, err
+	})
+}
+
+func buildRideTrack(rideID string, points []TrackPoint) *RideTrack {
+	t := &RideTrack{
+		RideID: rideID,
+		Points: len(points),
+		GeoJSON: GeoJSONFeature{
+			Type:     "Feature",
+			Geometry: GeoJSONLineString{Type: "LineString", Coordinates: [][2]float64{}},
+		},
+	}
+	timestamps := make([]time.Time, len(points))
+	for i, p := range points {
+		t.GeoJSON.Geometry.Coordinates = append(t.GeoJSON.Geometry.Coordinates, [2]float64{p.Lng, p.Lat})
+		timestamps[i] = p.RecordedAt
+	}
+	if len(points) > 0 {
+		t.StartedAt = &points[0].RecordedAt
+		t.EndedAt = &points[len(points)-1].RecordedAt
+	}
+	t.GeoJSON.Properties = map[string]interface{}{
+		"ride_id":    rideID,
+		"timestamps": timestamps,
+	}
+	t.Polyline = encodePolyline(points)
+	return t
+}
+
+// encodePolyline encodes points with Google's polyline algorithm at five
+// decimal places.
+func encodePolyline(points []TrackPoint) string {
+	var b strings.Builder
+	var prevLat, prevLng int64
+	for _, p := range points {
+		lat := int64(math.Round(p.Lat * 1e5))
+		lng := int64(math.Round(p.Lng * 1e5))
+		encodePolylineValue(&b, lat-prevLat)
+		encodePolylineValue(&b, lng-prevLng)
+		prevLat, prevLng = lat, lng
+	}
+	return b.String()
+}
+
+func encodePolylineValue(b *strings.Builder, v int64) {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte((0x20 | (u & 0x1f)) + 63))
+		u >>= 5
+	}
+	b.WriteByte(byte(u + 63))
+}
+
+// startTrackMaintenance keeps ride_locations bounded: it creates upcoming
+// monthly partitions, drops months older than TRACK_RETENTION and thins out
+// tracks of rides that finished more than TRACK_DOWNSAMPLE_AFTER ago.
+func startTrackMaintenance() {
+	go func() {
+		runTrackMaintenance(context.Background())
+		ticker := time.NewTicker(trackMaintenancePeriod)
+		defer ticker.Stop()
+		for range ticker.C {
+			runTrackMaintenance(context.Background())
+		}
+	}()
+}
+
+func runTrackMaintenance(ctx context.Context) {
+	now := time.Now().UTC()
+	if err := ensureLocationPartitions(ctx, now); err != nil {
+		log.Printf("Creating ride location partitions failed: %v", err)
+	}
+	if err := dropExpiredLocationPartitions(ctx, now.Add(-envDuration("TRACK_RETENTION", defaultTrackRetention))); err != nil {
+		log.Printf("Dropping expired ride locations failed: %v", err)
+	}
+	if n, err := downsampleTracks(ctx); err != nil {
+		log.Printf("Downsampling ride tracks failed: %v", err)
+	} else if n > 0 {
+		log.Printf("Downsampled tracks of %d rides", n)
+	}
+}
+
+func locationPartitionName(month time.Time) string {
+	return fmt.Sprintf("ride_locations_y%04dm%02d", month.Year(), int(month.Month()))
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ensureLocationPartitions creates the partitions for last, this and next
+// month, which covers every ping the API accepts.
+func ensureLocationPartitions(ctx context.Context, now time.Time) error {
+	for i := -1; i <= 1; i++ {
+		month := monthStart(now).AddDate(0, i, 0)
+		if _, err := dbPool.Exec(ctx, fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s PARTITION OF ride_locations FOR VALUES FROM ('%s') TO ('%s')`,
+			locationPartitionName(month), month.Format("2006-01-02"), month.AddDate(0, 1, 0).Format("2006-01-02"))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropExpiredLocationPartitions drops every monthly partition that ends
+// before cutoff.
+func dropExpiredLocationPartitions(ctx context.Context, cutoff time.Time) error {
+	rows, err := dbPool.Query(ctx,
+		`SELECT c.relname
+		 FROM pg_inherits i
+		 JOIN pg_class c ON c.oid = i.inhrelid
+		 JOIN pg_class p ON p.oid = i.inhparent
+		 WHERE p.relname = 'ride_locations'`)
+	if err != nil {
+		return err
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		var year, month int
+		if _, err := fmt.Sscanf(name, "ride_locations_y%04dm%02d", &year, &month); err != nil {
+			continue
+		}
+		end := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+		if !end.Before(cutoff) {
+			continue
+		}
+		if _, err := dbPool.Exec(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, name)); err != nil {
+			return err
+		}
+		log.Printf("Dropped ride location partition %s", name)
+	}
+	return nil
+}
+
+// downsampleTracks keeps one point per TRACK_DOWNSAMPLE_STEP for rides that
+// finished more than TRACK_DOWNSAMPLE_AFTER ago. The first point of each step
+// is kept, so the path shape survives while live-tracking density does not.
+func downsampleTracks(ctx context.Context) (int, error) {
+	after := envDuration("TRACK_DOWNSAMPLE_AFTER", defaultTrackDownsampleAfter)
+	step := envDuration("TRACK_DOWNSAMPLE_STEP", defaultTrackDownsampleStep)
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`SELECT id FROM rides
+		 WHERE status IN ('completed', 'cancelled') AND track_downsampled_at IS NULL
+		   AND COALESCE(completed_at, cancelled_at, updated_at) < NOW() - make_interval(secs => $1)
+		 LIMIT $2
+		 FOR UPDATE SKIP LOCKED`,
+		after.Seconds(), trackDownsampleBatch)
+	if err != nil {
+		return 0, err
+	}
+	rideIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, err
+	}
+	if len(rideIDs) == 0 {
+		return 0, nil
+	}
+
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM ride_locations l
+		 WHERE l.ride_id = ANY($1::uuid[])
+		   AND (l.id, l.recorded_at) NOT IN (
+		       SELECT DISTINCT ON (ride_id, floor(extract(epoch FROM recorded_at) / $2)) id, recorded_at
+		       FROM ride_locations
+		       WHERE ride_id = ANY($1::uuid[])
+		       ORDER BY ride_id, floor(extract(epoch FROM recorded_at) / $2), recorded_at)`,
+		rideIDs, step.Seconds()); err != nil {
+		return 0, fmt.Errorf("failed to thin ride locations: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE rides SET track_downsampled_at = NOW() WHERE id = ANY($1::uuid[])`,
+		rideIDs); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(rideIDs), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEncodePolyline(t *testing.T) {
+	points := []TrackPoint{
+		{Lat: 38.5, Lng: -120.2},
+		{Lat: 40.7, Lng: -120.95},
+		{Lat: 43.252, Lng: -126.453},
+	}
+	if got, want := encodePolyline(points), "_p~iF~ps|U_ulLnnqC_mqNvxq`@"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestBuildRideTrack(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	track := buildRideTrack("ride-1", []TrackPoint{
+		{Lat: 0.3135, Lng: 32.5805, RecordedAt: start},
+		{Lat: 0.3200, Lng: 32.5900, RecordedAt: start.Add(time.Minute)},
+	})
+	coords := track.GeoJSON.Geometry.Coordinates
+	if track.GeoJSON.Geometry.Type != "LineString" || len(coords) != 2 || coords[0] != [2]float64{32.5805, 0.3135} {
+		t.Errorf("Unexpected GeoJSON: %+v", track.GeoJSON)
+	}
+	if !track.EndedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("Unexpected end time %v", track.EndedAt)
+	}
+
+	empty := buildRideTrack("ride-2", nil)
+	if empty.Points != 0 || empty.Polyline != "" || empty.GeoJSON.Geometry.Coordinates == nil {
+		t.Errorf("Unexpected empty track: %+v", empty)
+	}
+}
+
+func TestLocationPartitionName(t *testing.T) {
+	month := monthStart(time.Date(2024, 5, 17, 13, 0, 0, 0, time.UTC))
+	if got := locationPartitionName(month); got != "ride_locations_y2024m05" {
+		t.Errorf("Unexpected partition name %q", got)
+	}
+}
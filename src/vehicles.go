@@ -0,0 +1,1014 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Vehicle is owned either by a single driver or by a fleet. Drivers drive
+// the vehicles they are authorised for, one at a time.
+type Vehicle struct {
+	ID            int64     `json:"id"`
+	Plate         string    `json:"plate"`
+	Make          string    `json:"make,omitempty"`
+	Model         string    `json:"model"`
+	Colour        string    `json:"colour,omitempty"`
+	Year          int       `json:"year,omitempty"`
+	Class         string    `json:"class"`
+	Capacity      int       `json:"capacity"`
+	FleetID       *int64    `json:"fleet_id,omitempty"`
+	OwnerDriverID string    `json:"owner_driver_id,omitempty"`
+	Active        bool      `json:"active"`
+	Drivers       []string  `json:"drivers,omitempty"` // authorised drivers, in fleet views
+	InUseBy       string    `json:"in_use_by,omitempty"`
+	Current       bool      `json:"current,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Fleet is a fleet owner's vehicles and the drivers working for them.
+type Fleet struct {
+	ID          int64         `json:"id"`
+	Name        string        `json:"name"`
+	OwnerUserID int           `json:"owner_user_id"`
+	Vehicles    []Vehicle     `json:"vehicles"`
+	Drivers     []FleetDriver `json:"drivers"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+type FleetDriver struct {
+	DriverID  string `json:"driver_id"`
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	VehicleID *int64 `json:"vehicle_id,omitempty"`
+}
+
+type FleetTrip struct {
+	RideID    string     `json:"ride_id"`
+	DriverID  string     `json:"driver_id"`
+	VehicleID int64      `json:"vehicle_id"`
+	Plate     string     `json:"plate"`
+	Status    string     `json:"status"`
+	Currency  string     `json:"currency"`
+	Fare      Money      `json:"fare"`
+	Net       *Money     `json:"net,omitempty"` // driver earnings once the trip is settled
+	CreatedAt time.Time  `json:"created_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// FleetEarnings totals a fleet's trip earnings per driver and per vehicle,
+// one row per currency earned in.
+type FleetEarnings struct {
+	Since     time.Time             `json:"since"`
+	ByDriver  []FleetEarningsTotals `json:"by_driver"`
+	ByVehicle []FleetEarningsTotals `json:"by_vehicle"`
+}
+
+type FleetEarningsTotals struct {
+	DriverID   string `json:"driver_id,omitempty"`
+	VehicleID  int64  `json:"vehicle_id,omitempty"`
+	Plate      string `json:"plate,omitempty"`
+	Currency   string `json:"currency"`
+	Trips      int    `json:"trips"`
+	Gross      Money  `json:"gross"`
+	Commission Money  `json:"commission"`
+	Net        Money  `json:"net"`
+	Tips       Money  `json:"tips"`
+}
+
+// vehicleClasses maps each class riders can request to its default seat
+// capacity.
+var vehicleClasses = map[string]int{
+	"boda":    1,
+	"economy": 4,
+	"comfort": 4,
+	"xl":      6,
+}
+
+const (
+	maxVehicleCapacity   = 16
+	oldestVehicleYear    = 1980
+	fleetTripsLimit      = 50
+	maxFleetTripsLimit   = 200
+	defaultEarningsDays  = 7
+	maxFleetEarningsDays = 90
+)
+
+// vehicleLabelSQL describes vehicle v for riders, e.g.
+// "White Toyota Premio (UBA 123X)". It is empty when v is NULL.
+const vehicleLabelSQL = `CONCAT_WS(' ', v.colour, v.make, v.model, '(' || v.plate || ')')`
+
+var (
+	errFleetNotFound        = errors.New("fleet not found")
+	errVehicleNotFound      = errors.New("vehicle not found")
+	errVehicleNotAuthorised = errors.New("not authorised for this vehicle")
+	errVehicleInactive      = errors.New("vehicle is not active")
+	errVehicleInUse         = errors.New("vehicle is in use by another driver")
+	errNoVehicle            = errors.New("select a vehicle first")
+	errDriverInFleet        = errors.New("driver already belongs to a fleet")
+)
+
+type vehicleRequest struct {
+	Plate    string `json:"plate"`
+	Make     string `json:"make"`
+	Model    string `json:"model"`
+	Colour   string `json:"colour"`
+	Year     int    `json:"year"`
+	Class    string `json:"class"`
+	Capacity int    `json:"capacity"`
+}
+
+// normalizePlate upper-cases a plate and collapses its spacing so the same
+// plate typed two ways is one vehicle.
+func normalizePlate(plate string) string {
+	return strings.Join(strings.Fields(strings.ToUpper(plate)), " ")
+}
+
+// toVehicle validates a vehicle request. Class defaults to economy and
+// capacity to the class's default.
+func (req vehicleRequest) toVehicle(now time.Time) (Vehicle, error) {
+	v := Vehicle{
+		Plate:    normalizePlate(req.Plate),
+		Make:     strings.TrimSpace(req.Make),
+		Model:    strings.TrimSpace(req.Model),
+		Colour:   strings.TrimSpace(req.Colour),
+		Year:     req.Year,
+		Class:    strings.ToLower(strings.TrimSpace(req.Class)),
+		Capacity: req.Capacity,
+		Active:   true,
+	}
+	if v.Class == "" {
+		v.Class = "economy"
+	}
+	defaultCapacity, ok := vehicleClasses[v.Class]
+	switch {
+	case v.Plate == "" || len(v.Plate) > 20:
+		return v, errors.New("plate is required and must be at most 20 characters")
+	case v.Make == "" || v.Model == "" || v.Colour == "":
+		return v, errors.New("make, model and colour are required")
+	case v.Year < oldestVehicleYear || v.Year > now.Year()+1:
+		return v, fmt.Errorf("year must be between %d and %d", oldestVehicleYear, now.Year()+1)
+	case !ok:
+		return v, errors.New("class must be one of boda, economy, comfort or xl")
+	case v.Capacity < 0 || v.Capacity > maxVehicleCapacity:
+		return v, fmt.Errorf("capacity must be between 1 and %d", maxVehicleCapacity)
+	}
+	if v.Capacity == 0 {
+		v.Capacity = defaultCapacity
+	}
+	return v, nil
+}
+
+func respondVehicleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errFleetNotFound), errors.Is(err, errVehicleNotFound), errors.Is(err, errDriverNotFound):
+		respondJSON(w, http.StatusNotFound, errorResponse(err.Error()))
+	case errors.Is(err, errVehicleNotAuthorised):
+		respondJSON(w, http.StatusForbidden, errorResponse(err.Error()))
+	case errors.Is(err, errVehicleInactive), errors.Is(err, errVehicleInUse), errors.Is(err, errDriverBusy),
+		errors.Is(err, errDriverInFleet):
+		respondJSON(w, http.StatusConflict, errorResponse(err.Error()))
+	default:
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			respondJSON(w, http.StatusConflict, errorResponse("a vehicle with this plate is already registered"))
+			return
+		}
+		log.Printf("Vehicle error: %v", err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+	}
+}
+
+const vehicleColumns = `v.id, v.plate, COALESCE(v.make, ''), v.model, COALESCE(v.colour, ''), COALESCE(v.year, 0),
+	v.class, v.capacity, v.fleet_id, COALESCE(v.owner_driver_id, ''), v.active, v.created_at`
+
+func scanVehicle(row pgx.Row, v *Vehicle, extra ...any) error {
+	return row.Scan(append([]any{&v.ID, &v.Plate, &v.Make, &v.Model, &v.Colour, &v.Year,
+		&v.Class, &v.Capacity, &v.FleetID, &v.OwnerDriverID, &v.Active, &v.CreatedAt}, extra...)...)
+}
+
+func insertVehicle(ctx context.Context, tx pgx.Tx, v *Vehicle) error {
+	return tx.QueryRow(ctx,
+		`INSERT INTO vehicles (plate, make, model, colour, year, class, capacity, fleet_id, owner_driver_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''))
+		 RETURNING id, created_at`,
+		v.Plate, v.Make, v.Model, v.Colour, v.Year, v.Class, v.Capacity, v.FleetID, v.OwnerDriverID).
+		Scan(&v.ID, &v.CreatedAt)
+}
+
+// claimVehicle checks that a driver may take a vehicle out: they are
+// authorised for it, it is active, and no other online driver has it. The
+// vehicle row stays locked until tx ends.
+func claimVehicle(ctx context.Context, tx pgx.Tx, driverID string, vehicleID int64) error {
+	var active, authorised bool
+	err := tx.QueryRow(ctx,
+		`SELECT v.active, EXISTS (SELECT 1 FROM vehicle_drivers WHERE vehicle_id = v.id AND driver_id = $2)
+		 FROM vehicles v WHERE v.id = $1
+		 FOR UPDATE`,
+		vehicleID, driverID).Scan(&active, &authorised)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !authorised) {
+		return errVehicleNotAuthorised
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load vehicle: %w", err)
+	}
+	if !active {
+		return errVehicleInactive
+	}
+
+	var inUse bool
+	if err := tx.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM drivers WHERE vehicle_id = $1 AND driver_id <> $2 AND state <> 'offline')`,
+		vehicleID, driverID).Scan(&inUse); err != nil {
+		return fmt.Errorf("failed to check vehicle: %w", err)
+	}
+	if inUse {
+		return errVehicleInUse
+	}
+	return nil
+}
+
+func listDriverVehiclesHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	rows, err := dbPool.Query(r.Context(),
+		`SELECT `+vehicleColumns+`, COALESCE(d.vehicle_id = v.id, false)
+		 FROM vehicle_drivers vd
+		 JOIN vehicles v ON v.id = vd.vehicle_id
+		 LEFT JOIN drivers d ON d.driver_id = vd.driver_id
+		 WHERE vd.driver_id = $1
+		 ORDER BY v.plate`,
+		claims.Username)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	vehicles, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Vehicle, error) {
+		var v Vehicle
+		err := scanVehicle(row, &v, &v.Current)
+		return v, err
+	})
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if vehicles == nil {
+		vehicles = []Vehicle{}
+	}
+	respondJSON(w, http.StatusOK, successResponse(vehicles))
+}
+
+// addDriverVehicleHandler registers a vehicle the driver owns. A driver
+// without a current vehicle starts driving it straight away.
+func addDriverVehicleHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	var req vehicleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	v, err := req.toVehicle(time.Now())
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	v.OwnerDriverID = claims.Username
+
+	ctx := r.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var current *int64
+	err = tx.QueryRow(ctx,
+		`SELECT vehicle_id FROM drivers WHERE driver_id = $1 FOR UPDATE`,
+		claims.Username).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondVehicleError(w, errDriverNotFound)
+		return
+	}
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if err := insertVehicle(ctx, tx, &v); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO vehicle_drivers (vehicle_id, driver_id) VALUES ($1, $2)`,
+		v.ID, claims.Username); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if current == nil {
+		if _, err := tx.Exec(ctx,
+			`UPDATE drivers SET vehicle_id = $1 WHERE driver_id = $2`,
+			v.ID, claims.Username); err != nil {
+			respondVehicleError(w, err)
+			return
+		}
+		v.Current = true
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, successResponse(v))
+}
+
+// selectVehicleHandler switches the vehicle a driver is driving. Drivers
+// switch between rides, not during one.
+func selectVehicleHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	var req struct {
+		VehicleID int64 `json:"vehicle_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.VehicleID <= 0 {
+		respondJSON(w, http.StatusBadRequest, errorResponse("vehicle_id is required"))
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var state string
+	err = tx.QueryRow(ctx,
+		`SELECT state FROM drivers WHERE driver_id = $1 FOR UPDATE`,
+		claims.Username).Scan(&state)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondVehicleError(w, errDriverNotFound)
+		return
+	}
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if state != driverStateOffline && state != driverStateIdle {
+		respondVehicleError(w, errDriverBusy)
+		return
+	}
+	if err := claimVehicle(ctx, tx, claims.Username, req.VehicleID); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE drivers SET vehicle_id = $1 WHERE driver_id = $2`,
+		req.VehicleID, claims.Username); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+
+	var v Vehicle
+	if err := scanVehicle(dbPool.QueryRow(ctx,
+		`SELECT `+vehicleColumns+` FROM vehicles v WHERE v.id = $1`, req.VehicleID), &v); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	v.Current = true
+	respondJSON(w, http.StatusOK, successResponse(v))
+}
+
+// fleetOwnerClaims returns the caller's claims if they are a fleet owner.
+func fleetOwnerClaims(w http.ResponseWriter, r *http.Request) (*Claims, bool) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return nil, false
+	}
+	if claims.Role != "fleet_owner" {
+		respondJSON(w, http.StatusForbidden, errorResponse("fleet owners only"))
+		return nil, false
+	}
+	return claims, true
+}
+
+func ownerFleetID(ctx context.Context, q queryRower, ownerUserID int) (int64, error) {
+	var id int64
+	err := q.QueryRow(ctx, `SELECT id FROM fleets WHERE owner_user_id = $1`, ownerUserID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errFleetNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load fleet: %w", err)
+	}
+	return id, nil
+}
+
+func createFleetHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := fleetOwnerClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		respondJSON(w, http.StatusBadRequest, errorResponse("name is required and must be at most 100 characters"))
+		return
+	}
+
+	f := Fleet{Name: req.Name, OwnerUserID: claims.UserID, Vehicles: []Vehicle{}, Drivers: []FleetDriver{}}
+	err := dbPool.QueryRow(r.Context(),
+		`INSERT INTO fleets (name, owner_user_id) VALUES ($1, $2) RETURNING id, created_at`,
+		f.Name, f.OwnerUserID).Scan(&f.ID, &f.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		respondJSON(w, http.StatusConflict, errorResponse("you already have a fleet"))
+		return
+	}
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, successResponse(f))
+}
+
+func getFleetHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := fleetOwnerClaims(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	f := Fleet{OwnerUserID: claims.UserID}
+	err := dbPool.QueryRow(ctx,
+		`SELECT id, name, created_at FROM fleets WHERE owner_user_id = $1`,
+		claims.UserID).Scan(&f.ID, &f.Name, &f.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondVehicleError(w, errFleetNotFound)
+		return
+	}
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+
+	rows, err := dbPool.Query(ctx,
+		`SELECT `+vehicleColumns+`,
+		        COALESCE((SELECT array_agg(driver_id ORDER BY driver_id) FROM vehicle_drivers WHERE vehicle_id = v.id), '{}'),
+		        COALESCE((SELECT driver_id FROM drivers WHERE vehicle_id = v.id AND state <> 'offline' LIMIT 1), '')
+		 FROM vehicles v WHERE v.fleet_id = $1
+		 ORDER BY v.plate`,
+		f.ID)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	f.Vehicles, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Vehicle, error) {
+		var v Vehicle
+		err := scanVehicle(row, &v, &v.Drivers, &v.InUseBy)
+		return v, err
+	})
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+
+	rows, err = dbPool.Query(ctx,
+		`SELECT driver_id, COALESCE(name, ''), state, vehicle_id FROM drivers WHERE fleet_id = $1 ORDER BY driver_id`,
+		f.ID)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	f.Drivers, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (FleetDriver, error) {
+		var d FleetDriver
+		err := row.Scan(&d.DriverID, &d.Name, &d.State, &d.VehicleID)
+		return d, err
+	})
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if f.Vehicles == nil {
+		f.Vehicles = []Vehicle{}
+	}
+	if f.Drivers == nil {
+		f.Drivers = []FleetDriver{}
+	}
+	respondJSON(w, http.StatusOK, successResponse(f))
+}
+
+func addFleetVehicleHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := fleetOwnerClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var req vehicleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	v, err := req.toVehicle(time.Now())
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	fleetID, err := ownerFleetID(ctx, tx, claims.UserID)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	v.FleetID = &fleetID
+	if err := insertVehicle(ctx, tx, &v); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, successResponse(v))
+}
+
+// updateFleetVehicleHandler activates or retires a fleet vehicle. A vehicle
+// in use stays with its driver until they go offline or switch.
+func updateFleetVehicleHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := fleetOwnerClaims(w, r)
+	if !ok {
+		return
+	}
+	vehicleID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondVehicleError(w, errVehicleNotFound)
+		return
+	}
+
+	var req struct {
+		Active *bool `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Active == nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("active is required"))
+		return
+	}
+
+	var v Vehicle
+	err = scanVehicle(dbPool.QueryRow(r.Context(),
+		`UPDATE vehicles v SET active = $1
+		 FROM fleets f
+		 WHERE v.id = $2 AND f.id = v.fleet_id AND f.owner_user_id = $3
+		 RETURNING `+vehicleColumns,
+		*req.Active, vehicleID, claims.UserID), &v)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondVehicleError(w, errVehicleNotFound)
+		return
+	}
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(v))
+}
+
+// addFleetDriverHandler brings an approved driver into the fleet. They can
+// then be authorised for the fleet's vehicles.
+func addFleetDriverHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := fleetOwnerClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		DriverID string `json:"driver_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DriverID == "" {
+		respondJSON(w, http.StatusBadRequest, errorResponse("driver_id is required"))
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	fleetID, err := ownerFleetID(ctx, tx, claims.UserID)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	var current *int64
+	err = tx.QueryRow(ctx,
+		`SELECT fleet_id FROM drivers WHERE driver_id = $1 AND approved_at IS NOT NULL FOR UPDATE`,
+		req.DriverID).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondVehicleError(w, errDriverNotFound)
+		return
+	}
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if current != nil && *current != fleetID {
+		respondVehicleError(w, errDriverInFleet)
+		return
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE drivers SET fleet_id = $1 WHERE driver_id = $2`,
+		fleetID, req.DriverID); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"driver_id": req.DriverID,
+		"fleet_id":  fleetID,
+	}))
+}
+
+// removeFleetDriverHandler takes a driver out of the fleet along with their
+// authorisations for its vehicles.
+func removeFleetDriverHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := fleetOwnerClaims(w, r)
+	if !ok {
+		return
+	}
+	driverID := mux.Vars(r)["id"]
+	ctx := r.Context()
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	fleetID, err := ownerFleetID(ctx, tx, claims.UserID)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if err := revokeFleetVehicles(ctx, tx, fleetID, driverID, 0); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	tag, err := tx.Exec(ctx,
+		`UPDATE drivers SET fleet_id = NULL WHERE driver_id = $1 AND fleet_id = $2`,
+		driverID, fleetID)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		respondVehicleError(w, errDriverNotFound)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(map[string]string{"message": "driver removed from fleet"}))
+}
+
+// revokeFleetVehicles removes a driver's authorisation for one fleet vehicle,
+// or for all of them when vehicleID is 0. A driver online in one of those
+// vehicles has to go offline first; an offline driver loses it as their
+// current vehicle.
+func revokeFleetVehicles(ctx context.Context, tx pgx.Tx, fleetID int64, driverID string, vehicleID int64) error {
+	var state string
+	var current *int64
+	err := tx.QueryRow(ctx,
+		`SELECT d.state, v.id
+		 FROM drivers d
+		 LEFT JOIN vehicles v ON v.id = d.vehicle_id AND v.fleet_id = $2 AND ($3::bigint = 0 OR v.id = $3)
+		 WHERE d.driver_id = $1
+		 FOR UPDATE OF d`,
+		driverID, fleetID, vehicleID).Scan(&state, &current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errDriverNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load driver: %w", err)
+	}
+	if current != nil {
+		if state != driverStateOffline {
+			return errDriverBusy
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE drivers SET vehicle_id = NULL WHERE driver_id = $1`,
+			driverID); err != nil {
+			return fmt.Errorf("failed to clear vehicle: %w", err)
+		}
+	}
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM vehicle_drivers vd
+		 USING vehicles v
+		 WHERE v.id = vd.vehicle_id AND vd.driver_id = $1 AND v.fleet_id = $2 AND ($3::bigint = 0 OR v.id = $3)`,
+		driverID, fleetID, vehicleID); err != nil {
+		return fmt.Errorf("failed to revoke vehicle: %w", err)
+	}
+	return nil
+}
+
+// fleetVehicleDriver parses the vehicle and driver of a fleet authorisation
+// route and checks both belong to the caller's fleet.
+func fleetVehicleDriver(ctx context.Context, tx pgx.Tx, r *http.Request, ownerUserID int) (int64, int64, string, error) {
+	fleetID, err := ownerFleetID(ctx, tx, ownerUserID)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	vars := mux.Vars(r)
+	vehicleID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		return 0, 0, "", errVehicleNotFound
+	}
+	driverID := vars["driver_id"]
+
+	var vehicleOK, driverOK bool
+	if err := tx.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM vehicles WHERE id = $1 AND fleet_id = $3),
+		        EXISTS (SELECT 1 FROM drivers WHERE driver_id = $2 AND fleet_id = $3)`,
+		vehicleID, driverID, fleetID).Scan(&vehicleOK, &driverOK); err != nil {
+		return 0, 0, "", fmt.Errorf("failed to load fleet: %w", err)
+	}
+	if !vehicleOK {
+		return 0, 0, "", errVehicleNotFound
+	}
+	if !driverOK {
+		return 0, 0, "", errDriverNotFound
+	}
+	return fleetID, vehicleID, driverID, nil
+}
+
+func authoriseFleetDriverHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := fleetOwnerClaims(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, vehicleID, driverID, err := fleetVehicleDriver(ctx, tx, r, claims.UserID)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO vehicle_drivers (vehicle_id, driver_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		vehicleID, driverID); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"vehicle_id": vehicleID,
+		"driver_id":  driverID,
+	}))
+}
+
+func revokeFleetDriverHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := fleetOwnerClaims(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	fleetID, vehicleID, driverID, err := fleetVehicleDriver(ctx, tx, r, claims.UserID)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if err := revokeFleetVehicles(ctx, tx, fleetID, driverID, vehicleID); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, successResponse(map[string]string{"message": "authorisation revoked"}))
+}
+
+// fleetTripsHandler lists the latest trips driven in the fleet's vehicles,
+// optionally for one vehicle or driver.
+func fleetTripsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := fleetOwnerClaims(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	limit := fleetTripsLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondJSON(w, http.StatusBadRequest, errorResponse("limit must be a positive integer"))
+			return
+		}
+		limit = min(n, maxFleetTripsLimit)
+	}
+	var vehicleID int64
+	if v := q.Get("vehicle_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, errorResponse("vehicle_id must be an integer"))
+			return
+		}
+		vehicleID = n
+	}
+
+	fleetID, err := ownerFleetID(ctx, dbPool, claims.UserID)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	rows, err := dbPool.Query(ctx,
+		`SELECT r.id, r.driver_id, v.id, v.plate, r.status, r.currency,
+		        COALESCE(r.final_fare, r.price_estimate, 0), e.net_amount, r.created_at,
+		        COALESCE(r.completed_at, r.cancelled_at)
+		 FROM rides r
+		 JOIN vehicles v ON v.id = r.vehicle_id
+		 LEFT JOIN driver_earnings e ON e.ride_id = r.id
+		 WHERE v.fleet_id = $1 AND ($2::bigint = 0 OR v.id = $2) AND ($3 = '' OR r.driver_id = $3)
+		 ORDER BY r.created_at DESC
+		 LIMIT $4`,
+		fleetID, vehicleID, q.Get("driver_id"), limit)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	trips, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (FleetTrip, error) {
+		var t FleetTrip
+		err := row.Scan(&t.RideID, &t.DriverID, &t.VehicleID, &t.Plate, &t.Status, &t.Currency,
+			&t.Fare, &t.Net, &t.CreatedAt, &t.EndedAt)
+		return t, err
+	})
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	if trips == nil {
+		trips = []FleetTrip{}
+	}
+	respondJSON(w, http.StatusOK, successResponse(trips))
+}
+
+// fleetEarningsHandler totals the earnings of trips driven in the fleet's
+// vehicles over the last ?days= days.
+func fleetEarningsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := fleetOwnerClaims(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	days := defaultEarningsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxFleetEarningsDays {
+			respondJSON(w, http.StatusBadRequest,
+				errorResponse(fmt.Sprintf("days must be between 1 and %d", maxFleetEarningsDays)))
+			return
+		}
+		days = n
+	}
+
+	fleetID, err := ownerFleetID(ctx, dbPool, claims.UserID)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	earnings := FleetEarnings{Since: time.Now().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))}
+
+	rows, err := dbPool.Query(ctx,
+		`SELECT e.driver_id, v.id, v.plate, e.currency, COUNT(*),
+		        SUM(e.gross_fare)::bigint, SUM(e.commission)::bigint, SUM(e.net_amount)::bigint, SUM(e.tip)::bigint
+		 FROM driver_earnings e
+		 JOIN rides r ON r.id = e.ride_id
+		 JOIN vehicles v ON v.id = r.vehicle_id
+		 WHERE v.fleet_id = $1 AND e.created_at >= $2
+		 GROUP BY e.driver_id, v.id, v.plate, e.currency`,
+		fleetID, earnings.Since)
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+	totals, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (FleetEarningsTotals, error) {
+		var t FleetEarningsTotals
+		err := row.Scan(&t.DriverID, &t.VehicleID, &t.Plate, &t.Currency, &t.Trips,
+			&t.Gross, &t.Commission, &t.Net, &t.Tips)
+		return t, err
+	})
+	if err != nil {
+		respondVehicleError(w, err)
+		return
+	}
+
+	earnings.ByDriver = rollupFleetEarnings(totals, func(t FleetEarningsTotals) FleetEarningsTotals {
+		return FleetEarningsTotals{DriverID: t.DriverID, Currency: t.Currency}
+	})
+	earnings.ByVehicle = rollupFleetEarnings(totals, func(t FleetEarningsTotals) FleetEarningsTotals {
+		return FleetEarningsTotals{VehicleID: t.VehicleID, Plate: t.Plate, Currency: t.Currency}
+	})
+	respondJSON(w, http.StatusOK, successResponse(earnings))
+}
+
+// rollupFleetEarnings sums per driver-and-vehicle totals into the groups
+// returned by key, which keeps only the identifying fields of a total. The
+// groups come back in the order they were first seen.
+func rollupFleetEarnings(totals []FleetEarningsTotals, key func(FleetEarningsTotals) FleetEarningsTotals) []FleetEarningsTotals {
+	out := []FleetEarningsTotals{}
+	index := map[FleetEarningsTotals]int{}
+	for _, t := range totals {
+		k := key(t)
+		i, ok := index[k]
+		if !ok {
+			i = len(out)
+			index[k] = i
+			out = append(out, k)
+		}
+		out[i].Trips += t.Trips
+		out[i].Gross += t.Gross
+		out[i].Commission += t.Commission
+		out[i].Net += t.Net
+		out[i].Tips += t.Tips
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVehicleRequestToVehicle(t *testing.T) {
+	now := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	valid := vehicleRequest{Plate: " uba  123x ", Make: "Toyota", Model: "Premio", Colour: "White", Year: 2015}
+
+	v, err := valid.toVehicle(now)
+	if err != nil {
+		t.Fatalf("Expected a valid vehicle, got %v", err)
+	}
+	if v.Plate != "UBA 123X" || v.Class != "economy" || v.Capacity != 4 || !v.Active {
+		t.Errorf("Expected a normalised economy vehicle with 4 seats, got %+v", v)
+	}
+
+	boda := valid
+	boda.Class = "BODA"
+	if v, err := boda.toVehicle(now); err != nil || v.Class != "boda" || v.Capacity != 1 {
+		t.Errorf("Expected a one-seat boda, got %+v (%v)", v, err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*vehicleRequest)
+	}{
+		{"missing plate", func(r *vehicleRequest) { r.Plate = "  " }},
+		{"missing colour", func(r *vehicleRequest) { r.Colour = "" }},
+		{"too old", func(r *vehicleRequest) { r.Year = 1975 }},
+		{"future year", func(r *vehicleRequest) { r.Year = 2028 }},
+		{"unknown class", func(r *vehicleRequest) { r.Class = "limo" }},
+		{"too many seats", func(r *vehicleRequest) { r.Capacity = 40 }},
+	}
+	for _, tt := range tests {
+		req := valid
+		tt.modify(&req)
+		if _, err := req.toVehicle(now); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestRollupFleetEarnings(t *testing.T) {
+	totals := []FleetEarningsTotals{
+		{DriverID: "sam", VehicleID: 1, Plate: "UBA 123X", Currency: "UGX", Trips: 3, Gross: 30000, Commission: 6000, Net: 24000},
+		{DriverID: "ann", VehicleID: 1, Plate: "UBA 123X", Currency: "UGX", Trips: 1, Gross: 10000, Commission: 2000, Net: 8000, Tips: 1000},
+		{DriverID: "sam", VehicleID: 2, Plate: "UBB 456Y", Currency: "UGX", Trips: 2, Gross: 20000, Commission: 4000, Net: 16000},
+	}
+
+	byDriver := rollupFleetEarnings(totals, func(t FleetEarningsTotals) FleetEarningsTotals {
+		return FleetEarningsTotals{DriverID: t.DriverID, Currency: t.Currency}
+	})
+	want := []FleetEarningsTotals{
+		{DriverID: "sam", Currency: "UGX", Trips: 5, Gross: 50000, Commission: 10000, Net: 40000},
+		{DriverID: "ann", Currency: "UGX", Trips: 1, Gross: 10000, Commission: 2000, Net: 8000, Tips: 1000},
+	}
+	if !reflect.DeepEqual(byDriver, want) {
+		t.Errorf("Expected %+v, got %+v", want, byDriver)
+	}
+
+	byVehicle := rollupFleetEarnings(totals, func(t FleetEarningsTotals) FleetEarningsTotals {
+		return FleetEarningsTotals{VehicleID: t.VehicleID, Plate: t.Plate, Currency: t.Currency}
+	})
+	if len(byVehicle) != 2 || byVehicle[0].Trips != 4 || byVehicle[0].Tips != 1000 || byVehicle[1].Net != 16000 {
+		t.Errorf("Unexpected per-vehicle totals: %+v", byVehicle)
+	}
+}
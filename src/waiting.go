@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultWaitFreeWindow = 3 * time.Minute
+	waitingMonitorPeriod  = 30 * time.Second
+)
+
+// freeWaitingWindow is how long a driver waits at pickup before the rider
+// starts paying for waiting time.
+func freeWaitingWindow() time.Duration {
+	return envDuration("WAIT_FREE_WINDOW", defaultWaitFreeWindow)
+}
+
+// waitingCharge prices the time between the driver arriving and end. Waiting
+// past the free window is billed per started minute and rounded to the
+// currency's step.
+func waitingCharge(arrivedAt, end time.Time, free time.Duration, perMin Money, cur Currency) Money {
+	paid := end.Sub(arrivedAt) - free
+	if paid <= 0 || perMin <= 0 {
+		return 0
+	}
+	return cur.RoundFare(perMin.scale(math.Ceil(paid.Minutes())))
+}
+
+// rideWaitingCharge applies the waiting rate quoted when the ride was
+// requested to a ride whose driver has arrived.
+func rideWaitingCharge(ctx context.Context, tx pgx.Tx, ride *RideStatus, end time.Time) (Money, error) {
+	if ride.ArrivedAt == nil {
+		return 0, nil
+	}
+	var perMin Money
+	if err := tx.QueryRow(ctx,
+		`SELECT COALESCE((fare_breakdown->>'wait_per_min')::bigint, 0) FROM rides WHERE id = $1`,
+		ride.ID).Scan(&perMin); err != nil {
+		return 0, fmt.Errorf("failed to load waiting rate: %w", err)
+	}
+	return waitingCharge(*ride.ArrivedAt, end, freeWaitingWindow(), perMin, currencyFor(ride.Currency)), nil
+}
+
+func arriveRideHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	ride, err := arriveRide(r.Context(), mux.Vars(r)["id"], claims.Username)
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+	publishRideStatus(ride)
+
+	free := freeWaitingWindow()
+	if err := notifyRider(r.Context(), ride.RiderID, ride.ID, "driver_arrived",
+		fmt.Sprintf("Your driver has arrived. Waiting is free for %s.", free)); err != nil {
+		log.Printf("Failed to notify rider %d of arrival on ride %s: %v", ride.RiderID, ride.ID, err)
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(ride))
+}
+
+// arriveRide records the assigned driver reaching the pickup point, which
+// starts the waiting clock.
+func arriveRide(ctx context.Context, rideID, driverID string) (*RideStatus, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	if ride.DriverID != driverID {
+		return nil, errRideNotFound
+	}
+	if ride.Status != rideStatusAccepted {
+		return nil, errInvalidRideTransition
+	}
+
+	if err := tx.QueryRow(ctx,
+		`UPDATE rides SET status = 'arrived', arrived_at = NOW(), updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING arrived_at, updated_at`,
+		ride.ID).Scan(&ride.ArrivedAt, &ride.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to mark ride arrived: %w", err)
+	}
+	ride.Status = rideStatusArrived
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return ride, nil
+}
+
+func startRideHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+	if claims.Role != "driver" {
+		respondJSON(w, http.StatusForbidden, errorResponse("drivers only"))
+		return
+	}
+
+	ride, err := startRide(r.Context(), mux.Vars(r)["id"], claims.Username)
+	if err != nil {
+		respondRideError(w, err)
+		return
+	}
+	publishRideStatus(ride)
+
+	respondJSON(w, http.StatusOK, successResponse(ride))
+}
+
+// startRide picks the rider up. Any paid waiting up to this point is fixed as
+// the ride's waiting charge and added to the fare on completion.
+func startRide(ctx context.Context, rideID, driverID string) (*RideStatus, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, errors.New("failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	ride, err := lockRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	if ride.DriverID != driverID {
+		return nil, errRideNotFound
+	}
+	switch ride.Status {
+	case rideStatusAccepted, rideStatusArrived:
+	default:
+		return nil, errInvalidRideTransition
+	}
+
+	ride.WaitingCharge, err = rideWaitingCharge(ctx, tx, ride, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow(ctx,
+		`UPDATE rides SET status = 'in_progress', started_at = NOW(), waiting_charge = $1, updated_at = NOW()
+		 WHERE id = $2
+		 RETURNING started_at, updated_at`,
+		ride.WaitingCharge, ride.ID).Scan(&ride.StartedAt, &ride.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to start ride: %w", err)
+	}
+	ride.Status = rideStatusInProgress
+	if err := setDriverState(ctx, tx, driverID, driverStateOnTrip); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.New("failed to commit transaction")
+	}
+	return ride, nil
+}
+
+// startWaitingMonitor tells riders when their free waiting time has run out.
+func startWaitingMonitor() {
+	go func() {
+		ticker := time.NewTicker(waitingMonitorPeriod)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := notifyPaidWaiting(context.Background()); err != nil {
+				log.Printf("Waiting monitor failed: %v", err)
+			}
+		}
+	}()
+}
+
+func notifyPaidWaiting(ctx context.Context) error {
+	rows, err := dbPool.Query(ctx,
+		`UPDATE rides SET paid_waiting_notified_at = NOW()
+		 WHERE status = 'arrived' AND paid_waiting_notified_at IS NULL
+		   AND arrived_at <= NOW() - make_interval(secs => $1)
+		 RETURNING id, rider_id, currency, COALESCE((fare_breakdown->>'wait_per_min')::bigint, 0)`,
+		freeWaitingWindow().Seconds())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type waitingRide struct {
+		ID       string
+		RiderID  int
+		Currency string
+		PerMin   Money
+	}
+	var due []waitingRide
+	for rows.Next() {
+		var wr waitingRide
+		if err := rows.Scan(&wr.ID, &wr.RiderID, &wr.Currency, &wr.PerMin); err != nil {
+			return err
+		}
+		due = append(due, wr)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, wr := range due {
+		msg := fmt.Sprintf("Your free waiting time is over. Waiting is now charged at %s per minute.",
+			currencyFor(wr.Currency).Format(wr.PerMin))
+		if err := notifyRider(ctx, wr.RiderID, wr.ID, "paid_waiting_started", msg); err != nil {
+			log.Printf("Failed to notify rider %d of paid waiting on ride %s: %v", wr.RiderID, wr.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitingCharge(t *testing.T) {
+	arrived := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	ugx := currencyFor("UGX")
+
+	cases := []struct {
+		name   string
+		waited time.Duration
+		want   Money
+	}{
+		{"within free window", 2 * time.Minute, 0},
+		{"exactly free window", 3 * time.Minute, 0},
+		{"partial minute counts", 3*time.Minute + 10*time.Second, 200},
+		{"five paid minutes", 8 * time.Minute, 1000},
+	}
+	for _, c := range cases {
+		got := waitingCharge(arrived, arrived.Add(c.waited), 3*time.Minute, 200, ugx)
+		if got != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, got)
+		}
+	}
+
+	if got := waitingCharge(arrived, arrived.Add(10*time.Minute), 3*time.Minute, 0, ugx); got != 0 {
+		t.Errorf("No waiting rate should mean no charge, got %d", got)
+	}
+}
+
+func TestCheckCompletable(t *testing.T) {
+	started := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	arrived := started.Add(-10 * time.Minute)
+
+	// A rider who never boarded is not billed the quoted fare and waiting;
+	// the ride has to go through the no-show cancellation instead.
+	noShow := &RideStatus{Status: rideStatusArrived, Price: 15000, WaitingCharge: 1200, ArrivedAt: &arrived}
+	if err := checkCompletable(noShow); err != errInvalidRideTransition {
+		t.Errorf("Expected an arrived ride to be refused, got %v", err)
+	}
+	for _, status := range []string{rideStatusRequested, rideStatusAccepted, rideStatusCompleted, rideStatusCancelled} {
+		if err := checkCompletable(&RideStatus{Status: status}); err != errInvalidRideTransition {
+			t.Errorf("Expected a %s ride to be refused, got %v", status, err)
+		}
+	}
+	if err := checkCompletable(&RideStatus{Status: rideStatusInProgress, StartedAt: &started}); err != nil {
+		t.Errorf("Expected a started ride to complete, got %v", err)
+	}
+}
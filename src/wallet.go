@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type Wallet struct {
+	RiderID      int           `json:"rider_id"`
+	Currency     string        `json:"currency"`
+	Balance      Money         `json:"balance"`
+	Held         Money         `json:"held"`
+	Available    Money         `json:"available"`
+	Holds        []WalletHold  `json:"holds"`
+	Transactions []LedgerEntry `json:"transactions"`
+}
+
+type WalletHold struct {
+	RideID    string    `json:"ride_id"`
+	Amount    Money     `json:"amount"`
+	Currency  string    `json:"currency"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const walletHistoryLimit = 50
+
+var (
+	errInsufficientWalletFunds = errors.New("insufficient wallet balance")
+	errNoWalletHold            = errors.New("no wallet hold for ride")
+)
+
+// placeWalletHold reserves amount of the rider's available balance for a ride.
+// The conditional UPDATE makes the balance check and the reservation a single
+// atomic step, so concurrent requests cannot reserve the same funds twice.
+func placeWalletHold(ctx context.Context, tx pgx.Tx, riderID int, rideID string, amount Money, currency string) error {
+	tag, err := tx.Exec(ctx,
+		`UPDATE wallets SET held = held + $1, updated_at = NOW()
+		 WHERE rider_id = $2 AND currency = $3 AND balance - held >= $1`,
+		amount, riderID, currency)
+	if err != nil {
+		return fmt.Errorf("failed to place wallet hold: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return walletUpdateError(ctx, tx, riderID, currency)
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO wallet_holds (ride_id, rider_id, amount, currency, status)
+		 VALUES ($1, $2, $3, $4, 'held')`,
+		rideID, riderID, amount, currency)
+	if err != nil {
+		return fmt.Errorf("failed to record wallet hold: %w", err)
+	}
+	return nil
+}
+
+// lockWalletHold locks the hold row for a ride and returns it along with the
+// owning rider. Callers must hold the lock before touching the wallet row.
+func lockWalletHold(ctx context.Context, tx pgx.Tx, rideID string) (int, *WalletHold, error) {
+	var riderID int
+	hold := WalletHold{RideID: rideID}
+	err := tx.QueryRow(ctx,
+		`SELECT rider_id, amount, currency, status, created_at
+		 FROM wallet_holds WHERE ride_id = $1
+		 FOR UPDATE`,
+		rideID).Scan(&riderID, &hold.Amount, &hold.Currency, &hold.Status, &hold.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil, errNoWalletHold
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to load wallet hold: %w", err)
+	}
+	return riderID, &hold, nil
+}
+
+// captureWalletHold settles a ride's hold at the final amount. The ride may
+// spend its own hold plus any free balance, but never funds reserved for other
+// rides. It returns the amount actually charged; any remainder is left for the
+// caller to collect another way.
+func captureWalletHold(ctx context.Context, tx pgx.Tx, rideID string, amount Money) (Money, error) {
+	riderID, hold, err := lockWalletHold(ctx, tx, rideID)
+	if err != nil {
+		return 0, err
+	}
+	if hold.Status != "held" {
+		return 0, fmt.Errorf("wallet hold already %s", hold.Status)
+	}
+
+	var balance, held Money
+	err = tx.QueryRow(ctx,
+		`SELECT balance, held FROM wallets WHERE rider_id = $1 FOR UPDATE`,
+		riderID).Scan(&balance, &held)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock wallet: %w", err)
+	}
+
+	charged := walletCapture(balance, held, hold.Amount, amount)
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE wallets SET balance = balance - $1, held = held - $2, updated_at = NOW()
+		 WHERE rider_id = $3`,
+		charged, hold.Amount, riderID); err != nil {
+		return 0, fmt.Errorf("failed to capture wallet hold: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE wallet_holds SET status = 'captured', captured_amount = $1, updated_at = NOW()
+		 WHERE ride_id = $2`,
+		charged, rideID); err != nil {
+		return 0, fmt.Errorf("failed to update wallet hold: %w", err)
+	}
+
+	if charged > 0 {
+		if err := recordLedgerEntry(ctx, tx, walletAccount(riderID), "ride_payment", rideID, -charged, hold.Currency, ""); err != nil {
+			return 0, err
+		}
+	}
+	return charged, nil
+}
+
+// walletCapture is how much of amount a ride holding hold can take from a
+// wallet: its own hold plus free balance, but never funds held for other
+// rides.
+func walletCapture(balance, held, hold, amount Money) Money {
+	spendable := balance - (held - hold)
+	return max(0, min(amount, spendable))
+}
+
+// releaseWalletHold returns a ride's held funds to the rider's available
+// balance. Releasing a hold that is already settled is a no-op.
+func releaseWalletHold(ctx context.Context, tx pgx.Tx, rideID string) error {
+	riderID, hold, err := lockWalletHold(ctx, tx, rideID)
+	if err != nil {
+		return err
+	}
+	if hold.Status != "held" {
+		return nil
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE wallets SET held = held - $1, updated_at = NOW() WHERE rider_id = $2`,
+		hold.Amount, riderID); err != nil {
+		return fmt.Errorf("failed to release wallet hold: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE wallet_holds SET status = 'released', updated_at = NOW() WHERE ride_id = $1`,
+		rideID)
+	return err
+}
+
+// walletUpdateError explains why a conditional wallet update matched no row:
+// either the wallet holds another currency or it lacks the funds.
+func walletUpdateError(ctx context.Context, q queryRower, riderID int, currency string) error {
+	current, err := walletCurrency(ctx, q, riderID)
+	if err == nil && current != "" && current != currency {
+		return errCurrencyMismatch
+	}
+	return errInsufficientWalletFunds
+}
+
+// walletCurrency returns the currency of the rider's wallet, or "" if the
+// rider has no wallet yet.
+func walletCurrency(ctx context.Context, q queryRower, riderID int) (string, error) {
+	var currency string
+	err := q.QueryRow(ctx,
+		`SELECT currency FROM wallets WHERE rider_id = $1`,
+		riderID).Scan(&currency)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return currency, err
+}
+
+// creditWallet adds funds to a rider's wallet, creating it in currency on
+// first use. A wallet only ever holds one currency.
+func creditWallet(ctx context.Context, tx pgx.Tx, riderID int, amount Money, currency, entryType, rideID, reference string) error {
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO wallets (rider_id, balance, currency) VALUES ($1, $2, $3)
+		 ON CONFLICT (rider_id) DO UPDATE
+		 SET balance = wallets.balance + EXCLUDED.balance, updated_at = NOW()
+		 WHERE wallets.currency = EXCLUDED.currency`,
+		riderID, amount, currency)
+	if err != nil {
+		return fmt.Errorf("failed to credit wallet: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errCurrencyMismatch
+	}
+	return recordLedgerEntry(ctx, tx, walletAccount(riderID), entryType, rideID, amount, currency, reference)
+}
+
+// walletSpendable locks the rider's wallet and returns what is not held for
+// rides, or zero if they have no wallet in currency.
+func walletSpendable(ctx context.Context, tx pgx.Tx, riderID int, currency string) (Money, error) {
+	var spendable Money
+	err := tx.QueryRow(ctx,
+		`SELECT balance - held FROM wallets WHERE rider_id = $1 AND currency = $2 FOR UPDATE`,
+		riderID, currency).Scan(&spendable)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock wallet: %w", err)
+	}
+	return max(0, spendable), nil
+}
+
+// debitWallet takes amount from the rider's available balance, leaving funds
+// held for other rides untouched.
+func debitWallet(ctx context.Context, tx pgx.Tx, riderID int, amount Money, currency, entryType, rideID, reference string) error {
+	tag, err := tx.Exec(ctx,
+		`UPDATE wallets SET balance = balance - $1, updated_at = NOW()
+		 WHERE rider_id = $2 AND currency = $3 AND balance - held >= $1`,
+		amount, riderID, currency)
+	if err != nil {
+		return fmt.Errorf("failed to debit wallet: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return walletUpdateError(ctx, tx, riderID, currency)
+	}
+	return recordLedgerEntry(ctx, tx, walletAccount(riderID), entryType, rideID, -amount, currency, reference)
+}
+
+func getWallet(ctx context.Context, riderID int) (*Wallet, error) {
+	wallet := &Wallet{RiderID: riderID, Currency: defaultCurrency, Holds: []WalletHold{}}
+	err := dbPool.QueryRow(ctx,
+		`SELECT currency, balance, held FROM wallets WHERE rider_id = $1`,
+		riderID).Scan(&wallet.Currency, &wallet.Balance, &wallet.Held)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	wallet.Available = wallet.Balance - wallet.Held
+
+	rows, err := dbPool.Query(ctx,
+		`SELECT ride_id, amount, currency, status, created_at
+		 FROM wallet_holds
+		 WHERE rider_id = $1 AND status = 'held'
+		 ORDER BY created_at DESC`,
+		riderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h WalletHold
+		if err := rows.Scan(&h.RideID, &h.Amount, &h.Currency, &h.Status, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		wallet.Holds = append(wallet.Holds, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	wallet.Transactions, err = listLedgerEntries(ctx, walletAccount(riderID), walletHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	return wallet, nil
+}
+
+func getWalletHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	wallet, err := getWallet(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Failed to load wallet for rider %d: %v", claims.UserID, err)
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(wallet))
+}
+
+func topUpWalletHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		Amount   Money  `json:"amount"`
+		Currency string `json:"currency"`
+		Provider string `json:"provider"`
+		Phone    string `json:"phone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+	if req.Amount <= 0 {
+		respondJSON(w, http.StatusBadRequest, errorResponse("amount must be positive"))
+		return
+	}
+
+	// Top-ups are always in the wallet's currency once it exists
+	current, err := walletCurrency(r.Context(), dbPool, claims.UserID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = current
+	}
+	cur, err := lookupCurrency(req.Currency)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if current != "" && cur.Code != current {
+		respondJSON(w, http.StatusConflict, errorResponse(errCurrencyMismatch.Error()))
+		return
+	}
+
+	provider, err := getPaymentProvider(req.Provider)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	txRef := fmt.Sprintf("topup-%d-%d", claims.UserID, time.Now().UnixNano())
+	if _, err := dbPool.Exec(r.Context(),
+		`INSERT INTO wallet_topups (tx_ref, rider_id, amount, currency, provider, status)
+		 VALUES ($1, $2, $3, $4, $5, 'pending')`,
+		txRef, claims.UserID, req.Amount, cur.Code, provider.Name()); err != nil {
+		respondJSON(w, http.StatusInternalServerError, errorResponse("Database error"))
+		return
+	}
+
+	paymentLink, err := provider.InitiatePayment(PaymentRequest{
+		TxRef:    txRef,
+		Amount:   req.Amount,
+		Currency: cur.Code,
+		Email:    claims.Email,
+		Phone:    req.Phone,
+	})
+	if err != nil {
+		dbPool.Exec(r.Context(),
+			`UPDATE wallet_topups SET status = 'failed', updated_at = NOW() WHERE tx_ref = $1`, txRef)
+		respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"tx_ref":       txRef,
+		"payment_link": paymentLink,
+	}))
+}
+
+func verifyTopUpHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("userClaims").(*Claims)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, errorResponse("invalid auth claims"))
+		return
+	}
+
+	var req struct {
+		TxRef string `json:"tx_ref"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, errorResponse("Invalid request"))
+		return
+	}
+
+	var providerName, status string
+	err := dbPool.QueryRow(r.Context(),
+		`SELECT provider, status FROM wallet_topups WHERE tx_ref = $1 AND rider_id = $2`,
+		req.TxRef, claims.UserID).Scan(&providerName, &status)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, errorResponse("top-up not found"))
+		return
+	}
+
+	if status == "pending" {
+		provider, err := getPaymentProvider(providerName)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+			return
+		}
+		verified, err := provider.VerifyPayment(req.TxRef)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, errorResponse(err.Error()))
+			return
+		}
+		if verified {
+			if err := completeTopUp(r.Context(), req.TxRef); err != nil {
+				log.Printf("Failed to complete top-up %s: %v", req.TxRef, err)
+				respondJSON(w, http.StatusInternalServerError, errorResponse("failed to credit wallet"))
+				return
+			}
+			status = "completed"
+		}
+	}
+
+	respondJSON(w, http.StatusOK, successResponse(map[string]interface{}{
+		"tx_ref":   req.TxRef,
+		"status":   status,
+		"verified": status == "completed",
+	}))
+}
+
+// completeTopUp credits a verified top-up exactly once: the status transition
+// from pending to completed is what guards the wallet credit.
+func completeTopUp(ctx context.Context, txRef string) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var riderID int
+	var amount Money
+	var currency string
+	err = tx.QueryRow(ctx,
+		`UPDATE wallet_topups SET status = 'completed', updated_at = NOW()
+		 WHERE tx_ref = $1 AND status = 'pending'
+		 RETURNING rider_id, amount, currency`,
+		txRef).Scan(&riderID, &amount, &currency)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := creditWallet(ctx, tx, riderID, amount, currency, "topup", "", txRef); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWalletCapture(t *testing.T) {
+	cases := []struct {
+		name                        string
+		balance, held, hold, amount Money
+		want                        Money
+	}{
+		{"hold covers the fare", 10000, 5000, 5000, 4000, 4000},
+		{"free balance tops up the hold", 10000, 5000, 5000, 8000, 8000},
+		{"other rides' holds are protected", 10000, 9000, 3000, 8000, 4000},
+		{"shortfall takes what is spendable", 3000, 3000, 3000, 5000, 3000},
+		{"nothing spendable", 2000, 4000, 1000, 5000, 0},
+	}
+	for _, c := range cases {
+		if got := walletCapture(c.balance, c.held, c.hold, c.amount); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestFareShortfall(t *testing.T) {
+	if got := fareShortfall(8000, 5000); got != 3000 {
+		t.Errorf("Expected a shortfall of 3000, got %v", got)
+	}
+	if got := fareShortfall(8000, 8000); got != 0 {
+		t.Errorf("Expected nothing due on a paid fare, got %v", got)
+	}
+	if got := fareShortfall(8000, 10000); got != 0 {
+		t.Errorf("Expected cash overpayment to leave nothing due, got %v", got)
+	}
+}
+
+func TestPaymentLinkFresh(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	if !paymentLinkFresh(now.Add(-10*time.Minute), now, time.Hour) {
+		t.Errorf("Expected a recent link to be reused")
+	}
+	if paymentLinkFresh(now.Add(-2*time.Hour), now, time.Hour) {
+		t.Errorf("Expected a lapsed link to be replaced")
+	}
+}